@@ -85,3 +85,22 @@ func TestParseGlobalConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestContains(t *testing.T) {
+	values := []string{"leads", "activities"}
+	if !Contains(values, "activities") {
+		t.Error("expected values to contain activities")
+	}
+	if Contains(values, "Leads") || Contains(nil, "leads") {
+		t.Error("expected values to contain the exact values only")
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	if got := SplitList(" 1001, ,1002 ,"); !reflect.DeepEqual(got, []string{"1001", "1002"}) {
+		t.Errorf("expected the trimmed, non-empty values, got %q", got)
+	}
+	if got := SplitList(" "); got != nil {
+		t.Errorf("expected no values, got %q", got)
+	}
+}
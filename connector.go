@@ -16,6 +16,7 @@ package marketo
 
 import (
 	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/destination"
 	"github.com/rustiever/conduit-connector-marketo/source"
 )
 
@@ -23,5 +24,5 @@ import (
 var Connector = sdk.Connector{
 	NewSpecification: Specification,
 	NewSource:        source.NewSource,
-	NewDestination:   nil,
+	NewDestination:   destination.NewDestination,
 }
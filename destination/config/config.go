@@ -0,0 +1,271 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/config"
+	"github.com/rustiever/conduit-connector-marketo/destination/mapping"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+const (
+	// KeyMode selects how the records are written, see ModeLeads, ModeBulkLeads, ModeCustomObject,
+	// ModeProgramMembers, ModeListMembers, ModeCustomActivities and ModeTriggerCampaign.
+	KeyMode = "mode"
+	// KeyPollingPeriod is the period between polls of the status of bulk import jobs.
+	KeyPollingPeriod = "pollingPeriod"
+	// KeyCustomObjectName is the API name of the custom object written when the mode is ModeCustomObject.
+	KeyCustomObjectName = "customObjectName"
+	// KeyDedupeBy selects the fields matching custom object records with existing records, either the dedupe fields
+	// or the id field of the custom object.
+	KeyDedupeBy = "dedupeBy"
+	// KeyListID is the ID of the static list written when the mode is ModeListMembers, for the records which don't
+	// hold a list ID.
+	KeyListID = "listId"
+	// KeyActivityTypeID is the ID of the custom activity type written when the mode is ModeCustomActivities.
+	KeyActivityTypeID = "activityTypeId"
+	// KeyPrimaryAttributeField is the record field holding the value of the primary attribute of custom activities.
+	KeyPrimaryAttributeField = "primaryAttributeField"
+	// KeyAttributes maps the attributes of custom activities to record fields, like "plan:planName, seats", where
+	// an attribute without a field is read from the field with the same name.
+	KeyAttributes = "attributes"
+	// KeyCampaignID is the ID of the smart campaign triggered when the mode is ModeTriggerCampaign, for the records
+	// which don't hold a campaign ID.
+	KeyCampaignID = "campaignId"
+	// KeyTokens maps the My Tokens of triggered campaigns to record fields, like "my.trialEnd:trialEndDate".
+	KeyTokens = "tokens"
+	// KeyFieldMapping maps the fields of the written records to Marketo fields, see mapping.Parse.
+	KeyFieldMapping = "fieldMapping"
+	// KeyLookupField is the lead field matching the written leads with existing leads, like email. It resolves the
+	// leads of the records which aren't leads as well, like program members.
+	KeyLookupField = "lookupField"
+	// KeyDeleteNotFound selects how deletes of leads which don't exist are handled, see DeleteNotFoundIgnore and
+	// DeleteNotFoundFail.
+	KeyDeleteNotFound = "deleteNotFound"
+	// DefaultLookupField is the value assumed for the lookup field when the config omits the lookup field parameter
+	DefaultLookupField = "email"
+	// DefaultPollingPeriod is the value assumed for the polling period when the config omits the polling period
+	// parameter
+	DefaultPollingPeriod = 10 * time.Second
+)
+
+// destination modes
+const (
+	// ModeLeads upserts and deletes leads with the synchronous leads API.
+	ModeLeads = "leads"
+	// ModeBulkLeads upserts leads with bulk import jobs of CSV files.
+	ModeBulkLeads = "bulkLeads"
+	// ModeCustomObject upserts and deletes the records of a custom object.
+	ModeCustomObject = "customObject"
+	// ModeProgramMembers changes the status of leads in programs.
+	ModeProgramMembers = "programMembers"
+	// ModeListMembers adds leads to static lists and removes them.
+	ModeListMembers = "listMembers"
+	// ModeCustomActivities adds custom activities to leads.
+	ModeCustomActivities = "customActivities"
+	// ModeTriggerCampaign triggers smart campaigns for leads.
+	ModeTriggerCampaign = "triggerCampaign"
+)
+
+// supported destination modes
+var modes = []string{ModeLeads, ModeBulkLeads, ModeCustomObject, ModeProgramMembers, ModeListMembers, ModeCustomActivities, ModeTriggerCampaign}
+
+// handling of deletes of leads which don't exist
+const (
+	// DeleteNotFoundIgnore considers leads which don't exist deleted already.
+	DeleteNotFoundIgnore = "ignore"
+	// DeleteNotFoundFail fails the write of deletes of leads which don't exist.
+	DeleteNotFoundFail = "fail"
+)
+
+// DestinationConfig represents destination configuration with Marketo configurations
+type DestinationConfig struct {
+	config.Config
+	Mode                  string
+	PollingPeriod         time.Duration
+	CustomObjectName      string
+	DedupeBy              string
+	ListID                int               // 0 if the records hold their list ID
+	ActivityTypeID        int               // custom activity type written
+	PrimaryAttributeField string            // empty for the field named after the primary attribute
+	Attributes            map[string]string // record field by attribute API name, nil for the fields named after them
+	CampaignID            int               // 0 if the records hold their campaign ID
+	Tokens                map[string]string // record field by token name, like "{{my.trialEnd}}"
+	FieldMapping          mapping.Mapping   // nil to write the records as they are
+	LookupField           string
+	DeleteNotFound        string
+}
+
+// ParseDestinationConfig attempts to parse the configurations into a DestinationConfig struct that Destination could
+// utilize
+func ParseDestinationConfig(ctx context.Context, cfg map[string]string) (DestinationConfig, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "ParseDestinationConfig").Logger()
+	logger.Trace().Msg("Start Parsing the Config")
+
+	globalConfig, err := config.ParseGlobalConfig(ctx, cfg)
+	if err != nil {
+		logger.Error().Stack().Err(err).Msg("Error While Parsing the Global Config")
+		return DestinationConfig{}, fmt.Errorf("parse global config: %w", err)
+	}
+
+	destinationConfig := DestinationConfig{
+		Config:         globalConfig,
+		Mode:           ModeLeads,
+		PollingPeriod:  DefaultPollingPeriod,
+		DedupeBy:       marketoclient.DedupeByDedupeFields,
+		LookupField:    DefaultLookupField,
+		DeleteNotFound: DeleteNotFoundIgnore,
+	}
+
+	if mode := cfg[KeyMode]; mode != "" {
+		if !config.Contains(modes, mode) {
+			return DestinationConfig{}, fmt.Errorf("%q config value should be one of %q, got %q", KeyMode, modes, mode)
+		}
+		destinationConfig.Mode = mode
+	}
+
+	destinationConfig.CustomObjectName = strings.TrimSpace(cfg[KeyCustomObjectName])
+	if destinationConfig.Mode == ModeCustomObject && destinationConfig.CustomObjectName == "" {
+		return DestinationConfig{}, fmt.Errorf("%q config value is required when %q is %q", KeyCustomObjectName, KeyMode, ModeCustomObject)
+	}
+
+	if dedupeBy := cfg[KeyDedupeBy]; dedupeBy != "" {
+		if dedupeBy != marketoclient.DedupeByDedupeFields && dedupeBy != marketoclient.DedupeByIDField {
+			return DestinationConfig{}, fmt.Errorf(
+				"%q config value should be one of %q or %q, got %q",
+				KeyDedupeBy, marketoclient.DedupeByDedupeFields, marketoclient.DedupeByIDField, dedupeBy,
+			)
+		}
+		destinationConfig.DedupeBy = dedupeBy
+	}
+
+	if pollingPeriodString := cfg[KeyPollingPeriod]; pollingPeriodString != "" {
+		destinationConfig.PollingPeriod, err = time.ParseDuration(pollingPeriodString)
+		if err != nil {
+			return DestinationConfig{}, fmt.Errorf(
+				"%q config value should be a valid duration: %w",
+				KeyPollingPeriod, err,
+			)
+		}
+
+		if destinationConfig.PollingPeriod <= 0 {
+			return DestinationConfig{}, fmt.Errorf(
+				"%q config value should be positive, got %s",
+				KeyPollingPeriod,
+				destinationConfig.PollingPeriod,
+			)
+		}
+	}
+
+	if listID := strings.TrimSpace(cfg[KeyListID]); listID != "" {
+		destinationConfig.ListID, err = strconv.Atoi(listID)
+		if err != nil || destinationConfig.ListID <= 0 {
+			return DestinationConfig{}, fmt.Errorf("%q config value should be a list ID, got %q", KeyListID, listID)
+		}
+	}
+
+	if activityTypeID := strings.TrimSpace(cfg[KeyActivityTypeID]); activityTypeID != "" {
+		destinationConfig.ActivityTypeID, err = strconv.Atoi(activityTypeID)
+		if err != nil || destinationConfig.ActivityTypeID <= 0 {
+			return DestinationConfig{}, fmt.Errorf("%q config value should be an activity type ID, got %q", KeyActivityTypeID, activityTypeID)
+		}
+	}
+	if destinationConfig.Mode == ModeCustomActivities && destinationConfig.ActivityTypeID == 0 {
+		return DestinationConfig{}, fmt.Errorf("%q config value is required when %q is %q", KeyActivityTypeID, KeyMode, ModeCustomActivities)
+	}
+	destinationConfig.PrimaryAttributeField = strings.TrimSpace(cfg[KeyPrimaryAttributeField])
+	destinationConfig.Attributes, err = parseMapping(cfg[KeyAttributes])
+	if err != nil {
+		return DestinationConfig{}, fmt.Errorf("%q config value should map attributes to fields: %w", KeyAttributes, err)
+	}
+
+	if campaignID := strings.TrimSpace(cfg[KeyCampaignID]); campaignID != "" {
+		destinationConfig.CampaignID, err = strconv.Atoi(campaignID)
+		if err != nil || destinationConfig.CampaignID <= 0 {
+			return DestinationConfig{}, fmt.Errorf("%q config value should be a campaign ID, got %q", KeyCampaignID, campaignID)
+		}
+	}
+	tokens, err := parseMapping(cfg[KeyTokens])
+	if err != nil {
+		return DestinationConfig{}, fmt.Errorf("%q config value should map tokens to fields: %w", KeyTokens, err)
+	}
+	for token, field := range tokens {
+		if destinationConfig.Tokens == nil {
+			destinationConfig.Tokens = make(map[string]string)
+		}
+		destinationConfig.Tokens[tokenName(token)] = field
+	}
+
+	destinationConfig.FieldMapping, err = mapping.Parse(cfg[KeyFieldMapping])
+	if err != nil {
+		return DestinationConfig{}, fmt.Errorf("%q config value should be a field mapping: %w", KeyFieldMapping, err)
+	}
+
+	if lookupField := strings.TrimSpace(cfg[KeyLookupField]); lookupField != "" {
+		if strings.Contains(lookupField, ",") {
+			return DestinationConfig{}, fmt.Errorf("%q config value should be a single field, got %q", KeyLookupField, lookupField)
+		}
+		destinationConfig.LookupField = lookupField
+	}
+
+	if deleteNotFound := cfg[KeyDeleteNotFound]; deleteNotFound != "" {
+		if deleteNotFound != DeleteNotFoundIgnore && deleteNotFound != DeleteNotFoundFail {
+			return DestinationConfig{}, fmt.Errorf(
+				"%q config value should be one of %q or %q, got %q",
+				KeyDeleteNotFound, DeleteNotFoundIgnore, DeleteNotFoundFail, deleteNotFound,
+			)
+		}
+		destinationConfig.DeleteNotFound = deleteNotFound
+	}
+
+	logger.Trace().Msg("Stop Parsing the Config")
+	return destinationConfig, nil
+}
+
+// returns the fields of a comma separated mapping of names to fields, like "plan:planName, seats", where a name without
+// a field maps to the field with the same name. returns nil for an empty mapping.
+func parseMapping(mapping string) (map[string]string, error) {
+	var fields map[string]string
+	for _, m := range config.SplitList(mapping) {
+		name, field := m, m
+		if i := strings.LastIndex(m, ":"); i != -1 {
+			name, field = strings.TrimSpace(m[:i]), strings.TrimSpace(m[i+1:])
+		}
+		if name == "" || field == "" {
+			return nil, fmt.Errorf("invalid mapping %q", m)
+		}
+		if fields == nil {
+			fields = make(map[string]string)
+		}
+		fields[name] = field
+	}
+	return fields, nil
+}
+
+// returns the name of a My Token, like "{{my.trialEnd}}", given with or without braces and prefix.
+func tokenName(token string) string {
+	token = strings.TrimSuffix(strings.TrimPrefix(token, "{{"), "}}")
+	if !strings.HasPrefix(token, "my.") {
+		token = "my." + token
+	}
+	return "{{" + token + "}}"
+}
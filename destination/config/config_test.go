@@ -0,0 +1,424 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	globalConfig "github.com/rustiever/conduit-connector-marketo/config"
+	"github.com/rustiever/conduit-connector-marketo/destination/mapping"
+)
+
+func TestParseDestinationConfig(t *testing.T) {
+	var configTests = []struct {
+		name        string
+		wantErr     bool
+		in          map[string]string
+		expectedCon DestinationConfig
+	}{
+		{
+			name:        "Empty Input",
+			wantErr:     true,
+			in:          map[string]string{},
+			expectedCon: DestinationConfig{},
+		},
+		{
+			name:    "Default lookup field",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+			},
+			expectedCon: DestinationConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				Mode:           ModeLeads,
+				PollingPeriod:  DefaultPollingPeriod,
+				DedupeBy:       "dedupeFields",
+				LookupField:    "email",
+				DeleteNotFound: DeleteNotFoundIgnore,
+			},
+		},
+		{
+			name:    "Custom lookup field",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"lookupField":    " externalId ",
+			},
+			expectedCon: DestinationConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				Mode:           ModeLeads,
+				PollingPeriod:  DefaultPollingPeriod,
+				DedupeBy:       "dedupeFields",
+				LookupField:    "externalId",
+				DeleteNotFound: DeleteNotFoundIgnore,
+			},
+		},
+		{
+			name:    "Several lookup fields",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"lookupField":    "email,externalId",
+			},
+			expectedCon: DestinationConfig{},
+		},
+		{
+			name:    "Failing deletes of leads not found",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"deleteNotFound": "fail",
+			},
+			expectedCon: DestinationConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				Mode:           ModeLeads,
+				PollingPeriod:  DefaultPollingPeriod,
+				DedupeBy:       "dedupeFields",
+				LookupField:    "email",
+				DeleteNotFound: DeleteNotFoundFail,
+			},
+		},
+		{
+			name:    "Invalid handling of deletes of leads not found",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"deleteNotFound": "skip",
+			},
+			expectedCon: DestinationConfig{},
+		},
+		{
+			name:    "Bulk leads mode",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"mode":           "bulkLeads",
+				"pollingPeriod":  "1m",
+			},
+			expectedCon: DestinationConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				Mode:           ModeBulkLeads,
+				PollingPeriod:  time.Minute,
+				DedupeBy:       "dedupeFields",
+				LookupField:    "email",
+				DeleteNotFound: DeleteNotFoundIgnore,
+			},
+		},
+		{
+			name:    "Program members mode",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"mode":           "programMembers",
+				"lookupField":    "id",
+			},
+			expectedCon: DestinationConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				Mode:           ModeProgramMembers,
+				PollingPeriod:  DefaultPollingPeriod,
+				DedupeBy:       "dedupeFields",
+				LookupField:    "id",
+				DeleteNotFound: DeleteNotFoundIgnore,
+			},
+		},
+		{
+			name:    "List members mode",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"mode":           "listMembers",
+				"listId":         "1001",
+			},
+			expectedCon: DestinationConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				Mode:           ModeListMembers,
+				PollingPeriod:  DefaultPollingPeriod,
+				DedupeBy:       "dedupeFields",
+				ListID:         1001,
+				LookupField:    "email",
+				DeleteNotFound: DeleteNotFoundIgnore,
+			},
+		},
+		{
+			name:    "Invalid list ID",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"mode":           "listMembers",
+				"listId":         "newsletter",
+			},
+			expectedCon: DestinationConfig{},
+		},
+		{
+			name:    "Custom activities mode",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":              "client_id",
+				"clientSecret":          "client_secret",
+				"clientEndpoint":        "https://xxx-xxx-xxx.mktorest.com",
+				"mode":                  "customActivities",
+				"activityTypeId":        "100001",
+				"primaryAttributeField": "feature",
+				"attributes":            "plan:planName, seats",
+			},
+			expectedCon: DestinationConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				Mode:                  ModeCustomActivities,
+				PollingPeriod:         DefaultPollingPeriod,
+				DedupeBy:              "dedupeFields",
+				ActivityTypeID:        100001,
+				PrimaryAttributeField: "feature",
+				Attributes:            map[string]string{"plan": "planName", "seats": "seats"},
+				LookupField:           "email",
+				DeleteNotFound:        DeleteNotFoundIgnore,
+			},
+		},
+		{
+			name:    "Custom activities mode without activity type",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"mode":           "customActivities",
+			},
+			expectedCon: DestinationConfig{},
+		},
+		{
+			name:    "Invalid attribute mapping",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"mode":           "customActivities",
+				"activityTypeId": "100001",
+				"attributes":     "plan:",
+			},
+			expectedCon: DestinationConfig{},
+		},
+		{
+			name:    "Trigger campaign mode",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"mode":           "triggerCampaign",
+				"campaignId":     "2001",
+				"tokens":         "my.trialEnd:trialEndDate, {{my.plan}}:plan, seats",
+			},
+			expectedCon: DestinationConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				Mode:          ModeTriggerCampaign,
+				PollingPeriod: DefaultPollingPeriod,
+				DedupeBy:      "dedupeFields",
+				CampaignID:    2001,
+				Tokens: map[string]string{
+					"{{my.trialEnd}}": "trialEndDate",
+					"{{my.plan}}":     "plan",
+					"{{my.seats}}":    "seats",
+				},
+				LookupField:    "email",
+				DeleteNotFound: DeleteNotFoundIgnore,
+			},
+		},
+		{
+			name:    "Invalid campaign ID",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"mode":           "triggerCampaign",
+				"campaignId":     "-1",
+			},
+			expectedCon: DestinationConfig{},
+		},
+		{
+			name:    "Field mapping",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"fieldMapping":   "email:contact.email; leadSource:'Web'",
+			},
+			expectedCon: DestinationConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				Mode:          ModeLeads,
+				PollingPeriod: DefaultPollingPeriod,
+				DedupeBy:      "dedupeFields",
+				FieldMapping: mapping.Mapping{
+					{Field: "email", Path: []string{"contact", "email"}},
+					{Field: "leadSource", Constant: "Web"},
+				},
+				LookupField:    "email",
+				DeleteNotFound: DeleteNotFoundIgnore,
+			},
+		},
+		{
+			name:    "Invalid field mapping",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"fieldMapping":   "email",
+			},
+			expectedCon: DestinationConfig{},
+		},
+		{
+			name:    "Invalid mode",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"mode":           "opportunities",
+			},
+			expectedCon: DestinationConfig{},
+		},
+		{
+			name:    "Custom object mode",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":         "client_id",
+				"clientSecret":     "client_secret",
+				"clientEndpoint":   "https://xxx-xxx-xxx.mktorest.com",
+				"mode":             "customObject",
+				"customObjectName": " subscription_c ",
+				"dedupeBy":         "idField",
+			},
+			expectedCon: DestinationConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				Mode:             ModeCustomObject,
+				PollingPeriod:    DefaultPollingPeriod,
+				CustomObjectName: "subscription_c",
+				DedupeBy:         "idField",
+				LookupField:      "email",
+				DeleteNotFound:   DeleteNotFoundIgnore,
+			},
+		},
+		{
+			name:    "Custom object mode without custom object name",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"mode":           "customObject",
+			},
+			expectedCon: DestinationConfig{},
+		},
+		{
+			name:    "Invalid dedupe by",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":         "client_id",
+				"clientSecret":     "client_secret",
+				"clientEndpoint":   "https://xxx-xxx-xxx.mktorest.com",
+				"mode":             "customObject",
+				"customObjectName": "subscription_c",
+				"dedupeBy":         "marketoGUID",
+			},
+			expectedCon: DestinationConfig{},
+		},
+		{
+			name:    "Negative polling period",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"pollingPeriod":  "-1m",
+			},
+			expectedCon: DestinationConfig{},
+		},
+	}
+
+	for _, tt := range configTests {
+		t.Run(tt.name, func(t *testing.T) {
+			actualCon, err := ParseDestinationConfig(context.Background(), tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected want error is %v but got parse error as : %v ", tt.wantErr, err)
+			}
+			if !reflect.DeepEqual(tt.expectedCon, actualCon) {
+				t.Errorf("Expected Config %v doesn't match with actual config %v ", tt.expectedCon, actualCon)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package destination
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SpeakData/minimarketo"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	globalConfig "github.com/rustiever/conduit-connector-marketo/config"
+	"github.com/rustiever/conduit-connector-marketo/destination/config"
+	"github.com/rustiever/conduit-connector-marketo/destination/mapping"
+	"github.com/rustiever/conduit-connector-marketo/destination/writer"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// Destination connector
+type Destination struct {
+	sdk.UnimplementedDestination
+	config config.DestinationConfig
+	client marketoclient.Client
+	writer writer.Writer
+	cancel context.CancelFunc // cancels the calls of the client waiting for its rate limiter
+}
+
+func NewDestination() sdk.Destination {
+	return sdk.DestinationWithMiddleware(&Destination{}, sdk.DefaultDestinationMiddleware()...)
+}
+
+// Parameters is a map of named Parameters that describe how to configure the Destination.
+func (d *Destination) Parameters() map[string]sdk.Parameter {
+	return map[string]sdk.Parameter{
+		globalConfig.ClientID: {
+			Required:    true,
+			Default:     "",
+			Description: "The client ID for the Marketo instance.",
+		},
+		globalConfig.ClientSecret: {
+			Required:    true,
+			Default:     "",
+			Description: "The client secret for the Marketo instance.",
+		},
+		globalConfig.ClientEndpoint: {
+			Required:    true,
+			Default:     "",
+			Description: "The endpoint for the Marketo instance.",
+		},
+		config.KeyMode: {
+			Required:    false,
+			Default:     config.ModeLeads,
+			Description: "How the records are written, `leads` with the leads API, `bulkLeads` with bulk import jobs, `customObject` to the records of a custom object, `programMembers` to the status of leads in programs, `listMembers` to the members of static lists, `customActivities` to custom activities of leads or `triggerCampaign` to trigger smart campaigns for leads.",
+		},
+		config.KeyPollingPeriod: {
+			Required:    false,
+			Default:     config.DefaultPollingPeriod.String(),
+			Description: "The period between polls of the status of bulk import jobs.",
+		},
+
+		config.KeyCustomObjectName: {
+			Required:    false,
+			Default:     "",
+			Description: "The API name of the custom object to write, required when the mode is `customObject`.",
+		},
+		config.KeyDedupeBy: {
+			Required:    false,
+			Default:     marketoclient.DedupeByDedupeFields,
+			Description: "The fields matching the written custom object records with existing records, either `dedupeFields` or `idField`.",
+		},
+		config.KeyListID: {
+			Required:    false,
+			Default:     "",
+			Description: "The ID of the static list written when the mode is `listMembers`, for the records which don't hold a list ID.",
+		},
+		config.KeyActivityTypeID: {
+			Required:    false,
+			Default:     "",
+			Description: "The ID of the custom activity type written, required when the mode is `customActivities`.",
+		},
+		config.KeyPrimaryAttributeField: {
+			Required:    false,
+			Default:     "",
+			Description: "The record field holding the primary attribute value of custom activities, the field named after the primary attribute by default.",
+		},
+		config.KeyAttributes: {
+			Required:    false,
+			Default:     "",
+			Description: "Comma separated attributes of custom activities, mapped to record fields like `plan:planName`. Attributes are read from the fields named after them by default.",
+		},
+		config.KeyCampaignID: {
+			Required:    false,
+			Default:     "",
+			Description: "The ID of the smart campaign triggered when the mode is `triggerCampaign`, for the records which don't hold a `campaignId` field.",
+		},
+		config.KeyTokens: {
+			Required:    false,
+			Default:     "",
+			Description: "Comma separated My Tokens of triggered campaigns, mapped to record fields like `my.trialEnd:trialEndDate`.",
+		},
+		config.KeyFieldMapping: {
+			Required:    false,
+			Default:     "",
+			Description: "Semicolon separated rules mapping record fields to Marketo fields, like `firstName:user.first; leadSource:'Web'; score:points|integer`. Records are written as they are by default.",
+		},
+		config.KeyLookupField: {
+			Required:    false,
+			Default:     config.DefaultLookupField,
+			Description: "The lead field matching the written leads with existing leads, or resolving the leads of program members.",
+		},
+		config.KeyDeleteNotFound: {
+			Required:    false,
+			Default:     config.DeleteNotFoundIgnore,
+			Description: "How deletes of leads which don't exist are handled, either ignore or fail.",
+		},
+	}
+}
+
+// Configure parses and stores the configurations
+// returns an error in case of invalid config
+func (d *Destination) Configure(ctx context.Context, cfg map[string]string) error {
+	logger := sdk.Logger(ctx).With().Str("Class", "Destination").Str("Method", "Configure").Logger()
+	logger.Trace().Msg("Starting Configuring the Destination Connector...")
+
+	destinationConfig, err := config.ParseDestinationConfig(ctx, cfg)
+	if err != nil {
+		logger.Error().Stack().Err(err).Msg("Error While parsing the Destination Config")
+		return fmt.Errorf("couldn't parse the destination config: %w", err)
+	}
+	d.config = destinationConfig
+	logger.Trace().Msg("Successfully Configured the Destination Connector")
+	return nil
+}
+
+// Open prepares the plugin to start writing records
+func (d *Destination) Open(ctx context.Context) error {
+	logger := sdk.Logger(ctx).With().Str("Class", "Destination").Str("Method", "Open").Logger()
+	logger.Trace().Msg("Starting Open the Destination Connector...")
+
+	clientConfig := minimarketo.ClientConfig{
+		ID:       d.config.ClientID,
+		Secret:   d.config.ClientSecret,
+		Endpoint: d.config.ClientEndpoint,
+		Debug:    false,
+	}
+	client, err := marketoclient.NewClient(clientConfig)
+	if err != nil {
+		logger.Error().Stack().Err(err).Msg("Error While Creating the Marketo Client")
+		return fmt.Errorf("couldn't create the marketo client: %w", err)
+	}
+	var clientCtx context.Context
+	clientCtx, d.cancel = context.WithCancel(context.Background())
+	d.client = client.WithRateLimit(clientCtx, marketoclient.NewRateLimiter())
+	var w writer.Writer
+	switch d.config.Mode {
+	case config.ModeBulkLeads:
+		w = writer.NewBulkLeadWriter(&d.client, d.config.ClientEndpoint, d.config.LookupField, d.config.PollingPeriod)
+	case config.ModeCustomObject:
+		w, err = writer.NewCustomObjectWriter(&d.client, d.config.CustomObjectName, d.config.DedupeBy)
+	case config.ModeProgramMembers:
+		w = writer.NewProgramMemberWriter(&d.client, d.config.LookupField)
+	case config.ModeListMembers:
+		w = writer.NewListMemberWriter(&d.client, d.config.ListID)
+	case config.ModeCustomActivities:
+		w, err = writer.NewCustomActivityWriter(&d.client, d.config.ActivityTypeID, d.config.PrimaryAttributeField, d.config.Attributes)
+	case config.ModeTriggerCampaign:
+		w = writer.NewCampaignWriter(&d.client, d.config.CampaignID, d.config.Tokens)
+	default:
+		w = writer.NewLeadWriter(&d.client, d.config.LookupField, d.config.DeleteNotFound == config.DeleteNotFoundIgnore)
+	}
+	if err != nil {
+		logger.Error().Stack().Err(err).Msg("Error While Creating the Writer")
+		return fmt.Errorf("couldn't create the %s writer: %w", d.config.Mode, err)
+	}
+	if len(d.config.FieldMapping) > 0 {
+		fieldMapping, err := d.validateMapping(w)
+		if err != nil {
+			logger.Error().Stack().Err(err).Msg("Error While Validating the Field Mapping")
+			return fmt.Errorf("couldn't validate the field mapping: %w", err)
+		}
+		w = writer.NewMappedWriter(w, fieldMapping)
+	}
+	d.writer = w
+	logger.Trace().Msg("Successfully Opened the Destination Connector")
+	return nil
+}
+
+// returns the field mapping validated against the description of the object written by given writer, for the modes
+// writing the fields of leads or custom objects, or against the fields read by the writers of the other modes.
+func (d *Destination) validateMapping(w writer.Writer) (mapping.Mapping, error) {
+	var description marketoclient.ObjectDescription
+	var err error
+	switch d.config.Mode {
+	case config.ModeLeads, config.ModeBulkLeads:
+		description, err = d.client.DescribeLeads()
+	case config.ModeCustomObject:
+		description, err = d.client.DescribeCustomObject(d.config.CustomObjectName)
+	default:
+		reader, ok := w.(writer.FieldReader)
+		if !ok {
+			return nil, fmt.Errorf("field mappings aren't supported in mode %s", d.config.Mode)
+		}
+		description.Fields = reader.Fields()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the fields of %s: %w", d.config.Mode, err)
+	}
+	return d.config.FieldMapping.Validate(description.Fields)
+}
+
+// Write writes the records to the Marketo Instance, returns the number of records written
+func (d *Destination) Write(ctx context.Context, records []sdk.Record) (int, error) {
+	logger := sdk.Logger(ctx).With().Str("Class", "Destination").Str("Method", "Write").Logger()
+	logger.Trace().Msgf("Writing %d records...", len(records))
+
+	n, err := d.writer.Write(ctx, records)
+	if err != nil {
+		logger.Error().Stack().Err(err).Msgf("Error while writing the records, %d of %d written", n, len(records))
+		return n, fmt.Errorf("couldn't write the records: %w", err)
+	}
+	return n, nil
+}
+
+func (d *Destination) Teardown(ctx context.Context) error {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	return nil
+}
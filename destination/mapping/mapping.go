@@ -0,0 +1,353 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/config"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// types the values of mapped fields are converted to
+const (
+	TypeString   = "string"
+	TypeInteger  = "integer"
+	TypeFloat    = "float"
+	TypeBoolean  = "boolean"
+	TypeDate     = "date"     // formatted like 2006-01-02
+	TypeDateTime = "datetime" // formatted like 2006-01-02T15:04:05Z, in UTC
+)
+
+// supported types
+var types = []string{TypeString, TypeInteger, TypeFloat, TypeBoolean, TypeDate, TypeDateTime}
+
+// types of the marketo data types, the values of the other data types are written as they are
+var dataTypes = map[string]string{
+	"string":    TypeString,
+	"text":      TypeString,
+	"email":     TypeString,
+	"phone":     TypeString,
+	"url":       TypeString,
+	"integer":   TypeInteger,
+	"score":     TypeInteger,
+	"percent":   TypeInteger,
+	"reference": TypeInteger,
+	"float":     TypeFloat,
+	"currency":  TypeFloat,
+	"boolean":   TypeBoolean,
+	"date":      TypeDate,
+	"datetime":  TypeDateTime,
+}
+
+// layouts of the dates which are parsed without a layout
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// Rule maps a record field, or a constant, to a Marketo field.
+type Rule struct {
+	Field    string   // Marketo field written
+	Path     []string // path of the record field read, through nested fields, nil for a constant
+	Constant string   // value written if Path is nil
+	Type     string   // type the value is converted to, empty to keep the value
+	Layout   string   // layout of the dates read from strings, empty for RFC 3339 dates and the like
+}
+
+// Mapping maps records to the fields of Marketo records, the fields which aren't mapped are left out.
+type Mapping []Rule
+
+// Parse returns the mapping of semicolon separated rules, like "firstName:user.first; leadSource:'Web'", where
+// every rule maps a Marketo field to a record field path, or to a constant in single quotes. A rule can convert the
+// value to a type, like "score:points|integer", and read dates with a Go time layout, like
+// "birthday:dob|date:02/01/2006". returns nil for no rules.
+func Parse(rules string) (Mapping, error) {
+	var mapping Mapping
+	var fields = make(map[string]bool)
+	for _, r := range strings.Split(rules, ";") {
+		if strings.TrimSpace(r) == "" {
+			continue
+		}
+		rule, err := parseRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %w", strings.TrimSpace(r), err)
+		}
+		if fields[rule.Field] {
+			return nil, fmt.Errorf("field %s is mapped twice", rule.Field)
+		}
+		fields[rule.Field] = true
+		mapping = append(mapping, rule)
+	}
+	return mapping, nil
+}
+
+// returns the rule of a "field:source|type:layout" string.
+func parseRule(r string) (Rule, error) {
+	i := strings.Index(r, ":")
+	if i == -1 {
+		return Rule{}, fmt.Errorf("rule should map a field to a source, like field:source")
+	}
+	rule := Rule{Field: strings.TrimSpace(r[:i])}
+	source := strings.TrimSpace(r[i+1:])
+	if rule.Field == "" {
+		return Rule{}, fmt.Errorf("rule has no field")
+	}
+
+	var conversion string
+	if strings.HasPrefix(source, "'") {
+		end := strings.Index(source[1:], "'")
+		if end == -1 {
+			return Rule{}, fmt.Errorf("constant has no closing quote")
+		}
+		rule.Constant, conversion = source[1:end+1], strings.TrimSpace(source[end+2:])
+		if conversion != "" && !strings.HasPrefix(conversion, "|") {
+			return Rule{}, fmt.Errorf("unexpected %q after the constant", conversion)
+		}
+		conversion = strings.TrimPrefix(conversion, "|")
+	} else {
+		if j := strings.Index(source, "|"); j != -1 {
+			source, conversion = strings.TrimSpace(source[:j]), source[j+1:]
+		}
+		if source == "" {
+			return Rule{}, fmt.Errorf("rule has no source")
+		}
+		for _, name := range strings.Split(source, ".") {
+			if name == "" {
+				return Rule{}, fmt.Errorf("path %s has an empty field", source)
+			}
+			rule.Path = append(rule.Path, name)
+		}
+	}
+
+	if conversion != "" {
+		rule.Type = strings.TrimSpace(conversion)
+		if j := strings.Index(conversion, ":"); j != -1 {
+			rule.Type, rule.Layout = strings.TrimSpace(conversion[:j]), conversion[j+1:]
+		}
+		if !config.Contains(types, rule.Type) {
+			return Rule{}, fmt.Errorf("type should be one of %q, got %q", types, rule.Type)
+		}
+		if rule.Layout != "" && rule.Type != TypeDate && rule.Type != TypeDateTime {
+			return Rule{}, fmt.Errorf("only dates have a layout")
+		}
+		if err := rule.checkConstant(); err != nil {
+			return Rule{}, err
+		}
+	}
+	return rule, nil
+}
+
+// Validate returns the mapping checked against given fields of the described Marketo object, with the rules without
+// a type converting their values to the type of their field.
+func (m Mapping) Validate(fields []marketoclient.ObjectField) (Mapping, error) {
+	var dataTypeOf = make(map[string]string, len(fields))
+	for _, field := range fields {
+		dataTypeOf[field.Name] = field.DataType
+	}
+	var unknown []string
+	var validated = make(Mapping, 0, len(m))
+	for _, rule := range m {
+		dataType, ok := dataTypeOf[rule.Field]
+		if !ok {
+			unknown = append(unknown, rule.Field)
+			continue
+		}
+		if rule.Type == "" {
+			rule.Type = dataTypes[dataType]
+			if err := rule.checkConstant(); err != nil {
+				return nil, fmt.Errorf("invalid constant of field %s: %w", rule.Field, err)
+			}
+		}
+		validated = append(validated, rule)
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("fields %s don't exist", strings.Join(unknown, ", "))
+	}
+	return validated, nil
+}
+
+// Apply returns the Marketo fields mapped from given record fields. The fields whose path doesn't exist in the record
+// are left out, null values are written as they are.
+func (m Mapping) Apply(data map[string]interface{}) (map[string]interface{}, error) {
+	var mapped = make(map[string]interface{}, len(m))
+	for _, rule := range m {
+		var value interface{} = rule.Constant
+		if rule.Path != nil {
+			var ok bool
+			value, ok = lookup(data, rule.Path)
+			if !ok {
+				continue
+			}
+		}
+		converted, err := rule.convert(value)
+		if err != nil {
+			return nil, fmt.Errorf("error mapping field %s %w", rule.Field, err)
+		}
+		mapped[rule.Field] = converted
+	}
+	return mapped, nil
+}
+
+// returns an error if the constant of the rule can't be converted to its type.
+func (r Rule) checkConstant() error {
+	if r.Path != nil {
+		return nil
+	}
+	_, err := r.convert(r.Constant)
+	return err
+}
+
+// returns the value at given path of given fields, and whether it exists.
+func lookup(data map[string]interface{}, path []string) (interface{}, bool) {
+	var value interface{} = data
+	for _, name := range path {
+		var fields map[string]interface{}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			fields = v
+		case sdk.StructuredData:
+			fields = v
+		default:
+			return nil, false
+		}
+		var ok bool
+		value, ok = fields[name]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// returns given value converted to the type of the rule.
+func (r Rule) convert(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch r.Type {
+	case TypeString:
+		return toString(value), nil
+	case TypeInteger:
+		f, err := toFloat(value)
+		if err != nil || f != math.Trunc(f) {
+			return nil, fmt.Errorf("value %v should be an integer", value)
+		}
+		return int64(f), nil
+	case TypeFloat:
+		f, err := toFloat(value)
+		if err != nil {
+			return nil, fmt.Errorf("value %v should be a number", value)
+		}
+		return f, nil
+	case TypeBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("value %q should be a boolean", v)
+			}
+			return b, nil
+		}
+		f, err := toFloat(value)
+		if err != nil || (f != 0 && f != 1) {
+			return nil, fmt.Errorf("value %v should be a boolean", value)
+		}
+		return f == 1, nil
+	case TypeDate:
+		t, err := r.toTime(value)
+		if err != nil {
+			return nil, err
+		}
+		return t.Format("2006-01-02"), nil
+	case TypeDateTime:
+		t, err := r.toTime(value)
+		if err != nil {
+			return nil, err
+		}
+		return t.UTC().Format(time.RFC3339), nil
+	default:
+		return value, nil
+	}
+}
+
+// returns the time of a value, which is a date string or a unix timestamp in seconds.
+func (r Rule) toTime(value interface{}) (time.Time, error) {
+	s, ok := value.(string)
+	if !ok {
+		f, err := toFloat(value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("value %v should be a date", value)
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	s = strings.TrimSpace(s)
+	if r.Layout != "" {
+		t, err := time.Parse(r.Layout, s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("value %q should be a date like %q", s, r.Layout)
+		}
+		return t, nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("value %q should be a date like %q", s, time.RFC3339)
+}
+
+// returns the number of a value, which is a number or a string holding one.
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	default:
+		return 0, fmt.Errorf("%v isn't a number", value)
+	}
+}
+
+// returns the string of a value, numbers without exponent and the other non-string values in JSON.
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	}
+}
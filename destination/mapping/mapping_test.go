@@ -0,0 +1,162 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mapping
+
+import (
+	"reflect"
+	"testing"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    Mapping
+		wantErr bool
+	}{
+		{
+			name: "No rules",
+			in:   " ; ",
+			want: nil,
+		},
+		{
+			name: "Paths, constants and types",
+			in:   "firstName: user.first ; leadSource:'Web: signup' ; score:points|integer; birthday:dob|date:02/01/2006",
+			want: Mapping{
+				{Field: "firstName", Path: []string{"user", "first"}},
+				{Field: "leadSource", Constant: "Web: signup"},
+				{Field: "score", Path: []string{"points"}, Type: TypeInteger},
+				{Field: "birthday", Path: []string{"dob"}, Type: TypeDate, Layout: "02/01/2006"},
+			},
+		},
+		{
+			name:    "Missing source",
+			in:      "firstName",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown type",
+			in:      "firstName:name|uuid",
+			wantErr: true,
+		},
+		{
+			name:    "Layout of a number",
+			in:      "score:points|integer:02/01/2006",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid constant",
+			in:      "score:'high'|integer",
+			wantErr: true,
+		},
+		{
+			name:    "Field mapped twice",
+			in:      "firstName:first; firstName:name",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMapping_Validate(t *testing.T) {
+	fields := []marketoclient.ObjectField{
+		{Name: "email", DataType: "email"},
+		{Name: "score", DataType: "integer"},
+		{Name: "unsubscribed", DataType: "boolean"},
+	}
+	mapping, err := Parse("email:contact.email; score:points; unsubscribed:'true'|string")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := mapping.Validate(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{TypeString, TypeInteger, TypeString}
+	for i, rule := range got {
+		if rule.Type != want[i] {
+			t.Errorf("expected field %s converted to %s, got %q", rule.Field, want[i], rule.Type)
+		}
+	}
+
+	mapping, _ = Parse("score:'high'; email:email")
+	if _, err = mapping.Validate(fields); err == nil {
+		t.Errorf("expected an error for a constant which isn't an integer")
+	}
+	mapping, _ = Parse("firstName:first; email:email; company:org")
+	if _, err = mapping.Validate(fields); err == nil || err.Error() != "fields company, firstName don't exist" {
+		t.Errorf("expected an error for the unknown fields, got %v", err)
+	}
+}
+
+func TestMapping_Apply(t *testing.T) {
+	mapping, err := Parse("email:contact.email; firstName:name|string; score:points|integer; rate:rate|float; " +
+		"unsubscribed:optOut|boolean; birthday:dob|date:02/01/2006; signedUpAt:signup|datetime; leadSource:'Web'; " +
+		"phone:contact.phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := mapping.Apply(map[string]interface{}{
+		"contact": sdk.StructuredData{"email": "jane@example.com"},
+		"name":    float64(42),
+		"points":  "12",
+		"rate":    float64(1.5),
+		"optOut":  float64(0),
+		"dob":     "31/12/1990",
+		"signup":  "2022-08-01T10:00:00+02:00",
+		"unused":  "value",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"email":        "jane@example.com",
+		"firstName":    "42",
+		"score":        int64(12),
+		"rate":         1.5,
+		"unsubscribed": false,
+		"birthday":     "1990-12-31",
+		"signedUpAt":   "2022-08-01T08:00:00Z",
+		"leadSource":   "Web",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply() = %v, want %v", got, want)
+	}
+
+	_, err = mapping.Apply(map[string]interface{}{"points": float64(1.5)})
+	if err == nil {
+		t.Errorf("expected an error for a value which isn't an integer")
+	}
+	got, err = mapping.Apply(map[string]interface{}{"signup": float64(1659340800), "name": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["signedUpAt"] != "2022-08-01T08:00:00Z" || got["firstName"] != nil {
+		t.Errorf("expected unix timestamps converted and null values kept, got %v", got)
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// BulkLeadWriter writes records to leads with bulk import jobs. The create, update and snapshot records of a write are
+// buffered into CSV files of at most MaxImportFileSize bytes, every file is imported by a job creating or updating the
+// leads matching their lookup field.
+type BulkLeadWriter struct {
+	client        *marketoclient.Client // marketo client
+	endpoint      string                // marketo endpoint, for the calls which aren't made by the minimarketo client
+	lookupField   string                // lead field matching records with existing leads
+	pollingPeriod time.Duration         // period between polls of the status of import jobs
+}
+
+// returns NewBulkLeadWriter which imports leads matched by given lookup field, polling the status of the import jobs
+// every pollingPeriod.
+func NewBulkLeadWriter(client *marketoclient.Client, endpoint string, lookupField string, pollingPeriod time.Duration) *BulkLeadWriter {
+	return &BulkLeadWriter{
+		client:        client,
+		endpoint:      endpoint,
+		lookupField:   lookupField,
+		pollingPeriod: pollingPeriod,
+	}
+}
+
+// writes given records with import jobs, stopping at the first record which couldn't be written. Deletes are not
+// supported by import jobs. returns the number of records written.
+func (w *BulkLeadWriter) Write(ctx context.Context, records []sdk.Record) (int, error) {
+	return writeBatches(ctx, records, len(records), w.importLeads, w.delete)
+}
+
+// deletes are not supported by import jobs.
+func (w *BulkLeadWriter) delete(ctx context.Context, records []sdk.Record) (int, error) {
+	return 0, fmt.Errorf("%w %s of record %s, import jobs don't delete leads", ErrUnsupportedOperation, records[0].Operation, records[0].Key.Bytes())
+}
+
+// imports the leads of given records, in as many files as needed.
+func (w *BulkLeadWriter) importLeads(ctx context.Context, records []sdk.Record) (int, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "importLeads").Logger()
+	logger.Trace().Msgf("Importing %d leads", len(records))
+
+	var leads = make([]map[string]interface{}, 0, len(records))
+	var readErr error
+	for _, r := range records {
+		lead, err := recordData(r)
+		if err != nil {
+			readErr = err
+			break
+		}
+		leads = append(leads, lead)
+	}
+	written, err := w.importFiles(ctx, records[:len(leads)], leads)
+	if err != nil {
+		return written, err
+	}
+	return written, readErr
+}
+
+// imports given leads, read from given records, one file after the other. Importing stops at the first file with
+// failed rows.
+func (w *BulkLeadWriter) importFiles(ctx context.Context, records []sdk.Record, leads []map[string]interface{}) (int, error) {
+	if len(leads) == 0 {
+		return 0, nil
+	}
+	columns := leadColumns(leads)
+	header := csvRow(columns)
+	var rows = make([][]byte, 0, len(leads))
+	var sizeErr error
+	for i, lead := range leads {
+		var values = make([]string, 0, len(columns))
+		for _, column := range columns {
+			values = append(values, formatValue(lead[column]))
+		}
+		row := csvRow(values)
+		if len(header)+len(row) > marketoclient.MaxImportFileSize {
+			sizeErr = fmt.Errorf("lead of record %s doesn't fit in an import file", records[i].Key.Bytes())
+			break
+		}
+		rows = append(rows, row)
+	}
+	records, leads = records[:len(rows)], leads[:len(rows)]
+
+	start := 0
+	for start < len(rows) {
+		file := bytes.NewBuffer(append([]byte(nil), header...))
+		end := start
+		for end < len(rows) && file.Len()+len(rows[end]) <= marketoclient.MaxImportFileSize {
+			file.Write(rows[end])
+			end++
+		}
+		failures, err := w.importFile(ctx, records[start:end], leads[start:end], file.Bytes())
+		if err != nil {
+			return start, err
+		}
+		if len(failures) > 0 {
+			var fileFailures = make(map[int]string, len(failures))
+			for i, reason := range failures {
+				fileFailures[start+i] = reason
+			}
+			return failuresError(ctx, records[:end], fileFailures)
+		}
+		start = end
+	}
+	return len(records), sizeErr
+}
+
+// imports given file of given leads and waits for the import job. returns the reasons of the failed rows, by index of
+// their lead.
+func (w *BulkLeadWriter) importFile(ctx context.Context, records []sdk.Record, leads []map[string]interface{}, file []byte) (map[int]string, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "importFile").Logger()
+
+	job, err := w.client.ImportLeads(ctx, w.endpoint, w.lookupField, file)
+	if err != nil {
+		return nil, fmt.Errorf("error creating import job %w", err)
+	}
+	logger.Trace().Msgf("Created import job %d of %d leads", job.BatchID, len(leads))
+	job, err = w.waitForImport(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.NumOfRowsWithWarning > 0 {
+		warnings, err := w.client.ImportWarnings(ctx, w.endpoint, job.BatchID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting warnings of import job %d %w", job.BatchID, err)
+		}
+		rows, unmatched, err := w.matchRows(leads, warnings, marketoclient.ImportWarningColumn)
+		if err != nil {
+			return nil, fmt.Errorf("error reading warnings of import job %d %w", job.BatchID, err)
+		}
+		for i, reason := range rows {
+			logger.Warn().Msgf("Record %s was imported with warnings: %s", records[i].Key.Bytes(), reason)
+		}
+		for _, reason := range unmatched {
+			logger.Warn().Msgf("A record of import job %d was imported with warnings, %s", job.BatchID, reason)
+		}
+	}
+	if job.NumOfRowsFailed == 0 {
+		return nil, nil
+	}
+	failures, err := w.client.ImportFailures(ctx, w.endpoint, job.BatchID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting failures of import job %d %w", job.BatchID, err)
+	}
+	rows, unmatched, err := w.matchRows(leads, failures, marketoclient.ImportFailureColumn)
+	if err != nil {
+		return nil, fmt.Errorf("error reading failures of import job %d %w", job.BatchID, err)
+	}
+	// the records of failures which can't be matched are unknown, so none of the records of the file is written.
+	if len(unmatched) > 0 {
+		return nil, fmt.Errorf("import job %d has failed rows which can't be matched with their records, %s", job.BatchID, strings.Join(unmatched, "; "))
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%d rows of import job %d failed, but its failures file has no rows", job.NumOfRowsFailed, job.BatchID)
+	}
+	return rows, nil
+}
+
+// polls the status of given import job until it's complete. returns the completed job.
+func (w *BulkLeadWriter) waitForImport(ctx context.Context, job marketoclient.ImportResult) (marketoclient.ImportResult, error) {
+	ticker := time.NewTicker(w.pollingPeriod)
+	defer ticker.Stop()
+	for {
+		switch job.Status {
+		case marketoclient.ImportStatusComplete:
+			return job, nil
+		case marketoclient.ImportStatusFailed:
+			return job, fmt.Errorf("import job %d failed: %s", job.BatchID, job.Message)
+		}
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-ticker.C:
+		}
+		status, err := w.client.StatusOfImport(job.BatchID)
+		if err != nil {
+			return job, fmt.Errorf("error getting status of import job %d %w", job.BatchID, err)
+		}
+		job = status
+	}
+}
+
+// returns the reasons, from given reason column, of the rows of a failures or warnings file by index of the lead of
+// the row, and the rows which can't be matched with a lead, with their reasons. Rows are matched with leads by lookup
+// field, in the order of the leads.
+func (w *BulkLeadWriter) matchRows(leads []map[string]interface{}, file []byte, reasonColumn string) (map[int]string, []string, error) {
+	rows, err := csv.NewReader(bytes.NewReader(file)).ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rows) < 2 {
+		return nil, nil, nil
+	}
+	lookupIndex, reasonIndex := -1, -1
+	for i, column := range rows[0] {
+		switch column {
+		case w.lookupField:
+			lookupIndex = i
+		case reasonColumn:
+			reasonIndex = i
+		}
+	}
+	if reasonIndex == -1 {
+		return nil, nil, fmt.Errorf("file has no %q column", reasonColumn)
+	}
+
+	var matched = make(map[int]string)
+	var unmatched []string
+	next := 0 // rows are in the order of the leads
+	for _, row := range rows[1:] {
+		i := -1
+		if lookupIndex != -1 {
+			for j := next; j < len(leads); j++ {
+				if strings.EqualFold(formatValue(leads[j][w.lookupField]), row[lookupIndex]) {
+					i = j
+					break
+				}
+			}
+		}
+		if i == -1 {
+			unmatched = append(unmatched, fmt.Sprintf("row %s: %s", strings.Join(row, ","), row[reasonIndex]))
+			continue
+		}
+		matched[i] = row[reasonIndex]
+		next = i + 1
+	}
+	return matched, unmatched, nil
+}
+
+// returns the sorted fields of given leads, with the fields of every lead.
+func leadColumns(leads []map[string]interface{}) []string {
+	var fields = make(map[string]bool)
+	for _, lead := range leads {
+		for field := range lead {
+			fields[field] = true
+		}
+	}
+	var columns = make([]string, 0, len(fields))
+	for field := range fields {
+		columns = append(columns, field)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// returns given values encoded as a CSV row.
+func csvRow(values []string) []byte {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	_ = writer.Write(values) // writing to a buffer doesn't fail
+	writer.Flush()
+	return buf.Bytes()
+}
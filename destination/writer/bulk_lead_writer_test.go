@@ -0,0 +1,178 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// handles the import of a file by a job which completes at the second status poll, with given results.
+func handleImport(t *testing.T, f *marketotest.Server, result marketoclient.ImportResult, files map[string]string) *[]string {
+	var mu sync.Mutex
+	var imported []string
+	polls := 0
+	f.Handle("/bulk/v1/leads.json", func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("expected a file, got %v", err)
+			return
+		}
+		raw, _ := io.ReadAll(file)
+		mu.Lock()
+		imported = append(imported, string(raw))
+		mu.Unlock()
+		marketotest.WriteResult(w, []marketoclient.ImportResult{{BatchID: 1, Status: marketoclient.ImportStatusQueued}}, "", false)
+	})
+	f.Handle("/bulk/v1/leads/batch/1.json", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		polls++
+		status := result
+		if polls == 1 {
+			status = marketoclient.ImportResult{BatchID: 1, Status: marketoclient.ImportStatusImporting}
+		}
+		mu.Unlock()
+		marketotest.WriteResult(w, []marketoclient.ImportResult{status}, "", false)
+	})
+	for path, content := range files {
+		content := content
+		f.Handle(path, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(content))
+		})
+	}
+	return &imported
+}
+
+func TestBulkLeadWriter_Import(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	imported := handleImport(t, f, marketoclient.ImportResult{
+		BatchID:              1,
+		Status:               marketoclient.ImportStatusComplete,
+		NumOfLeadsProcessed:  2,
+		NumOfRowsWithWarning: 1,
+	}, map[string]string{
+		"/bulk/v1/leads/batch/1/warnings.json": "company,email,score,Import Warning Reason\nAcme,b@example.com,,invalid value\n",
+	})
+	records := []sdk.Record{
+		testRecord(sdk.OperationCreate, "a", sdk.StructuredData{"email": "a@example.com", "score": float64(12)}),
+		{Operation: sdk.OperationSnapshot, Key: sdk.RawData("b"), Payload: sdk.Change{After: sdk.RawData(`{"email":"b@example.com","company":"Acme, Inc."}`)}},
+	}
+
+	n, err := NewBulkLeadWriter(f.Client(t), f.URL, "email", time.Millisecond).Write(ctx, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(records) {
+		t.Errorf("expected %d records written, got %d", len(records), n)
+	}
+	expected := "company,email,score\n,a@example.com,12\n\"Acme, Inc.\",b@example.com,\n"
+	if len(*imported) != 1 || (*imported)[0] != expected {
+		t.Errorf("expected file %q imported, got %q", expected, *imported)
+	}
+	if query := f.RequestsTo("/bulk/v1/leads.json")[0].URL.RawQuery; query != "format=csv&lookupField=email" {
+		t.Errorf("unexpected import query %s", query)
+	}
+}
+
+func TestBulkLeadWriter_Failures(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	handleImport(t, f, marketoclient.ImportResult{
+		BatchID:             1,
+		Status:              marketoclient.ImportStatusComplete,
+		NumOfLeadsProcessed: 1,
+		NumOfRowsFailed:     2,
+	}, map[string]string{
+		"/bulk/v1/leads/batch/1/failures.json": "email,score,Import Failure Reason\n" +
+			"B@example.com,abc,Invalid value for field score\nc@example.com,,Missing required field\n",
+	})
+	records := []sdk.Record{
+		testRecord(sdk.OperationCreate, "a", sdk.StructuredData{"email": "a@example.com"}),
+		testRecord(sdk.OperationCreate, "b", sdk.StructuredData{"email": "b@example.com", "score": "abc"}),
+		testRecord(sdk.OperationCreate, "c", sdk.StructuredData{"email": "c@example.com"}),
+	}
+
+	n, err := NewBulkLeadWriter(f.Client(t), f.URL, "email", time.Millisecond).Write(ctx, records)
+	if n != 1 {
+		t.Errorf("expected the records before the first failed record written, got %d", n)
+	}
+	if err == nil || !strings.Contains(err.Error(), "record b: Invalid value for field score") ||
+		!strings.Contains(err.Error(), "record c: Missing required field") {
+		t.Errorf("expected an error listing the failed records, got %v", err)
+	}
+}
+
+func TestBulkLeadWriter_UnmatchedFailures(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	handleImport(t, f, marketoclient.ImportResult{
+		BatchID:             1,
+		Status:              marketoclient.ImportStatusComplete,
+		NumOfLeadsProcessed: 1,
+		NumOfRowsFailed:     1,
+	}, map[string]string{
+		"/bulk/v1/leads/batch/1/failures.json": "email,Import Failure Reason\nunknown@example.com,Invalid email\n",
+	})
+	records := []sdk.Record{
+		testRecord(sdk.OperationCreate, "a", sdk.StructuredData{"email": "a@example.com"}),
+		testRecord(sdk.OperationCreate, "b", sdk.StructuredData{"email": "b@example.com"}),
+	}
+
+	n, err := NewBulkLeadWriter(f.Client(t), f.URL, "email", time.Millisecond).Write(ctx, records)
+	if n != 0 || err == nil || !strings.Contains(err.Error(), "can't be matched with their records, row unknown@example.com,Invalid email") {
+		t.Errorf("expected no record of the file written and the unmatched failures, got %d and %v", n, err)
+	}
+}
+
+func TestBulkLeadWriter_FailedJob(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	handleImport(t, f, marketoclient.ImportResult{
+		BatchID: 1,
+		Status:  marketoclient.ImportStatusFailed,
+		Message: "Import failed",
+	}, nil)
+	records := []sdk.Record{
+		testRecord(sdk.OperationCreate, "a", sdk.StructuredData{"email": "a@example.com"}),
+		{Operation: sdk.OperationDelete, Key: sdk.RawData("b")},
+	}
+
+	n, err := NewBulkLeadWriter(f.Client(t), f.URL, "email", time.Millisecond).Write(ctx, records)
+	if n != 0 || err == nil || !strings.Contains(err.Error(), "import job 1 failed: Import failed") {
+		t.Errorf("expected no record written and the failure of the job, got %d and %v", n, err)
+	}
+}
+
+func TestBulkLeadWriter_Delete(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	records := []sdk.Record{{Operation: sdk.OperationDelete, Key: sdk.RawData("1")}}
+
+	n, err := NewBulkLeadWriter(f.Client(t), f.URL, "email", time.Millisecond).Write(ctx, records)
+	if n != 0 || !errors.Is(err, ErrUnsupportedOperation) {
+		t.Errorf("expected deletes unsupported, got %d and %v", n, err)
+	}
+}
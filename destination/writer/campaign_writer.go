@@ -0,0 +1,215 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/SpeakData/minimarketo"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// fields of the records written by CampaignWriter
+const (
+	fieldCampaignID = "campaignId"
+	fieldLeadIDs    = "leadIds"
+)
+
+// CampaignWriter writes records to smart campaign triggers. Create, update and snapshot records hold the lead id, or
+// the lead ids, for which the campaign is triggered, the campaign id, which defaults to the configured campaign, and
+// the fields of the My Token values of the campaign.
+type CampaignWriter struct {
+	client     *marketoclient.Client // marketo client
+	campaignID int                   // campaign of the records which don't hold a campaign id, 0 if none
+	tokens     []campaignToken       // My Tokens of the campaigns, sorted by name
+}
+
+// My Token of a campaign and the record field holding its value
+type campaignToken struct {
+	name  string // name of the token, like "{{my.trialEnd}}"
+	field string
+}
+
+// returns NewCampaignWriter which triggers smart campaigns, given campaignID is the campaign of the records which
+// don't hold one, if not 0. tokens maps the My Tokens of the campaigns to record fields.
+func NewCampaignWriter(client *marketoclient.Client, campaignID int, tokens map[string]string) *CampaignWriter {
+	var campaignTokens = make([]campaignToken, 0, len(tokens))
+	for name, field := range tokens {
+		campaignTokens = append(campaignTokens, campaignToken{name: name, field: field})
+	}
+	sort.Slice(campaignTokens, func(i, j int) bool { return campaignTokens[i].name < campaignTokens[j].name })
+	return &CampaignWriter{
+		client:     client,
+		campaignID: campaignID,
+		tokens:     campaignTokens,
+	}
+}
+
+// returns the campaign, lead and token fields read from the records.
+func (w *CampaignWriter) Fields() []marketoclient.ObjectField {
+	var fields = []marketoclient.ObjectField{
+		{Name: fieldCampaignID, DataType: "integer"},
+		{Name: fieldLeadID, DataType: "integer"},
+		{Name: fieldLeadIDs}, // list of lead ids
+	}
+	for _, token := range w.tokens {
+		fields = append(fields, marketoclient.ObjectField{Name: token.field})
+	}
+	return fields
+}
+
+// trigger of a campaign for leads, read from a record
+type campaignTrigger struct {
+	campaignID int
+	leadIDs    []int
+	tokens     []marketoclient.Token // token values, sorted by name
+}
+
+// campaign and token values of a group of triggers made by a single call
+type campaignTokens struct {
+	campaignID int
+	tokens     int // index of the token values in the distinct token values of the triggers
+}
+
+// writes given records grouped by campaign and token values, stopping at the first record which couldn't be written.
+// Deletes are not supported. returns the number of records written.
+func (w *CampaignWriter) Write(ctx context.Context, records []sdk.Record) (int, error) {
+	return writeBatches(ctx, records, len(records), w.trigger, w.delete)
+}
+
+// deletes are not supported, triggered campaigns can't be undone.
+func (w *CampaignWriter) delete(ctx context.Context, records []sdk.Record) (int, error) {
+	return 0, fmt.Errorf("%w %s of record %s, triggered campaigns can't be undone", ErrUnsupportedOperation, records[0].Operation, records[0].Key.Bytes())
+}
+
+// triggers the campaigns of given records.
+func (w *CampaignWriter) trigger(ctx context.Context, records []sdk.Record) (int, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "trigger").Logger()
+	logger.Trace().Msgf("Triggering campaigns of %d records", len(records))
+
+	var triggers = make([]campaignTrigger, 0, len(records))
+	var readErr error
+	for _, r := range records {
+		trigger, err := w.parseTrigger(r)
+		if err != nil {
+			readErr = fmt.Errorf("error reading record %s %w", r.Key.Bytes(), err)
+			break
+		}
+		triggers = append(triggers, trigger)
+	}
+	written, err := w.triggerCampaigns(triggers)
+	if err != nil {
+		return written, err
+	}
+	return written, readErr
+}
+
+// triggers given campaigns, with a call by campaign and token values for at most MaxTriggerLeads leads.
+func (w *CampaignWriter) triggerCampaigns(triggers []campaignTrigger) (int, error) {
+	if len(triggers) == 0 {
+		return 0, nil
+	}
+	groups := newLeadGroups(len(triggers))
+	var values [][]marketoclient.Token // distinct token values of the triggers
+	for i, trigger := range triggers {
+		tokens := indexTokens(values, trigger.tokens)
+		if tokens == len(values) {
+			values = append(values, trigger.tokens)
+		}
+		groups.add(campaignTokens{campaignID: trigger.campaignID, tokens: tokens}, i, trigger.leadIDs)
+	}
+	// failures can't be told apart, campaigns are triggered for all the leads of a call or for none.
+	return groups.write(marketoclient.MaxTriggerLeads, make(map[int]string), func(group interface{}, ids []int) ([]minimarketo.RecordResult, error) {
+		ct := group.(campaignTokens)
+		err := w.client.TriggerCampaign(ct.campaignID, ids, values[ct.tokens])
+		if err != nil {
+			return nil, fmt.Errorf("error triggering campaign %d %w", ct.campaignID, err)
+		}
+		return make([]minimarketo.RecordResult, len(ids)), nil
+	}, noneIgnored)
+}
+
+// returns the index of given token values in values, or len(values) if they are missing.
+func indexTokens(values [][]marketoclient.Token, tokens []marketoclient.Token) int {
+	for i, v := range values {
+		if len(v) != len(tokens) {
+			continue
+		}
+		equal := true
+		for j := range v {
+			if v[j] != tokens[j] {
+				equal = false
+				break
+			}
+		}
+		if equal {
+			return i
+		}
+	}
+	return len(values)
+}
+
+// returns the campaign, leads and token values of a record.
+func (w *CampaignWriter) parseTrigger(r sdk.Record) (campaignTrigger, error) {
+	data, err := recordData(r)
+	if err != nil {
+		return campaignTrigger{}, err
+	}
+	trigger := campaignTrigger{campaignID: w.campaignID}
+	if value, ok := data[fieldCampaignID]; ok {
+		trigger.campaignID, ok = toInt(value)
+		if !ok {
+			return campaignTrigger{}, fmt.Errorf("%s field should be a campaign id, got %v", fieldCampaignID, value)
+		}
+	}
+	if trigger.campaignID == 0 {
+		return campaignTrigger{}, fmt.Errorf("record has no %s field and no campaign is configured", fieldCampaignID)
+	}
+
+	if value, ok := data[fieldLeadIDs]; ok {
+		values, ok := value.([]interface{})
+		if !ok {
+			return campaignTrigger{}, fmt.Errorf("%s field should be a list of lead ids, got %v", fieldLeadIDs, value)
+		}
+		for _, v := range values {
+			id, ok := toInt(v)
+			if !ok {
+				return campaignTrigger{}, fmt.Errorf("%s field should be a list of lead ids, got %v", fieldLeadIDs, value)
+			}
+			trigger.leadIDs = append(trigger.leadIDs, id)
+		}
+	} else {
+		id, ok := toInt(data[fieldLeadID])
+		if !ok {
+			return campaignTrigger{}, fmt.Errorf("%s field should be a lead id, got %v", fieldLeadID, data[fieldLeadID])
+		}
+		trigger.leadIDs = []int{id}
+	}
+	if len(trigger.leadIDs) == 0 {
+		return campaignTrigger{}, fmt.Errorf("%s field has no lead ids", fieldLeadIDs)
+	}
+
+	for _, token := range w.tokens {
+		value, ok := data[token.field]
+		if !ok || value == nil {
+			continue // the campaign uses the value of the program
+		}
+		trigger.tokens = append(trigger.tokens, marketoclient.Token{Name: token.name, Value: formatValue(value)})
+	}
+	return trigger, nil
+}
@@ -0,0 +1,136 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// handles the triggers of the campaign with given id, failing them if failed is true.
+func handleTrigger(f *marketotest.Server, campaignID int, failed bool) {
+	f.Handle(fmt.Sprintf("/rest/v1/campaigns/%d/trigger.json", campaignID), func(w http.ResponseWriter, r *http.Request) {
+		if failed {
+			_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":"1013","message":"Campaign not found"}]}`))
+			return
+		}
+		marketotest.WriteResult(w, []map[string]int{{"id": campaignID}}, "", false)
+	})
+}
+
+func TestCampaignWriter_Write(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	handleTrigger(f, 2001, false)
+	handleTrigger(f, 2002, false)
+	var records []sdk.Record
+	for i := 0; i < 150; i++ {
+		records = append(records, testRecord(sdk.OperationSnapshot, fmt.Sprint(i), sdk.StructuredData{"leadId": float64(i + 10), "plan": "pro"}))
+	}
+	records = append(records,
+		testRecord(sdk.OperationCreate, "basic", sdk.StructuredData{"leadId": float64(1), "plan": "basic"}),
+		testRecord(sdk.OperationCreate, "other", sdk.StructuredData{"campaignId": float64(2002), "leadIds": []interface{}{float64(2), float64(3)}}),
+	)
+
+	n, err := NewCampaignWriter(f.Client(t), 2001, map[string]string{"{{my.plan}}": "plan"}).Write(ctx, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(records) {
+		t.Errorf("expected %d records written, got %d", len(records), n)
+	}
+	triggers := f.RequestsTo("/rest/v1/campaigns/2001/trigger.json")
+	if len(triggers) != 3 {
+		t.Fatalf("expected the campaign triggered in 3 calls, got %d", len(triggers))
+	}
+	input := decodeBody(t, triggers[0])["input"].(map[string]interface{})
+	if len(input["leads"].([]interface{})) != 100 || fmt.Sprint(input["tokens"]) != "[map[name:{{my.plan}} value:pro]]" {
+		t.Errorf("expected the first 100 leads triggered with their token, got %v", input)
+	}
+	input = decodeBody(t, triggers[2])["input"].(map[string]interface{})
+	if fmt.Sprint(input) != "map[leads:[map[id:1]] tokens:[map[name:{{my.plan}} value:basic]]]" {
+		t.Errorf("expected the lead with another token value triggered separately, got %v", input)
+	}
+	triggers = f.RequestsTo("/rest/v1/campaigns/2002/trigger.json")
+	if len(triggers) != 1 || fmt.Sprint(decodeBody(t, triggers[0])["input"]) != "map[leads:[map[id:2] map[id:3]]]" {
+		t.Errorf("expected the leads of the record with a campaign id triggered without tokens, got %v", triggers)
+	}
+}
+
+func TestCampaignWriter_Tokens(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	handleTrigger(f, 2001, false)
+	tokens := map[string]string{"{{my.trialEnd}}": "trialEnd", "{{my.plan}}": "plan"}
+	var records []sdk.Record
+	for i := 0; i < 150; i++ {
+		records = append(records, testRecord(sdk.OperationCreate, fmt.Sprint(i), sdk.StructuredData{"leadId": float64(i + 10), "plan": "pro", "trialEnd": "2022-10-31"}))
+	}
+
+	n, err := NewCampaignWriter(f.Client(t), 2001, tokens).Write(ctx, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(records) {
+		t.Errorf("expected %d records written, got %d", len(records), n)
+	}
+	triggers := f.RequestsTo("/rest/v1/campaigns/2001/trigger.json")
+	if len(triggers) != 2 {
+		t.Fatalf("expected the records triggered in 2 calls, got %d", len(triggers))
+	}
+	for i, leads := range []int{marketoclient.MaxTriggerLeads, 50} {
+		input := decodeBody(t, triggers[i])["input"].(map[string]interface{})
+		if len(input["leads"].([]interface{})) != leads {
+			t.Errorf("expected %d leads triggered by call %d, got %d", leads, i, len(input["leads"].([]interface{})))
+		}
+		if fmt.Sprint(input["tokens"]) != "[map[name:{{my.plan}} value:pro] map[name:{{my.trialEnd}} value:2022-10-31]]" {
+			t.Errorf("expected the token values sorted by name, got %v", input["tokens"])
+		}
+	}
+}
+
+func TestCampaignWriter_Failures(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	handleTrigger(f, 2001, false)
+	handleTrigger(f, 2002, true)
+	records := []sdk.Record{
+		testRecord(sdk.OperationCreate, "1", sdk.StructuredData{"leadId": float64(1)}),
+		testRecord(sdk.OperationCreate, "2", sdk.StructuredData{"campaignId": float64(2002), "leadId": float64(2)}),
+	}
+
+	n, err := NewCampaignWriter(f.Client(t), 2001, nil).Write(ctx, records)
+	if n != 1 || err == nil || !strings.Contains(err.Error(), "Campaign not found") {
+		t.Errorf("expected the record before the failed trigger written and an error, got %d and %v", n, err)
+	}
+
+	n, err = NewCampaignWriter(f.Client(t), 0, nil).Write(ctx, records)
+	if n != 0 || err == nil || !strings.Contains(err.Error(), "no campaign is configured") {
+		t.Errorf("expected no record written without a campaign, got %d and %v", n, err)
+	}
+
+	n, err = NewCampaignWriter(f.Client(t), 2001, nil).Write(ctx, []sdk.Record{{Operation: sdk.OperationDelete, Key: sdk.RawData("1")}})
+	if n != 0 || !errors.Is(err, ErrUnsupportedOperation) {
+		t.Errorf("expected deletes unsupported, got %d and %v", n, err)
+	}
+}
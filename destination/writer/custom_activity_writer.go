@@ -0,0 +1,186 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// field of the records written by CustomActivityWriter holding the activity date, the time of the write if missing
+const fieldActivityDate = "activityDate"
+
+// CustomActivityWriter writes records to custom activities of a custom activity type. Create, update and snapshot
+// records hold the lead id, the primary attribute value and the attributes of an activity added to the lead.
+type CustomActivityWriter struct {
+	client         *marketoclient.Client       // marketo client
+	activityTypeID int                         // custom activity type of the activities
+	primaryField   string                      // record field holding the primary attribute value
+	attributes     map[string]string           // record field by attribute API name
+	names          []string                    // sorted attribute API names
+	fields         []marketoclient.ObjectField // fields read from the records, with the data types of their attributes
+}
+
+// returns NewCustomActivityWriter which adds activities of the custom activity type with given id. primaryField holds
+// the value of the primary attribute, and attributes maps the attributes to record fields, both default to the fields
+// named after the attributes. The custom activity type must be approved and have the mapped attributes.
+func NewCustomActivityWriter(client *marketoclient.Client, activityTypeID int, primaryField string, attributes map[string]string) (*CustomActivityWriter, error) {
+	types, err := client.GetCustomActivityTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get custom activity types: %w", err)
+	}
+	var activityType *marketoclient.ActivityType
+	for i := range types {
+		if types[i].ID == activityTypeID {
+			activityType = &types[i]
+			break
+		}
+	}
+	if activityType == nil {
+		return nil, fmt.Errorf("custom activity type %d not found", activityTypeID)
+	}
+	if !strings.HasPrefix(activityType.Status, "approved") {
+		return nil, fmt.Errorf("custom activity type %d is %s, activities are added to approved types only", activityTypeID, activityType.Status)
+	}
+
+	if primaryField == "" {
+		primaryField = activityType.PrimaryAttribute.APIName
+	}
+	var dataTypes = make(map[string]string, len(activityType.Attributes))
+	for _, attribute := range activityType.Attributes {
+		dataTypes[attribute.APIName] = attribute.DataType
+	}
+	if len(attributes) == 0 {
+		attributes = make(map[string]string, len(activityType.Attributes))
+		for _, attribute := range activityType.Attributes {
+			attributes[attribute.APIName] = attribute.APIName
+		}
+	}
+	var names = make([]string, 0, len(attributes))
+	for name := range attributes {
+		if _, ok := dataTypes[name]; !ok {
+			return nil, fmt.Errorf("%s isn't an attribute of custom activity type %d", name, activityTypeID)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var fields = []marketoclient.ObjectField{
+		{Name: fieldLeadID, DataType: "integer"},
+		{Name: fieldActivityDate, DataType: "datetime"},
+		{Name: primaryField, DataType: activityType.PrimaryAttribute.DataType},
+	}
+	for _, name := range names {
+		fields = append(fields, marketoclient.ObjectField{Name: attributes[name], DataType: dataTypes[name]})
+	}
+	return &CustomActivityWriter{
+		client:         client,
+		activityTypeID: activityTypeID,
+		primaryField:   primaryField,
+		attributes:     attributes,
+		names:          names,
+		fields:         fields,
+	}, nil
+}
+
+// returns the lead, activity date, primary attribute and attribute fields read from the records.
+func (w *CustomActivityWriter) Fields() []marketoclient.ObjectField {
+	return w.fields
+}
+
+// writes given records in batches of at most MaxSyncRecords records, stopping at the first record which couldn't be
+// written. Deletes are not supported. returns the number of records written.
+func (w *CustomActivityWriter) Write(ctx context.Context, records []sdk.Record) (int, error) {
+	return writeBatches(ctx, records, marketoclient.MaxSyncRecords, w.add, w.delete)
+}
+
+// deletes are not supported, activities can't be deleted.
+func (w *CustomActivityWriter) delete(ctx context.Context, records []sdk.Record) (int, error) {
+	return 0, fmt.Errorf("%w %s of record %s, activities can't be deleted", ErrUnsupportedOperation, records[0].Operation, records[0].Key.Bytes())
+}
+
+// adds the activities of given records.
+func (w *CustomActivityWriter) add(ctx context.Context, records []sdk.Record) (int, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "add").Logger()
+	logger.Trace().Msgf("Adding %d custom activities", len(records))
+
+	var activities = make([]map[string]interface{}, 0, len(records))
+	var readErr error
+	for _, r := range records {
+		activity, err := w.activity(r)
+		if err != nil {
+			readErr = fmt.Errorf("error reading record %s %w", r.Key.Bytes(), err)
+			break
+		}
+		activities = append(activities, activity)
+	}
+	written, err := w.addActivities(ctx, records[:len(activities)], activities)
+	if err != nil {
+		return written, err
+	}
+	return written, readErr
+}
+
+// adds given activities, read from given records.
+func (w *CustomActivityWriter) addActivities(ctx context.Context, records []sdk.Record, activities []map[string]interface{}) (int, error) {
+	if len(activities) == 0 {
+		return 0, nil
+	}
+	results, err := w.client.AddCustomActivities(activities)
+	if err != nil {
+		return 0, fmt.Errorf("error adding custom activities %w", err)
+	}
+	return checkResults(ctx, records, results)
+}
+
+// returns the activity of a record.
+func (w *CustomActivityWriter) activity(r sdk.Record) (map[string]interface{}, error) {
+	data, err := recordData(r)
+	if err != nil {
+		return nil, err
+	}
+	leadID, ok := toInt(data[fieldLeadID])
+	if !ok {
+		return nil, fmt.Errorf("%s field should be a lead id, got %v", fieldLeadID, data[fieldLeadID])
+	}
+	activityDate := time.Now().UTC().Format(time.RFC3339)
+	if value, ok := data[fieldActivityDate]; ok {
+		activityDate = formatValue(value)
+	}
+	primaryValue := formatValue(data[w.primaryField])
+	if primaryValue == "" {
+		return nil, fmt.Errorf("record has no %s field with the primary attribute value", w.primaryField)
+	}
+	var attributes = make([]map[string]string, 0, len(w.names))
+	for _, name := range w.names {
+		value, ok := data[w.attributes[name]]
+		if !ok || value == nil {
+			continue
+		}
+		attributes = append(attributes, map[string]string{"apiName": name, "value": formatValue(value)})
+	}
+	return map[string]interface{}{
+		"leadId":                leadID,
+		"activityDate":          activityDate,
+		"activityTypeId":        w.activityTypeID,
+		"primaryAttributeValue": primaryValue,
+		"attributes":            attributes,
+	}, nil
+}
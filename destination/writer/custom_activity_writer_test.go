@@ -0,0 +1,155 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpeakData/minimarketo"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// handles the feature used custom activity type, with given status, and adds of its activities.
+func handleFeatureUsed(f *marketotest.Server, status string) {
+	f.Handle("/rest/v1/activities/external/types.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []marketoclient.ActivityType{{
+			ID:               100001,
+			APIName:          "featureUsed",
+			Status:           status,
+			PrimaryAttribute: marketoclient.ActivityTypeAttribute{APIName: "feature", DataType: "string"},
+			Attributes: []marketoclient.ActivityTypeAttribute{
+				{APIName: "plan", DataType: "string"},
+				{APIName: "seats", DataType: "integer"},
+			},
+		}}, "", false)
+	})
+	f.Handle("/rest/v1/activities/external.json", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input []map[string]interface{} `json:"input"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		var results []minimarketo.RecordResult
+		for i := range body.Input {
+			results = append(results, recordResult(i+1, "added", ""))
+		}
+		marketotest.WriteResult(w, results, "", false)
+	})
+}
+
+func TestCustomActivityWriter_Write(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	handleFeatureUsed(f, "approved")
+	w, err := NewCustomActivityWriter(f.Client(t), 100001, "", map[string]string{"plan": "planName", "seats": "seats"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []sdk.Record{
+		testRecord(sdk.OperationCreate, "a", sdk.StructuredData{
+			"leadId":       float64(5),
+			"activityDate": "2022-10-01T10:00:00Z",
+			"feature":      "export",
+			"planName":     "pro",
+			"seats":        float64(3),
+		}),
+		testRecord(sdk.OperationCreate, "b", sdk.StructuredData{"leadId": float64(6), "feature": "import"}),
+		testRecord(sdk.OperationCreate, "c", sdk.StructuredData{
+			"leadId":       float64(7),
+			"activityDate": time.Date(2022, 10, 2, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60)),
+			"feature":      "export",
+		}),
+	}
+
+	n, err := w.Write(ctx, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(records) {
+		t.Errorf("expected %d records written, got %d", len(records), n)
+	}
+	requests := f.RequestsTo("/rest/v1/activities/external.json")
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 batch, got %d", len(requests))
+	}
+	input := decodeBody(t, requests[0])["input"].([]interface{})
+	expected := map[string]interface{}{
+		"leadId":                float64(5),
+		"activityDate":          "2022-10-01T10:00:00Z",
+		"activityTypeId":        float64(100001),
+		"primaryAttributeValue": "export",
+		"attributes": []interface{}{
+			map[string]interface{}{"apiName": "plan", "value": "pro"},
+			map[string]interface{}{"apiName": "seats", "value": "3"},
+		},
+	}
+	if !reflect.DeepEqual(input[0], expected) {
+		t.Errorf("expected activity %v, got %v", expected, input[0])
+	}
+	if second := input[1].(map[string]interface{}); second["activityDate"] == "" || len(second["attributes"].([]interface{})) != 0 {
+		t.Errorf("expected an activity dated now without attributes, got %v", second)
+	}
+	// dates of structured payloads are formatted as RFC 3339, not JSON quoted.
+	if date := input[2].(map[string]interface{})["activityDate"]; date != "2022-10-02T10:00:00Z" {
+		t.Errorf("expected activity date 2022-10-02T10:00:00Z, got %v", date)
+	}
+}
+
+func TestNewCustomActivityWriter_Validation(t *testing.T) {
+	testCases := []struct {
+		name       string
+		status     string
+		typeID     int
+		attributes map[string]string
+		wantErr    string
+	}{
+		{
+			name:    "unknown type",
+			status:  "approved",
+			typeID:  100002,
+			wantErr: "custom activity type 100002 not found",
+		},
+		{
+			name:    "draft type",
+			status:  "draft",
+			typeID:  100001,
+			wantErr: "activities are added to approved types only",
+		},
+		{
+			name:       "unknown attribute",
+			status:     "approved with draft",
+			typeID:     100001,
+			attributes: map[string]string{"discount": "discount"},
+			wantErr:    "discount isn't an attribute of custom activity type 100001",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := marketotest.NewServer(t)
+			handleFeatureUsed(f, tc.status)
+			_, err := NewCustomActivityWriter(f.Client(t), tc.typeID, "", tc.attributes)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// CustomObjectWriter writes records to a custom object. Create, update and snapshot records create or update the
+// record matching their dedupe fields or id field, delete records delete the record identified by their key.
+type CustomObjectWriter struct {
+	client    *marketoclient.Client // marketo client
+	apiName   string                // API name of the custom object
+	dedupeBy  string                // fields matching records with existing records, dedupe fields or id field
+	keyFields []string              // fields identifying the records to delete
+	fields    map[string]bool       // fields of the custom object
+}
+
+// returns NewCustomObjectWriter which writes the custom object with given API name, matching existing records by
+// dedupeBy, see marketoclient.DedupeByDedupeFields and marketoclient.DedupeByIDField. The fields of the records are
+// validated against the description of the custom object.
+func NewCustomObjectWriter(client *marketoclient.Client, apiName string, dedupeBy string) (*CustomObjectWriter, error) {
+	customObject, err := client.DescribeCustomObject(apiName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe custom object %q: %w", apiName, err)
+	}
+	var fields = make(map[string]bool, len(customObject.Fields))
+	for _, field := range customObject.Fields {
+		fields[field.Name] = true
+	}
+	keyFields := customObject.DedupeFields
+	if dedupeBy == marketoclient.DedupeByIDField {
+		keyFields = []string{customObject.IDField}
+	}
+	if len(keyFields) == 0 || keyFields[0] == "" {
+		return nil, fmt.Errorf("custom object %q has no %s", apiName, dedupeBy)
+	}
+	return &CustomObjectWriter{
+		client:    client,
+		apiName:   apiName,
+		dedupeBy:  dedupeBy,
+		keyFields: keyFields,
+		fields:    fields,
+	}, nil
+}
+
+// writes given records in batches of at most MaxSyncRecords records, stopping at the first record which couldn't be
+// written. returns the number of records written.
+func (w *CustomObjectWriter) Write(ctx context.Context, records []sdk.Record) (int, error) {
+	return writeBatches(ctx, records, marketoclient.MaxSyncRecords, w.upsert, w.delete)
+}
+
+// creates or updates the custom object records of given records.
+func (w *CustomObjectWriter) upsert(ctx context.Context, records []sdk.Record) (int, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "upsert").Logger()
+	logger.Trace().Msgf("Upserting %d records of %s", len(records), w.apiName)
+
+	var objects = make([]map[string]interface{}, 0, len(records))
+	var readErr error
+	for _, r := range records {
+		object, err := recordData(r)
+		if err == nil {
+			err = w.validate(object)
+		}
+		if err != nil {
+			readErr = fmt.Errorf("error reading record %s %w", r.Key.Bytes(), err)
+			break
+		}
+		objects = append(objects, object)
+	}
+	written, err := w.syncObjects(ctx, records[:len(objects)], objects)
+	if err != nil {
+		return written, err
+	}
+	return written, readErr
+}
+
+// upserts given custom object records, read from given records.
+func (w *CustomObjectWriter) syncObjects(ctx context.Context, records []sdk.Record, objects []map[string]interface{}) (int, error) {
+	if len(objects) == 0 {
+		return 0, nil
+	}
+	results, err := w.client.SyncCustomObjects(w.apiName, marketoclient.SyncActionCreateOrUpdate, w.dedupeBy, objects)
+	if err != nil {
+		return 0, fmt.Errorf("error upserting records of %s %w", w.apiName, err)
+	}
+	return checkResults(ctx, records, results)
+}
+
+// deletes the custom object records of given records.
+func (w *CustomObjectWriter) delete(ctx context.Context, records []sdk.Record) (int, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "delete").Logger()
+	logger.Trace().Msgf("Deleting %d records of %s", len(records), w.apiName)
+
+	var keys = make([]map[string]interface{}, 0, len(records))
+	var keyErr error
+	for _, r := range records {
+		key, err := w.parseKey(r.Key)
+		if err != nil {
+			keyErr = fmt.Errorf("error reading key of record %s %w", r.Key.Bytes(), err)
+			break
+		}
+		keys = append(keys, key)
+	}
+	written, err := w.deleteObjects(ctx, records[:len(keys)], keys)
+	if err != nil {
+		return written, err
+	}
+	return written, keyErr
+}
+
+// deletes the custom object records identified by given keys, read from given records.
+func (w *CustomObjectWriter) deleteObjects(ctx context.Context, records []sdk.Record, keys []map[string]interface{}) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	results, err := w.client.DeleteCustomObjects(w.apiName, w.dedupeBy, keys)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting records of %s %w", w.apiName, err)
+	}
+	return checkResults(ctx, records, results)
+}
+
+// returns an error listing the fields of given record which aren't fields of the custom object.
+func (w *CustomObjectWriter) validate(object map[string]interface{}) error {
+	var unknown []string
+	for field := range object {
+		if !w.fields[field] {
+			unknown = append(unknown, field)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("fields %s aren't fields of custom object %q", strings.Join(unknown, ", "), w.apiName)
+}
+
+// returns the key fields of a record key, which is structured or JSON, or the raw value of the key field when the
+// records are identified by a single field.
+func (w *CustomObjectWriter) parseKey(key sdk.Data) (map[string]interface{}, error) {
+	var fields map[string]interface{}
+	switch k := key.(type) {
+	case sdk.StructuredData:
+		fields = k
+	case sdk.RawData:
+		raw := strings.TrimSpace(string(k))
+		if !strings.HasPrefix(raw, "{") {
+			if len(w.keyFields) != 1 || raw == "" {
+				return nil, fmt.Errorf("key should hold the fields %s", strings.Join(w.keyFields, ", "))
+			}
+			return map[string]interface{}{w.keyFields[0]: raw}, nil
+		}
+		err := json.Unmarshal(k, &fields)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("record has no key")
+	}
+	var keyFields = make(map[string]interface{}, len(w.keyFields))
+	for _, field := range w.keyFields {
+		value, ok := fields[field]
+		if !ok || value == nil {
+			return nil, fmt.Errorf("key has no %s field", field)
+		}
+		keyFields[field] = value
+	}
+	return keyFields, nil
+}
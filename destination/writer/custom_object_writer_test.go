@@ -0,0 +1,147 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/SpeakData/minimarketo"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// handles the description of the subscription_c custom object, and writes of its records which all succeed.
+func handleSubscriptions(f *marketotest.Server) {
+	f.Handle("/rest/v1/customobjects/subscription_c/describe.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []marketoclient.ObjectDescription{{
+			Name:         "subscription_c",
+			IDField:      "marketoGUID",
+			DedupeFields: []string{"subscriptionId"},
+			Fields: []marketoclient.ObjectField{
+				{Name: "marketoGUID"},
+				{Name: "subscriptionId"},
+				{Name: "plan"},
+				{Name: "leadId"},
+			},
+		}}, "", false)
+	})
+	write := func(status string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				Input []map[string]interface{} `json:"input"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			var results []minimarketo.RecordResult
+			for range body.Input {
+				results = append(results, recordResult(0, status, ""))
+			}
+			marketotest.WriteResult(w, results, "", false)
+		}
+	}
+	f.Handle("/rest/v1/customobjects/subscription_c.json", write(marketoclient.SyncStatusCreated))
+	f.Handle("/rest/v1/customobjects/subscription_c/delete.json", write(marketoclient.SyncStatusDeleted))
+}
+
+func TestCustomObjectWriter_Write(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	handleSubscriptions(f)
+	w, err := NewCustomObjectWriter(f.Client(t), "subscription_c", marketoclient.DedupeByDedupeFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []sdk.Record{
+		testRecord(sdk.OperationCreate, "s1", sdk.StructuredData{"subscriptionId": "s1", "plan": "pro", "leadId": float64(5)}),
+		{Operation: sdk.OperationDelete, Key: sdk.RawData("s2")},
+		{Operation: sdk.OperationDelete, Key: sdk.StructuredData{"subscriptionId": "s3", "plan": "free"}},
+	}
+
+	n, err := w.Write(ctx, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(records) {
+		t.Errorf("expected %d records written, got %d", len(records), n)
+	}
+	upserts := f.RequestsTo("/rest/v1/customobjects/subscription_c.json")
+	if len(upserts) != 1 {
+		t.Fatalf("expected 1 upsert, got %d", len(upserts))
+	}
+	if body := decodeBody(t, upserts[0]); body["action"] != "createOrUpdate" || body["dedupeBy"] != "dedupeFields" {
+		t.Errorf("unexpected upsert %v", body)
+	}
+	deletes := f.RequestsTo("/rest/v1/customobjects/subscription_c/delete.json")
+	if len(deletes) != 1 {
+		t.Fatalf("expected 1 delete, got %d", len(deletes))
+	}
+	expected := map[string]interface{}{
+		"deleteBy": "dedupeFields",
+		"input": []interface{}{
+			map[string]interface{}{"subscriptionId": "s2"},
+			map[string]interface{}{"subscriptionId": "s3"},
+		},
+	}
+	if body := decodeBody(t, deletes[0]); !reflect.DeepEqual(body, expected) {
+		t.Errorf("expected delete %v, got %v", expected, body)
+	}
+}
+
+func TestCustomObjectWriter_DeleteByIDField(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	handleSubscriptions(f)
+	w, err := NewCustomObjectWriter(f.Client(t), "subscription_c", marketoclient.DedupeByIDField)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []sdk.Record{
+		{Operation: sdk.OperationDelete, Key: sdk.RawData(`{"marketoGUID":"guid-1"}`)},
+		{Operation: sdk.OperationDelete, Key: sdk.StructuredData{"subscriptionId": "s2"}},
+	}
+
+	n, err := w.Write(ctx, records)
+	if n != 1 || err == nil || !strings.Contains(err.Error(), "key has no marketoGUID field") {
+		t.Errorf("expected the record before the key without id field written and an error, got %d and %v", n, err)
+	}
+	deletes := f.RequestsTo("/rest/v1/customobjects/subscription_c/delete.json")
+	if len(deletes) != 1 || decodeBody(t, deletes[0])["deleteBy"] != "idField" {
+		t.Errorf("expected a delete by id field, got %v", deletes)
+	}
+}
+
+func TestCustomObjectWriter_UnknownFields(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	handleSubscriptions(f)
+	w, err := NewCustomObjectWriter(f.Client(t), "subscription_c", marketoclient.DedupeByDedupeFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []sdk.Record{
+		testRecord(sdk.OperationCreate, "s1", sdk.StructuredData{"subscriptionId": "s1"}),
+		testRecord(sdk.OperationUpdate, "s2", sdk.StructuredData{"subscriptionId": "s2", "seats": float64(3), "amount": float64(9)}),
+	}
+
+	n, err := w.Write(ctx, records)
+	if n != 1 || err == nil || !strings.Contains(err.Error(), `fields amount, seats aren't fields of custom object "subscription_c"`) {
+		t.Errorf("expected the record before the unknown fields written and an error, got %d and %v", n, err)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/SpeakData/minimarketo"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// LeadWriter writes records to leads with the synchronous leads API. Create, update and snapshot records create or
+// update the lead matching their lookup field, delete records delete the lead identified by their key.
+type LeadWriter struct {
+	client         *marketoclient.Client // marketo client
+	lookupField    string                // lead field matching records with existing leads
+	ignoreNotFound bool                  // true if deletes of leads which don't exist are considered written
+}
+
+// returns NewLeadWriter which upserts leads matched by given lookup field, and deletes leads by id or email. Deletes
+// of leads which don't exist fail unless ignoreNotFound is set.
+func NewLeadWriter(client *marketoclient.Client, lookupField string, ignoreNotFound bool) *LeadWriter {
+	return &LeadWriter{
+		client:         client,
+		lookupField:    lookupField,
+		ignoreNotFound: ignoreNotFound,
+	}
+}
+
+// writes given records in batches of at most MaxSyncRecords records, stopping at the first record which couldn't be
+// written. returns the number of records written.
+func (w *LeadWriter) Write(ctx context.Context, records []sdk.Record) (int, error) {
+	return writeBatches(ctx, records, marketoclient.MaxSyncRecords, w.upsert, w.delete)
+}
+
+// creates or updates the leads of given records.
+func (w *LeadWriter) upsert(ctx context.Context, records []sdk.Record) (int, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "upsert").Logger()
+	logger.Trace().Msgf("Upserting %d leads", len(records))
+
+	var leads = make([]map[string]interface{}, 0, len(records))
+	var readErr error
+	for _, r := range records {
+		lead, err := recordData(r)
+		if err != nil {
+			readErr = err
+			break
+		}
+		leads = append(leads, lead)
+	}
+	written, err := w.syncLeads(ctx, records[:len(leads)], leads)
+	if err != nil {
+		return written, err
+	}
+	return written, readErr
+}
+
+// upserts given leads, read from given records.
+func (w *LeadWriter) syncLeads(ctx context.Context, records []sdk.Record, leads []map[string]interface{}) (int, error) {
+	if len(leads) == 0 {
+		return 0, nil
+	}
+	results, err := w.client.SyncLeads(marketoclient.SyncActionCreateOrUpdate, w.lookupField, leads)
+	if err != nil {
+		return 0, fmt.Errorf("error upserting leads %w", err)
+	}
+	return checkResults(ctx, records, results)
+}
+
+// lead identified by a record key, by id or by email
+type leadKey struct {
+	id    int
+	email string
+}
+
+// deletes the leads of given records.
+func (w *LeadWriter) delete(ctx context.Context, records []sdk.Record) (int, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "delete").Logger()
+	logger.Trace().Msgf("Deleting %d leads", len(records))
+
+	var keys = make([]leadKey, 0, len(records))
+	var keyErr error
+	for _, r := range records {
+		key, err := parseLeadKey(r.Key)
+		if err != nil {
+			keyErr = fmt.Errorf("error reading key of record %s %w", r.Key.Bytes(), err)
+			break
+		}
+		keys = append(keys, key)
+	}
+	written, err := w.deleteLeads(ctx, records[:len(keys)], keys)
+	if err != nil {
+		return written, err
+	}
+	return written, keyErr
+}
+
+// deletes the leads identified by given keys, read from given records. Leads identified by email are looked up first,
+// every lead with the email is deleted.
+func (w *LeadWriter) deleteLeads(ctx context.Context, records []sdk.Record, keys []leadKey) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	var emails []string
+	for _, key := range keys {
+		if key.email != "" {
+			emails = append(emails, key.email)
+		}
+	}
+	leadIDs, err := leadIDsBy(w.client, "email", emails)
+	if err != nil {
+		return 0, fmt.Errorf("error looking up leads by email %w", err)
+	}
+
+	var failures = make(map[int]string)
+	groups := newLeadGroups(len(keys))
+	for i, key := range keys {
+		if key.email == "" {
+			groups.add(nil, i, []int{key.id})
+			continue
+		}
+		emailIDs := leadIDs[strings.ToLower(key.email)]
+		if len(emailIDs) == 0 && !w.ignoreNotFound {
+			failures[i] = fmt.Sprintf("no lead with email %s", key.email)
+		}
+		groups.add(nil, i, emailIDs)
+	}
+	written, err := groups.write(marketoclient.MaxSyncRecords, failures, func(_ interface{}, ids []int) ([]minimarketo.RecordResult, error) {
+		results, err := w.client.DeleteLeads(ids)
+		if err != nil {
+			return nil, fmt.Errorf("error deleting leads %w", err)
+		}
+		return results, nil
+	}, func(result minimarketo.RecordResult) bool {
+		return w.ignoreNotFound && leadNotFound(result)
+	})
+	if err != nil {
+		return written, err
+	}
+	return failuresError(ctx, records, failures)
+}
+
+// returns the ids of the leads whose given field has one of given values, by lowercase value.
+func leadIDsBy(client *marketoclient.Client, field string, values []string) (map[string][]int, error) {
+	var leadIDs = make(map[string][]int)
+	fields := []string{"id", field}
+	for _, chunk := range marketoclient.ChunkQueryValues(marketoclient.QueryObjectLeads, field, values, fields) {
+		var moreResult = true
+		token := ""
+		for moreResult {
+			res, err := client.QueryObjects(marketoclient.QueryObjectLeads, field, chunk, fields, token)
+			if err != nil {
+				return nil, err
+			}
+			moreResult = res.MoreResult && res.NextPageToken != ""
+			token = res.NextPageToken
+			if len(res.Result) == 0 {
+				continue
+			}
+			var leads []map[string]interface{}
+			err = json.Unmarshal(res.Result, &leads)
+			if err != nil {
+				return nil, fmt.Errorf("error unmarshalling leads %w", err)
+			}
+			for _, lead := range leads {
+				id, ok := toInt(lead["id"])
+				if !ok {
+					return nil, fmt.Errorf("lead %v has no id", lead)
+				}
+				value := strings.ToLower(formatValue(lead[field]))
+				leadIDs[value] = append(leadIDs[value], id)
+			}
+		}
+	}
+	return leadIDs, nil
+}
+
+// returns the lead identified by a record key: the id or email field of a structured or JSON key, or a raw key
+// holding the id or an email.
+func parseLeadKey(key sdk.Data) (leadKey, error) {
+	var fields map[string]interface{}
+	switch k := key.(type) {
+	case sdk.StructuredData:
+		fields = k
+	case sdk.RawData:
+		raw := strings.TrimSpace(string(k))
+		if strings.HasPrefix(raw, "{") {
+			err := json.Unmarshal(k, &fields)
+			if err != nil {
+				return leadKey{}, err
+			}
+			break
+		}
+		if id, err := strconv.Atoi(raw); err == nil {
+			return leadKey{id: id}, nil
+		}
+		if strings.Contains(raw, "@") {
+			return leadKey{email: raw}, nil
+		}
+		return leadKey{}, fmt.Errorf("key %q is neither a lead id nor an email", raw)
+	default:
+		return leadKey{}, errors.New("record has no key")
+	}
+	if id, ok := toInt(fields["id"]); ok {
+		return leadKey{id: id}, nil
+	}
+	if email, ok := fields["email"].(string); ok && email != "" {
+		return leadKey{email: email}, nil
+	}
+	return leadKey{}, errors.New("key has neither an id nor an email field")
+}
+
+// returns true if a lead couldn't be written since it doesn't exist.
+func leadNotFound(result minimarketo.RecordResult) bool {
+	for _, reason := range result.Reasons {
+		if reason.Code == marketoclient.ReasonLeadNotFound {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/SpeakData/minimarketo"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+func TestLeadWriter_Upsert(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/leads.json", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input []map[string]interface{} `json:"input"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		var results []minimarketo.RecordResult
+		for i := range body.Input {
+			results = append(results, recordResult(i+1, marketoclient.SyncStatusCreated, ""))
+		}
+		marketotest.WriteResult(w, results, "", false)
+	})
+	var records []sdk.Record
+	for i := 0; i < 301; i++ {
+		records = append(records, testRecord(sdk.OperationCreate, fmt.Sprint(i), sdk.StructuredData{"email": fmt.Sprintf("lead%d@example.com", i)}))
+	}
+	records = append(records, sdk.Record{
+		Operation: sdk.OperationSnapshot,
+		Key:       sdk.RawData("raw"),
+		Payload:   sdk.Change{After: sdk.RawData(`{"email":"raw@example.com","company":"Acme"}`)},
+	})
+
+	n, err := NewLeadWriter(f.Client(t), "email", true).Write(ctx, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(records) {
+		t.Errorf("expected %d records written, got %d", len(records), n)
+	}
+	requests := f.RequestsTo("/rest/v1/leads.json")
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 batches, got %d", len(requests))
+	}
+	first, second := decodeBody(t, requests[0]), decodeBody(t, requests[1])
+	if first["action"] != "createOrUpdate" || first["lookupField"] != "email" || len(first["input"].([]interface{})) != 300 {
+		t.Errorf("unexpected first batch %v %v with %d leads", first["action"], first["lookupField"], len(first["input"].([]interface{})))
+	}
+	if input := second["input"].([]interface{}); len(input) != 2 || input[1].(map[string]interface{})["company"] != "Acme" {
+		t.Errorf("unexpected second batch %v", input)
+	}
+}
+
+func TestLeadWriter_Failures(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/leads.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []minimarketo.RecordResult{
+			recordResult(1, marketoclient.SyncStatusUpdated, ""),
+			recordResult(0, marketoclient.SyncStatusSkipped, "Value for required field 'email' not specified"),
+			recordResult(3, marketoclient.SyncStatusCreated, ""),
+			recordResult(0, marketoclient.SyncStatusSkipped, "Lead not found"),
+		}, "", false)
+	})
+	records := []sdk.Record{
+		testRecord(sdk.OperationUpdate, "a", sdk.StructuredData{"email": "a@example.com"}),
+		testRecord(sdk.OperationUpdate, "b", sdk.StructuredData{"firstName": "B"}),
+		testRecord(sdk.OperationCreate, "c", sdk.StructuredData{"email": "c@example.com"}),
+		testRecord(sdk.OperationCreate, "d", sdk.StructuredData{"email": "d@example.com"}),
+	}
+
+	n, err := NewLeadWriter(f.Client(t), "email", true).Write(ctx, records)
+	if n != 1 {
+		t.Errorf("expected the records before the first skipped record written, got %d", n)
+	}
+	if err == nil || !strings.Contains(err.Error(), "record b: 1006: Value for required field 'email' not specified") ||
+		!strings.Contains(err.Error(), "record d: 1006: Lead not found") {
+		t.Errorf("expected an error listing the skipped records, got %v", err)
+	}
+}
+
+func TestLeadWriter_InvalidPayload(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/leads.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []minimarketo.RecordResult{recordResult(1, marketoclient.SyncStatusCreated, "")}, "", false)
+	})
+	records := []sdk.Record{
+		testRecord(sdk.OperationCreate, "a", sdk.StructuredData{"email": "a@example.com"}),
+		{Operation: sdk.OperationCreate, Key: sdk.RawData("b"), Payload: sdk.Change{After: sdk.RawData("not json")}},
+	}
+
+	n, err := NewLeadWriter(f.Client(t), "email", true).Write(ctx, records)
+	if n != 1 || err == nil {
+		t.Errorf("expected the record before the invalid payload written and an error, got %d and %v", n, err)
+	}
+}
+
+func TestLeadWriter_Delete(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/leads.json", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			marketotest.WriteResult(w, []minimarketo.RecordResult{recordResult(1, marketoclient.SyncStatusUpdated, "")}, "", false)
+			return
+		}
+		if r.URL.Query().Get("filterType") != "email" {
+			t.Errorf("unexpected lead query %s", r.URL.RawQuery)
+		}
+		marketotest.WriteResult(w, []map[string]interface{}{
+			{"id": 7, "email": "Seven@example.com"},
+			{"id": 8, "email": "seven@example.com"},
+		}, "", false)
+	})
+	f.Handle("/rest/v1/leads/delete.json", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input []struct {
+				ID int `json:"id"`
+			} `json:"input"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		var results []minimarketo.RecordResult
+		for _, lead := range body.Input {
+			results = append(results, recordResult(lead.ID, marketoclient.SyncStatusDeleted, ""))
+		}
+		marketotest.WriteResult(w, results, "", false)
+	})
+	records := []sdk.Record{
+		testRecord(sdk.OperationCreate, "a", sdk.StructuredData{"email": "a@example.com"}),
+		{Operation: sdk.OperationDelete, Key: sdk.RawData("5")},
+		{Operation: sdk.OperationDelete, Key: sdk.StructuredData{"id": float64(6)}},
+		{Operation: sdk.OperationDelete, Key: sdk.RawData(`{"email":"seven@example.com"}`)},
+	}
+
+	n, err := NewLeadWriter(f.Client(t), "email", true).Write(ctx, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(records) {
+		t.Errorf("expected %d records written, got %d", len(records), n)
+	}
+	requests := f.RequestsTo("/rest/v1/leads/delete.json")
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 delete batch, got %d", len(requests))
+	}
+	var ids []int
+	for _, lead := range decodeBody(t, requests[0])["input"].([]interface{}) {
+		ids = append(ids, int(lead.(map[string]interface{})["id"].(float64)))
+	}
+	if fmt.Sprint(ids) != "[5 6 7 8]" {
+		t.Errorf("expected leads 5, 6, 7 and 8 deleted, got %v", ids)
+	}
+}
+
+func TestLeadWriter_DeleteNotFound(t *testing.T) {
+	testCases := []struct {
+		name           string
+		ignoreNotFound bool
+		written        int
+		wantErr        string
+	}{
+		{
+			name:           "ignore",
+			ignoreNotFound: true,
+			written:        3,
+		},
+		{
+			name:           "fail",
+			ignoreNotFound: false,
+			written:        0,
+			wantErr:        "2 of 3 records were skipped by marketo, record 1: 1004: Lead not found, record gone@example.com: no lead with email gone@example.com",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := marketotest.NewServer(t)
+			f.Handle("/rest/v1/leads.json", func(w http.ResponseWriter, r *http.Request) {
+				marketotest.WriteResult(w, []map[string]interface{}{}, "", false)
+			})
+			f.Handle("/rest/v1/leads/delete.json", func(w http.ResponseWriter, r *http.Request) {
+				notFound := recordResult(0, marketoclient.SyncStatusSkipped, "")
+				notFound.Reasons = append(notFound.Reasons, struct {
+					Code    string `json:"code"`
+					Message string `json:"message"`
+				}{Code: marketoclient.ReasonLeadNotFound, Message: "Lead not found"})
+				marketotest.WriteResult(w, []minimarketo.RecordResult{notFound, recordResult(2, marketoclient.SyncStatusDeleted, "")}, "", false)
+			})
+			records := []sdk.Record{
+				{Operation: sdk.OperationDelete, Key: sdk.RawData("1")},
+				{Operation: sdk.OperationDelete, Key: sdk.RawData("2")},
+				{Operation: sdk.OperationDelete, Key: sdk.RawData("gone@example.com")},
+			}
+
+			n, err := NewLeadWriter(f.Client(t), "email", tc.ignoreNotFound).Write(ctx, records)
+			if n != tc.written {
+				t.Errorf("expected %d records written, got %d", tc.written, n)
+			}
+			if (err == nil && tc.wantErr != "") || (err != nil && err.Error() != tc.wantErr) {
+				t.Errorf("expected error %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLeadWriter_InvalidKey(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/leads/delete.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []minimarketo.RecordResult{recordResult(1, marketoclient.SyncStatusDeleted, "")}, "", false)
+	})
+	records := []sdk.Record{
+		{Operation: sdk.OperationDelete, Key: sdk.RawData("1")},
+		{Operation: sdk.OperationDelete, Key: sdk.RawData("not a lead")},
+	}
+
+	n, err := NewLeadWriter(f.Client(t), "email", true).Write(ctx, records)
+	if n != 1 || err == nil {
+		t.Errorf("expected the record before the invalid key written and an error, got %d and %v", n, err)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/SpeakData/minimarketo"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// fields of the records written by ListMemberWriter
+const (
+	fieldListID = "listId"
+	fieldLeadID = "leadId"
+)
+
+// ListMemberWriter writes records to the members of static lists. Create, update and snapshot records add the lead of
+// their payload to the list, delete records remove the lead of their key from the list. Records hold the lead id and
+// the list id, which defaults to the configured list.
+type ListMemberWriter struct {
+	client *marketoclient.Client // marketo client
+	listID int                   // list of the records which don't hold a list id, 0 if none
+}
+
+// returns NewListMemberWriter which adds leads to static lists and removes them, given listID is the list of the
+// records which don't hold one, if not 0.
+func NewListMemberWriter(client *marketoclient.Client, listID int) *ListMemberWriter {
+	return &ListMemberWriter{
+		client: client,
+		listID: listID,
+	}
+}
+
+// returns the list and lead fields read from the records.
+func (w *ListMemberWriter) Fields() []marketoclient.ObjectField {
+	return []marketoclient.ObjectField{
+		{Name: fieldListID, DataType: "integer"},
+		{Name: fieldLeadID, DataType: "integer"},
+	}
+}
+
+// lead added to a list or removed from it, read from a record
+type listMember struct {
+	listID int
+	leadID int
+}
+
+// writes given records grouped by list, stopping at the first record which couldn't be written. returns the number
+// of records written.
+func (w *ListMemberWriter) Write(ctx context.Context, records []sdk.Record) (int, error) {
+	return writeBatches(ctx, records, len(records), w.add, w.remove)
+}
+
+// adds the leads of given records to their lists.
+func (w *ListMemberWriter) add(ctx context.Context, records []sdk.Record) (int, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "add").Logger()
+	logger.Trace().Msgf("Adding %d list members", len(records))
+
+	return w.writeMembers(ctx, records, recordData, func(listID int, ids []int) ([]minimarketo.RecordResult, error) {
+		results, err := w.client.AddToList(listID, ids)
+		if err != nil {
+			return nil, fmt.Errorf("error adding leads to list %d %w", listID, err)
+		}
+		return results, nil
+	}, noneIgnored)
+}
+
+// removes the leads of given records from their lists. Leads which aren't members are considered removed.
+func (w *ListMemberWriter) remove(ctx context.Context, records []sdk.Record) (int, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "remove").Logger()
+	logger.Trace().Msgf("Removing %d list members", len(records))
+
+	return w.writeMembers(ctx, records, w.keyData, func(listID int, ids []int) ([]minimarketo.RecordResult, error) {
+		results, err := w.client.RemoveFromList(listID, ids)
+		if err != nil {
+			return nil, fmt.Errorf("error removing leads from list %d %w", listID, err)
+		}
+		return results, nil
+	}, func(result minimarketo.RecordResult) bool {
+		for _, reason := range result.Reasons {
+			if reason.Code == marketoclient.ReasonLeadNotInList {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// writes the list members read from given records by data with call, grouped by list, in calls of at most
+// MaxSyncRecords leads. Skipped leads fail unless ignore returns true for their result.
+func (w *ListMemberWriter) writeMembers(
+	ctx context.Context,
+	records []sdk.Record,
+	data func(r sdk.Record) (map[string]interface{}, error),
+	call func(listID int, ids []int) ([]minimarketo.RecordResult, error),
+	ignore func(result minimarketo.RecordResult) bool,
+) (int, error) {
+	var members = make([]listMember, 0, len(records))
+	var readErr error
+	for _, r := range records {
+		fields, err := data(r)
+		var member listMember
+		if err == nil {
+			member, err = w.parseMember(fields)
+		}
+		if err != nil {
+			readErr = fmt.Errorf("error reading record %s %w", r.Key.Bytes(), err)
+			break
+		}
+		members = append(members, member)
+	}
+	if len(members) == 0 {
+		return 0, readErr
+	}
+
+	groups := newLeadGroups(len(members))
+	for i, member := range members {
+		groups.add(member.listID, i, []int{member.leadID})
+	}
+	var failures = make(map[int]string)
+	written, err := groups.write(marketoclient.MaxSyncRecords, failures, func(group interface{}, ids []int) ([]minimarketo.RecordResult, error) {
+		return call(group.(int), ids)
+	}, ignore)
+	if err != nil {
+		return written, err
+	}
+	written, err = failuresError(ctx, records[:len(members)], failures)
+	if err != nil {
+		return written, err
+	}
+	return written, readErr
+}
+
+// returns the fields of the key of a record, which is structured or JSON, or the raw lead id when the list is
+// configured.
+func (w *ListMemberWriter) keyData(r sdk.Record) (map[string]interface{}, error) {
+	switch k := r.Key.(type) {
+	case sdk.StructuredData:
+		return k, nil
+	case sdk.RawData:
+		raw := strings.TrimSpace(string(k))
+		if id, err := strconv.Atoi(raw); err == nil {
+			return map[string]interface{}{fieldLeadID: id}, nil
+		}
+		var fields map[string]interface{}
+		err := json.Unmarshal(k, &fields)
+		if err != nil {
+			return nil, fmt.Errorf("key should be a lead id or hold the %s and %s fields", fieldListID, fieldLeadID)
+		}
+		return fields, nil
+	default:
+		return nil, errors.New("record has no key")
+	}
+}
+
+// returns the list and lead of the fields of a record.
+func (w *ListMemberWriter) parseMember(fields map[string]interface{}) (listMember, error) {
+	member := listMember{listID: w.listID}
+	if value, ok := fields[fieldListID]; ok {
+		member.listID, ok = toInt(value)
+		if !ok {
+			return listMember{}, fmt.Errorf("%s field should be a list id, got %v", fieldListID, value)
+		}
+	}
+	if member.listID == 0 {
+		return listMember{}, fmt.Errorf("record has no %s field and no list is configured", fieldListID)
+	}
+	var ok bool
+	member.leadID, ok = toInt(fields[fieldLeadID])
+	if !ok {
+		return listMember{}, fmt.Errorf("%s field should be a lead id, got %v", fieldLeadID, fields[fieldLeadID])
+	}
+	return member, nil
+}
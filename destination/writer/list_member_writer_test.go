@@ -0,0 +1,118 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/SpeakData/minimarketo"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// handles adds to and removes from given list, removes of leads with given ids are skipped with given reason code.
+func handleList(f *marketotest.Server, listID int, notRemoved map[int]string) {
+	f.Handle(fmt.Sprintf("/rest/v1/lists/%d/leads.json", listID), func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input []struct {
+				ID int `json:"id"`
+			} `json:"input"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		var results []minimarketo.RecordResult
+		for _, lead := range body.Input {
+			result := recordResult(lead.ID, "added", "")
+			if r.Method == http.MethodDelete {
+				result.Status = "removed"
+				if code, ok := notRemoved[lead.ID]; ok {
+					result = recordResult(lead.ID, marketoclient.SyncStatusSkipped, "")
+					result.Reasons = append(result.Reasons, struct {
+						Code    string `json:"code"`
+						Message string `json:"message"`
+					}{Code: code, Message: "Lead not removed"})
+				}
+			}
+			results = append(results, result)
+		}
+		marketotest.WriteResult(w, results, "", false)
+	})
+}
+
+func TestListMemberWriter_Write(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	handleList(f, 1001, map[int]string{3: marketoclient.ReasonLeadNotInList})
+	handleList(f, 1002, nil)
+	var records []sdk.Record
+	for i := 0; i < 301; i++ {
+		records = append(records, testRecord(sdk.OperationSnapshot, fmt.Sprint(i), sdk.StructuredData{"leadId": float64(i + 10)}))
+	}
+	records = append(records,
+		testRecord(sdk.OperationCreate, "other", sdk.StructuredData{"listId": float64(1002), "leadId": float64(1)}),
+		sdk.Record{Operation: sdk.OperationDelete, Key: sdk.RawData("2")},
+		sdk.Record{Operation: sdk.OperationDelete, Key: sdk.StructuredData{"listId": 1001, "leadId": 3}},
+	)
+
+	n, err := NewListMemberWriter(f.Client(t), 1001).Write(ctx, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(records) {
+		t.Errorf("expected %d records written, got %d", len(records), n)
+	}
+	var adds, removes []marketotest.Request
+	for _, r := range f.RequestsTo("/rest/v1/lists/1001/leads.json") {
+		if r.Method == http.MethodDelete {
+			removes = append(removes, r)
+		} else {
+			adds = append(adds, r)
+		}
+	}
+	if len(adds) != 2 || len(decodeBody(t, adds[0])["input"].([]interface{})) != 300 {
+		t.Errorf("expected the adds to the list in 2 batches, got %d", len(adds))
+	}
+	if len(removes) != 1 || fmt.Sprint(decodeBody(t, removes[0])["input"]) != "[map[id:2] map[id:3]]" {
+		t.Errorf("expected the removes from the list in 1 batch, got %v", removes)
+	}
+	if len(f.RequestsTo("/rest/v1/lists/1002/leads.json")) != 1 {
+		t.Errorf("expected the lead of the record with a list id added to its list")
+	}
+}
+
+func TestListMemberWriter_Failures(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	handleList(f, 1001, map[int]string{2: marketoclient.ReasonLeadNotFound})
+	records := []sdk.Record{
+		{Operation: sdk.OperationDelete, Key: sdk.RawData("1")},
+		{Operation: sdk.OperationDelete, Key: sdk.RawData("2")},
+	}
+
+	n, err := NewListMemberWriter(f.Client(t), 1001).Write(ctx, records)
+	if n != 1 || err == nil || !strings.Contains(err.Error(), "record 2: 1004: Lead not removed") {
+		t.Errorf("expected the record before the skipped record written and an error, got %d and %v", n, err)
+	}
+
+	n, err = NewListMemberWriter(f.Client(t), 0).Write(ctx, records)
+	if n != 0 || err == nil || !strings.Contains(err.Error(), "no list is configured") {
+		t.Errorf("expected no record written without a list, got %d and %v", n, err)
+	}
+}
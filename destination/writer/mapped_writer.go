@@ -0,0 +1,81 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"fmt"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/destination/mapping"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// Writer writes records to Marketo, returning the number of records written.
+type Writer interface {
+	Write(ctx context.Context, records []sdk.Record) (int, error)
+}
+
+// FieldReader is a writer reading given record fields, rather than the fields of a Marketo object. The field mappings
+// of these writers map to the fields they read.
+type FieldReader interface {
+	// returns the fields read from the records, with the Marketo data type their mapped values are converted to,
+	// empty if the values are kept as they are.
+	Fields() []marketoclient.ObjectField
+}
+
+// MappedWriter maps the payloads of create, update and snapshot records with a field mapping before writing them with
+// another writer. Delete records are written as they are.
+type MappedWriter struct {
+	writer  Writer          // writer of the mapped records
+	mapping mapping.Mapping // mapping of the payloads
+}
+
+// returns NewMappedWriter which writes the records mapped with given mapping with given writer.
+func NewMappedWriter(writer Writer, mapping mapping.Mapping) *MappedWriter {
+	return &MappedWriter{
+		writer:  writer,
+		mapping: mapping,
+	}
+}
+
+// maps given records, stopping at the first record which couldn't be mapped, and writes the mapped records. returns
+// the number of records written.
+func (w *MappedWriter) Write(ctx context.Context, records []sdk.Record) (int, error) {
+	var mapped = make([]sdk.Record, 0, len(records))
+	var mapErr error
+	for _, r := range records {
+		if r.Operation != sdk.OperationDelete {
+			data, err := recordData(r)
+			if err == nil {
+				data, err = w.mapping.Apply(data)
+			}
+			if err != nil {
+				mapErr = fmt.Errorf("error mapping record %s %w", r.Key.Bytes(), err)
+				break
+			}
+			r.Payload.After = sdk.StructuredData(data)
+		}
+		mapped = append(mapped, r)
+	}
+	if len(mapped) == 0 {
+		return 0, mapErr
+	}
+	written, err := w.writer.Write(ctx, mapped)
+	if err != nil {
+		return written, err
+	}
+	return written, mapErr
+}
@@ -0,0 +1,130 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/destination/mapping"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+)
+
+func TestMappedWriter_Write(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	handleSubscriptions(f)
+	objects, err := NewCustomObjectWriter(f.Client(t), "subscription_c", "dedupeFields")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fieldMapping, err := mapping.Parse("subscriptionId:subscription.id|string; plan:'pro'; leadId:lead|integer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []sdk.Record{
+		testRecord(sdk.OperationCreate, "1", sdk.StructuredData{"subscription": map[string]interface{}{"id": float64(1)}, "lead": "42"}),
+		{Operation: sdk.OperationDelete, Key: sdk.RawData("2")},
+		testRecord(sdk.OperationCreate, "3", sdk.StructuredData{"subscription": map[string]interface{}{"id": float64(3)}, "lead": "jane"}),
+	}
+
+	n, err := NewMappedWriter(objects, fieldMapping).Write(ctx, records)
+	if n != 2 || err == nil || !strings.Contains(err.Error(), "error mapping record 3") {
+		t.Errorf("expected the records before the record which couldn't be mapped written and an error, got %d and %v", n, err)
+	}
+	upserts := f.RequestsTo("/rest/v1/customobjects/subscription_c.json")
+	if len(upserts) != 1 || fmt.Sprint(decodeBody(t, upserts[0])["input"]) != "[map[leadId:42 plan:pro subscriptionId:1]]" {
+		t.Errorf("expected the mapped record upserted, got %v", upserts)
+	}
+	deletes := f.RequestsTo("/rest/v1/customobjects/subscription_c/delete.json")
+	if len(deletes) != 1 || fmt.Sprint(decodeBody(t, deletes[0])["input"]) != "[map[subscriptionId:2]]" {
+		t.Errorf("expected the delete written as it is, got %v", deletes)
+	}
+}
+
+func TestFieldReader_Fields(t *testing.T) {
+	f := marketotest.NewServer(t)
+	handleFeatureUsed(f, "approved")
+	activities, err := NewCustomActivityWriter(f.Client(t), 100001, "", map[string]string{"seats": "licenses"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		reader  FieldReader
+		rules   string
+		want    string // types of the validated rules
+		wantErr string
+	}{
+		{
+			name:   "Program members",
+			reader: NewProgramMemberWriter(f.Client(t), "email"),
+			rules:  "programId:program; status:'Registered'; email:user.email",
+			want:   "[integer string ]",
+		},
+		{
+			name:   "List members",
+			reader: NewListMemberWriter(f.Client(t), 0),
+			rules:  "listId:list; leadId:lead",
+			want:   "[integer integer]",
+		},
+		{
+			name:   "Custom activities",
+			reader: activities,
+			rules:  "leadId:lead; activityDate:usedAt; feature:name; licenses:seats",
+			want:   "[integer datetime string integer]",
+		},
+		{
+			name:   "Trigger campaign",
+			reader: NewCampaignWriter(f.Client(t), 2001, map[string]string{"{{my.plan}}": "plan"}),
+			rules:  "leadIds:leads; plan:subscription.plan|string",
+			want:   "[ string]",
+		},
+		{
+			name:    "Field not read",
+			reader:  NewListMemberWriter(f.Client(t), 0),
+			rules:   "leadId:lead; email:user.email",
+			wantErr: "fields email don't exist",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fieldMapping, err := mapping.Parse(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			validated, err := fieldMapping.Validate(tt.reader.Fields())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var types []string
+			for _, rule := range validated {
+				types = append(types, rule.Type)
+			}
+			if fmt.Sprint(types) != tt.want {
+				t.Errorf("expected the types %s, got %v", tt.want, types)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/SpeakData/minimarketo"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+)
+
+// returns the result of a record with given status, and reason if any.
+func recordResult(id int, status string, reason string) minimarketo.RecordResult {
+	result := minimarketo.RecordResult{ID: id, Status: status}
+	if reason != "" {
+		result.Reasons = append(result.Reasons, struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}{Code: "1006", Message: reason})
+	}
+	return result
+}
+
+// returns a record with given operation, key and structured payload.
+func testRecord(operation sdk.Operation, key string, payload sdk.StructuredData) sdk.Record {
+	return sdk.Record{
+		Operation: operation,
+		Key:       sdk.RawData(key),
+		Payload:   sdk.Change{After: payload},
+	}
+}
+
+// decodes the body of a request.
+func decodeBody(t *testing.T, r marketotest.Request) map[string]interface{} {
+	var body map[string]interface{}
+	err := json.Unmarshal(r.Body, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
@@ -0,0 +1,179 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/SpeakData/minimarketo"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// fields of the records written by ProgramMemberWriter
+const (
+	fieldProgramID = "programId"
+	fieldStatus    = "status"
+)
+
+// ProgramMemberWriter writes records to the status of leads in programs. Create, update and snapshot records hold the
+// program id, the status and the lookup field of the lead, and change the status of the lead in the program.
+type ProgramMemberWriter struct {
+	client      *marketoclient.Client // marketo client
+	lookupField string                // lead field resolving the leads of the records
+}
+
+// returns NewProgramMemberWriter which changes the status of program members, resolving their leads by given lookup
+// field, or by lead id if the lookup field is id.
+func NewProgramMemberWriter(client *marketoclient.Client, lookupField string) *ProgramMemberWriter {
+	return &ProgramMemberWriter{
+		client:      client,
+		lookupField: lookupField,
+	}
+}
+
+// returns the program, status and lookup fields read from the records.
+func (w *ProgramMemberWriter) Fields() []marketoclient.ObjectField {
+	lookup := marketoclient.ObjectField{Name: w.lookupField}
+	if w.lookupField == "id" {
+		lookup.DataType = "integer"
+	}
+	return []marketoclient.ObjectField{
+		{Name: fieldProgramID, DataType: "integer"},
+		{Name: fieldStatus, DataType: "string"},
+		lookup,
+	}
+}
+
+// status change of a lead in a program, read from a record
+type memberStatus struct {
+	programID int
+	status    string
+	leadID    int    // id of the lead, if the lookup field is id
+	lookup    string // value of the lookup field, otherwise
+}
+
+// program and status of a group of status changes made by a single call
+type programStatus struct {
+	programID int
+	status    string
+}
+
+// writes given records grouped by program and status, stopping at the first record which couldn't be written. Deletes
+// are not supported. returns the number of records written.
+func (w *ProgramMemberWriter) Write(ctx context.Context, records []sdk.Record) (int, error) {
+	return writeBatches(ctx, records, len(records), w.changeStatus, w.delete)
+}
+
+// deletes are not supported, program members keep their status.
+func (w *ProgramMemberWriter) delete(ctx context.Context, records []sdk.Record) (int, error) {
+	return 0, fmt.Errorf("%w %s of record %s, program members can't be deleted", ErrUnsupportedOperation, records[0].Operation, records[0].Key.Bytes())
+}
+
+// changes the status of the program members of given records.
+func (w *ProgramMemberWriter) changeStatus(ctx context.Context, records []sdk.Record) (int, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "changeStatus").Logger()
+	logger.Trace().Msgf("Changing the status of %d program members", len(records))
+
+	var members = make([]memberStatus, 0, len(records))
+	var readErr error
+	for _, r := range records {
+		member, err := w.parseMember(r)
+		if err != nil {
+			readErr = fmt.Errorf("error reading record %s %w", r.Key.Bytes(), err)
+			break
+		}
+		members = append(members, member)
+	}
+	written, err := w.changeStatuses(ctx, records[:len(members)], members)
+	if err != nil {
+		return written, err
+	}
+	return written, readErr
+}
+
+// changes given statuses, read from given records, with a call by program and status for at most MaxSyncRecords leads.
+func (w *ProgramMemberWriter) changeStatuses(ctx context.Context, records []sdk.Record, members []memberStatus) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	var lookups []string
+	for _, member := range members {
+		if member.lookup != "" {
+			lookups = append(lookups, member.lookup)
+		}
+	}
+	leadIDs, err := leadIDsBy(w.client, w.lookupField, lookups)
+	if err != nil {
+		return 0, fmt.Errorf("error looking up leads by %s %w", w.lookupField, err)
+	}
+
+	var failures = make(map[int]string)
+	groups := newLeadGroups(len(members))
+	for i, member := range members {
+		memberIDs := []int{member.leadID}
+		if member.lookup != "" {
+			memberIDs = leadIDs[strings.ToLower(member.lookup)]
+		}
+		if len(memberIDs) == 0 {
+			failures[i] = fmt.Sprintf("no lead with %s %s", w.lookupField, member.lookup)
+			continue
+		}
+		groups.add(programStatus{programID: member.programID, status: member.status}, i, memberIDs)
+	}
+	written, err := groups.write(marketoclient.MaxSyncRecords, failures, func(group interface{}, ids []int) ([]minimarketo.RecordResult, error) {
+		ps := group.(programStatus)
+		results, err := w.client.ChangeProgramMemberStatus(ps.programID, ps.status, ids)
+		if err != nil {
+			return nil, fmt.Errorf("error changing status of members of program %d to %q %w", ps.programID, ps.status, err)
+		}
+		return results, nil
+	}, noneIgnored)
+	if err != nil {
+		return written, err
+	}
+	return failuresError(ctx, records, failures)
+}
+
+// returns the program, status and lead of a record.
+func (w *ProgramMemberWriter) parseMember(r sdk.Record) (memberStatus, error) {
+	data, err := recordData(r)
+	if err != nil {
+		return memberStatus{}, err
+	}
+	programID, ok := toInt(data[fieldProgramID])
+	if !ok {
+		return memberStatus{}, fmt.Errorf("%s field should be a program id, got %v", fieldProgramID, data[fieldProgramID])
+	}
+	status, ok := data[fieldStatus].(string)
+	if !ok || status == "" {
+		return memberStatus{}, fmt.Errorf("%s field should be a program member status, got %v", fieldStatus, data[fieldStatus])
+	}
+	member := memberStatus{programID: programID, status: status}
+	if w.lookupField == "id" {
+		member.leadID, ok = toInt(data["id"])
+		if !ok {
+			return memberStatus{}, fmt.Errorf("id field should be a lead id, got %v", data["id"])
+		}
+		return member, nil
+	}
+	member.lookup = formatValue(data[w.lookupField])
+	if member.lookup == "" {
+		return memberStatus{}, fmt.Errorf("record has no %s field", w.lookupField)
+	}
+	return member, nil
+}
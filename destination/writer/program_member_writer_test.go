@@ -0,0 +1,115 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/SpeakData/minimarketo"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// handles status changes in given programs, skipping the leads with given ids.
+func handleStatusChanges(f *marketotest.Server, programIDs []int, skipped map[int]bool) {
+	for _, programID := range programIDs {
+		f.Handle(fmt.Sprintf("/rest/v1/leads/programs/%d/status.json", programID), func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				Input []struct {
+					ID int `json:"id"`
+				} `json:"input"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			var results []minimarketo.RecordResult
+			for _, lead := range body.Input {
+				if skipped[lead.ID] {
+					results = append(results, recordResult(lead.ID, marketoclient.SyncStatusSkipped, "Invalid status"))
+					continue
+				}
+				results = append(results, recordResult(lead.ID, marketoclient.SyncStatusUpdated, ""))
+			}
+			marketotest.WriteResult(w, results, "", false)
+		})
+	}
+}
+
+func TestProgramMemberWriter_Write(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/leads.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []map[string]interface{}{
+			{"id": 1, "email": "a@example.com"},
+			{"id": 2, "email": "B@example.com"},
+			{"id": 3, "email": "c@example.com"},
+		}, "", false)
+	})
+	handleStatusChanges(f, []int{1001, 1002}, nil)
+	records := []sdk.Record{
+		testRecord(sdk.OperationCreate, "a", sdk.StructuredData{"programId": float64(1001), "status": "Registered", "email": "a@example.com"}),
+		testRecord(sdk.OperationCreate, "b", sdk.StructuredData{"programId": "1002", "status": "Attended", "email": "b@example.com"}),
+		testRecord(sdk.OperationSnapshot, "c", sdk.StructuredData{"programId": float64(1001), "status": "Registered", "email": "c@example.com"}),
+	}
+
+	n, err := NewProgramMemberWriter(f.Client(t), "email").Write(ctx, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(records) {
+		t.Errorf("expected %d records written, got %d", len(records), n)
+	}
+	if lookups := f.RequestsTo("/rest/v1/leads.json"); len(lookups) != 1 {
+		t.Errorf("expected the leads looked up at once, got %d lookups", len(lookups))
+	}
+	registered := f.RequestsTo("/rest/v1/leads/programs/1001/status.json")
+	if len(registered) != 1 {
+		t.Fatalf("expected a single call for the registered members, got %d", len(registered))
+	}
+	body := decodeBody(t, registered[0])
+	if body["status"] != "Registered" || fmt.Sprint(body["input"]) != "[map[id:1] map[id:3]]" {
+		t.Errorf("unexpected status change %v", body)
+	}
+	attended := f.RequestsTo("/rest/v1/leads/programs/1002/status.json")
+	if len(attended) != 1 || decodeBody(t, attended[0])["status"] != "Attended" {
+		t.Errorf("expected a single call for the attended members, got %v", attended)
+	}
+}
+
+func TestProgramMemberWriter_Failures(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	handleStatusChanges(f, []int{1001}, map[int]bool{3: true})
+	records := []sdk.Record{
+		testRecord(sdk.OperationCreate, "a", sdk.StructuredData{"programId": float64(1001), "status": "Registered", "id": float64(1)}),
+		testRecord(sdk.OperationCreate, "b", sdk.StructuredData{"programId": float64(1001), "status": "Registered", "id": float64(3)}),
+		testRecord(sdk.OperationCreate, "c", sdk.StructuredData{"programId": float64(1001), "status": "Registered"}),
+	}
+
+	n, err := NewProgramMemberWriter(f.Client(t), "id").Write(ctx, records)
+	if n != 1 {
+		t.Errorf("expected the records before the first skipped record written, got %d", n)
+	}
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 records were skipped by marketo, record b: 1006: Invalid status") {
+		t.Errorf("expected an error with the skipped record, got %v", err)
+	}
+	if len(f.RequestsTo("/rest/v1/leads.json")) != 0 {
+		t.Errorf("expected no lookup of leads by id")
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SpeakData/minimarketo"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+var ErrUnsupportedOperation = errors.New("unsupported operation")
+
+// returns the payload of a record, structured or JSON encoded, as a map of fields.
+func recordData(r sdk.Record) (map[string]interface{}, error) {
+	switch data := r.Payload.After.(type) {
+	case sdk.StructuredData:
+		return data, nil
+	case sdk.RawData:
+		var fields map[string]interface{}
+		err := json.Unmarshal(data, &fields)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling payload of record %s %w", r.Key.Bytes(), err)
+		}
+		return fields, nil
+	default:
+		return nil, fmt.Errorf("record %s has no payload", r.Key.Bytes())
+	}
+}
+
+// returns the number of leading records which can be written together, which are at most max records whose
+// operations are both deletes or both not deletes.
+func batchLen(records []sdk.Record, max int) int {
+	n := 0
+	for n < len(records) && n < max && (records[n].Operation == sdk.OperationDelete) == (records[0].Operation == sdk.OperationDelete) {
+		n++
+	}
+	return n
+}
+
+// writes given records in batches of at most max records, with upsert for the records which aren't deletes and with
+// remove for deletes. returns the number of records written and the error which stopped writing, if any.
+func writeBatches(ctx context.Context, records []sdk.Record, max int, upsert, remove func(ctx context.Context, records []sdk.Record) (int, error)) (int, error) {
+	written := 0
+	for written < len(records) {
+		batch := records[written : written+batchLen(records[written:], max)]
+		write := upsert
+		if batch[0].Operation == sdk.OperationDelete {
+			write = remove
+		}
+		n, err := write(ctx, batch)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// returns the number of records written before the first record which couldn't be written according to results,
+// and an error listing every record which couldn't be written with its reasons.
+func checkResults(ctx context.Context, records []sdk.Record, results []minimarketo.RecordResult) (int, error) {
+	if len(results) != len(records) {
+		return 0, fmt.Errorf("unexpected number of results from marketo rest api, got %d for %d records", len(results), len(records))
+	}
+	var failures = make(map[int]string)
+	for i, result := range results {
+		if result.Status == marketoclient.SyncStatusSkipped {
+			failures[i] = marketoclient.ResultReasons(result)
+		}
+	}
+	return failuresError(ctx, records, failures)
+}
+
+// returns the number of records written before the first record which couldn't be written, and an error listing
+// every record which couldn't be written with its reasons, given by record index.
+func failuresError(ctx context.Context, records []sdk.Record, failures map[int]string) (int, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "failuresError").Logger()
+
+	if len(failures) == 0 {
+		return len(records), nil
+	}
+	var indexes = make([]int, 0, len(failures))
+	for i := range failures {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	var messages = make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		logger.Error().Msgf("Record %s was skipped: %s", records[i].Key.Bytes(), failures[i])
+		messages = append(messages, fmt.Sprintf("record %s: %s", records[i].Key.Bytes(), failures[i]))
+	}
+	return indexes[0], fmt.Errorf("%d of %d records were skipped by marketo, %s", len(failures), len(records), strings.Join(messages, ", "))
+}
+
+// leads of records grouped by the calls writing them, like the leads whose status is changed to the same status in the
+// same program. Groups are comparable values identifying the calls.
+type leadGroups struct {
+	groups    []interface{}         // groups in the order of their first record
+	ids       map[interface{}][]int // ids of the leads of every group
+	owners    map[interface{}][]int // index of the record of every lead of every group
+	remaining []int                 // number of leads of every record which aren't written yet
+}
+
+func newLeadGroups(records int) *leadGroups {
+	return &leadGroups{
+		ids:       make(map[interface{}][]int),
+		owners:    make(map[interface{}][]int),
+		remaining: make([]int, records),
+	}
+}
+
+// adds given leads of the record with given index to given group.
+func (g *leadGroups) add(group interface{}, owner int, ids []int) {
+	if _, ok := g.ids[group]; !ok {
+		g.groups = append(g.groups, group)
+	}
+	for _, id := range ids {
+		g.ids[group], g.owners[group] = append(g.ids[group], id), append(g.owners[group], owner)
+	}
+	g.remaining[owner] += len(ids)
+}
+
+// writes the leads of every group with calls of at most max leads, adding the records whose leads are skipped to
+// failures, unless ignore returns true for the result. returns the number of records written before the first record
+// which failed or isn't written when a call fails, and the error of the call.
+func (g *leadGroups) write(max int, failures map[int]string, call func(group interface{}, ids []int) ([]minimarketo.RecordResult, error), ignore func(result minimarketo.RecordResult) bool) (int, error) {
+	for _, group := range g.groups {
+		ids, owners := g.ids[group], g.owners[group]
+		for start := 0; start < len(ids); start += max {
+			end := start + max
+			if end > len(ids) {
+				end = len(ids)
+			}
+			results, err := call(group, ids[start:end])
+			if err == nil && len(results) != end-start {
+				err = fmt.Errorf("unexpected number of results from marketo rest api, got %d for %d leads", len(results), end-start)
+			}
+			if err != nil {
+				return g.firstPending(failures), err
+			}
+			for j, result := range results {
+				owner := owners[start+j]
+				g.remaining[owner]--
+				if result.Status == marketoclient.SyncStatusSkipped && !ignore(result) {
+					failures[owner] = marketoclient.ResultReasons(result)
+				}
+			}
+		}
+	}
+	return len(g.remaining), nil
+}
+
+// returns the number of records written before the first record which failed or whose leads aren't all written yet.
+func (g *leadGroups) firstPending(failures map[int]string) int {
+	for i, n := range g.remaining {
+		if _, failed := failures[i]; failed || n > 0 {
+			return i
+		}
+	}
+	return len(g.remaining)
+}
+
+// ignores none of the skipped results.
+func noneIgnored(minimarketo.RecordResult) bool {
+	return false
+}
+
+// returns the integer value of a number, or of a string holding an integer, and true if the value is one.
+func toInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), v == float64(int(v))
+	case json.Number:
+		n, err := v.Int64()
+		return int(n), err == nil
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// returns a field value as text, like a CSV value or a query filter value, which is empty for a missing value, and
+// JSON for values which aren't strings, numbers, booleans or dates. Dates are formatted as RFC 3339 in UTC.
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case int, int64, json.Number:
+		return fmt.Sprint(v)
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(raw)
+	}
+}
@@ -8,12 +8,12 @@ require (
 	github.com/goombaio/namegenerator v0.0.0-20181006234301-989e774b106e
 	github.com/jpillora/backoff v1.0.0
 	golang.org/x/sync v0.0.0-20220513210516-0976fa681c29
+	golang.org/x/time v0.0.0-20220922220347-f3bd1da661af
 	gopkg.in/tomb.v2 v2.0.0-20161208151619-d5d1b5820637
 )
 
 require (
 	go.buf.build/grpc/go/conduitio/conduit-connector-protocol v1.4.3 // indirect
-	golang.org/x/time v0.0.0-20220922220347-f3bd1da661af // indirect
 )
 
 require (
@@ -0,0 +1,132 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package marketotest provides a fake Marketo REST API for the tests of the source and destination.
+package marketotest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/SpeakData/minimarketo"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+// Request is a request received by the Server.
+type Request struct {
+	Method string
+	URL    *url.URL
+	Body   []byte
+}
+
+// Server is a minimal Marketo REST API serving registered handlers by path, or by path prefix. Requests to paths
+// without a handler fail the test.
+type Server struct {
+	*httptest.Server
+	mu       sync.Mutex
+	handlers map[string]http.HandlerFunc
+	prefixes map[string]http.HandlerFunc
+	requests []Request
+}
+
+// returns NewServer which issues access tokens, and is closed when the test finishes.
+func NewServer(t *testing.T) *Server {
+	s := &Server{handlers: make(map[string]http.HandlerFunc), prefixes: make(map[string]http.HandlerFunc)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{Method: r.Method, URL: r.URL, Body: body})
+		handler, ok := s.handlers[r.URL.Path]
+		if !ok {
+			var longest string
+			for prefix, h := range s.prefixes {
+				if strings.HasPrefix(r.URL.Path, prefix) && len(prefix) > len(longest) {
+					longest, handler, ok = prefix, h, true
+				}
+			}
+		}
+		s.mu.Unlock()
+		if !ok {
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		handler(w, r)
+	}))
+	s.Handle("/identity/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(minimarketo.AuthToken{AccessToken: "token", ExpiresIn: 3600})
+	})
+	t.Cleanup(s.Close)
+	return s
+}
+
+// serves the requests to path with handler.
+func (s *Server) Handle(path string, handler http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[path] = handler
+}
+
+// serves the requests to paths starting with prefix which have no handler of their own. The longest matching prefix
+// wins.
+func (s *Server) HandlePrefix(prefix string, handler http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prefixes[prefix] = handler
+}
+
+// returns the requests made to given path, oldest first.
+func (s *Server) RequestsTo(path string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var requests []Request
+	for _, r := range s.requests {
+		if r.URL.Path == path {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// returns a client of the server.
+func (s *Server) Client(t *testing.T) *marketoclient.Client {
+	client, err := marketoclient.NewClient(minimarketo.ClientConfig{
+		ID:       "id",
+		Secret:   "secret",
+		Endpoint: s.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &client
+}
+
+// writes a successful Marketo response with given result and paging.
+func WriteResult(w http.ResponseWriter, result interface{}, nextPageToken string, moreResult bool) {
+	raw, _ := json.Marshal(result)
+	_ = json.NewEncoder(w).Encode(minimarketo.Response{
+		Success:       true,
+		Result:        raw,
+		NextPageToken: nextPageToken,
+		MoreResult:    moreResult,
+	})
+}
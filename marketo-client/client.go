@@ -15,18 +15,23 @@
 package marketoclient
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/SpeakData/minimarketo"
 	"github.com/jpillora/backoff"
+	"golang.org/x/time/rate"
 )
 
 var (
@@ -49,10 +54,101 @@ func NewClient(config minimarketo.ClientConfig) (Client, error) {
 	return Client{client}, nil
 }
 
+// Marketo allows 100 REST API calls per 20 seconds and 10 concurrent calls per instance.
+const (
+	RateLimitCalls  = 100
+	RateLimitWindow = 20 * time.Second
+	RateLimitBurst  = 10
+)
+
+// returns a limiter keeping the calls within the Marketo rate limit. The burst is taken from the calls of the window,
+// so a full burst followed by calls at the limiter rate stays within the limit.
+func NewRateLimiter() *rate.Limiter {
+	return rate.NewLimiter(rate.Every(RateLimitWindow/(RateLimitCalls-RateLimitBurst)), RateLimitBurst)
+}
+
+// returns a copy of the client waiting for given limiter before every call. Copies of the returned client share the
+// limiter, so a limiter shared by several iterators keeps all their calls within the rate limit. Since the minimarketo
+// client isn't safe for concurrent use, the calls of the copies are serialized as well. Canceling ctx stops the calls
+// waiting for the limiter, so the waits don't outlive the connector.
+func (c Client) WithRateLimit(ctx context.Context, limiter *rate.Limiter) Client {
+	return Client{rateLimitedClient{Client: c.Client, ctx: ctx, limiter: limiter, mu: &sync.Mutex{}}}
+}
+
+// minimarketo client waiting for the limiter before every call, and making one call at a time.
+type rateLimitedClient struct {
+	minimarketo.Client
+	ctx     context.Context // cancels the waits for the limiter
+	limiter *rate.Limiter
+	mu      *sync.Mutex // held during the call only, not while waiting for the limiter
+}
+
+func (c rateLimitedClient) Get(resource string) (*minimarketo.Response, error) {
+	err := c.limiter.Wait(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Client.Get(resource)
+}
+
+func (c rateLimitedClient) Post(resource string, data []byte) (*minimarketo.Response, error) {
+	err := c.limiter.Wait(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Client.Post(resource, data)
+}
+
+func (c rateLimitedClient) Delete(resource string, data []byte) (*minimarketo.Response, error) {
+	err := c.limiter.Wait(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Client.Delete(resource, data)
+}
+
+func (c rateLimitedClient) RefreshToken() (minimarketo.AuthToken, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Client.RefreshToken()
+}
+
+func (c rateLimitedClient) GetTokenInfo() minimarketo.TokenInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Client.GetTokenInfo()
+}
+
+// waits for the limiter of the client, if any, before a call which isn't made by the minimarketo client.
+func (c Client) wait(ctx context.Context) error {
+	if limited, ok := c.Client.(rateLimitedClient); ok {
+		return limited.limiter.Wait(ctx)
+	}
+	return nil
+}
+
+// objects supported by bulk export jobs.
+const (
+	ExportObjectLeads          = "leads"
+	ExportObjectActivities     = "activities"
+	ExportObjectProgramMembers = "program/members"
+)
+
+// returns the bulk export object of the custom object with given API name.
+func ExportObjectCustomObject(apiName string) string {
+	return "customobjects/" + apiName
+}
+
 // creates New exportLeads job for given time range with requested fields. Maximum time range will be 31 days.
 // return export id and error.
 func (c Client) CreateExportLeads(fields []string, startDate string, endDate string) (string, error) {
-	reqBody, err := json.Marshal(map[string]interface{}{
+	return c.createExport(ExportObjectLeads, map[string]interface{}{
 		"filter": map[string]interface{}{
 			"createdAt": map[string]string{
 				"startAt": startDate,
@@ -61,10 +157,57 @@ func (c Client) CreateExportLeads(fields []string, startDate string, endDate str
 		},
 		"fields": fields,
 	})
+}
+
+// creates New exportActivities job for given time range, restricted to given activity types if any.
+// Maximum time range will be 31 days. return export id and error.
+func (c Client) CreateExportActivities(activityTypeIDs []int, startDate string, endDate string) (string, error) {
+	filter := map[string]interface{}{
+		"createdAt": map[string]string{
+			"startAt": startDate,
+			"endAt":   endDate,
+		},
+	}
+	if len(activityTypeIDs) > 0 {
+		filter["activityTypeIds"] = activityTypeIDs
+	}
+	return c.createExport(ExportObjectActivities, map[string]interface{}{
+		"filter": filter,
+	})
+}
+
+// creates New exportProgramMembers job for the members of given program with requested fields.
+// return export id and error.
+func (c Client) CreateExportProgramMembers(programID int, fields []string) (string, error) {
+	return c.createExport(ExportObjectProgramMembers, map[string]interface{}{
+		"filter": map[string]interface{}{
+			"programId": programID,
+		},
+		"fields": fields,
+	})
+}
+
+// creates New exportCustomObjects job of the custom object with given API name for records updated in given time
+// range, with requested fields. Maximum time range will be 31 days. return export id and error.
+func (c Client) CreateExportCustomObjects(apiName string, fields []string, startDate string, endDate string) (string, error) {
+	return c.createExport(ExportObjectCustomObject(apiName), map[string]interface{}{
+		"filter": map[string]interface{}{
+			"updatedAt": map[string]string{
+				"startAt": startDate,
+				"endAt":   endDate,
+			},
+		},
+		"fields": fields,
+	})
+}
+
+// creates New export job of given object with given request body. return export id and error.
+func (c Client) createExport(object string, body map[string]interface{}) (string, error) {
+	reqBody, err := json.Marshal(body)
 	if err != nil {
 		return "", err
 	}
-	path := "/bulk/v1/leads/export/create.json"
+	path := fmt.Sprintf("/bulk/v1/%s/export/create.json", object)
 	response, err := c.Post(path, reqBody)
 	if err != nil {
 		return "", err
@@ -89,9 +232,9 @@ type CreateExportResult struct {
 	CreatedAt time.Time `json:"createdAt"`
 }
 
-// enqueues export job.
-func (c Client) EnqueueExportLeads(exportID string) (string, error) {
-	path := fmt.Sprintf("/bulk/v1/leads/export/%s/enqueue.json", exportID)
+// enqueues export job of given object.
+func (c Client) EnqueueExport(object string, exportID string) (string, error) {
+	path := fmt.Sprintf("/bulk/v1/%s/export/%s/enqueue.json", object, exportID)
 	response, err := c.Post(path, nil)
 	if err != nil {
 		return "", err
@@ -105,9 +248,16 @@ func (c Client) EnqueueExportLeads(exportID string) (string, error) {
 	return exportID, nil
 }
 
-// returns current status of export job with error.
-func (c Client) StatusOfExportLeads(exportID string) (StatusOfExportResult, error) {
-	path := fmt.Sprintf("/bulk/v1/leads/export/%s/status.json", exportID)
+// enqueues export job of leads.
+//
+// Deprecated: use EnqueueExport with ExportObjectLeads.
+func (c Client) EnqueueExportLeads(exportID string) (string, error) {
+	return c.EnqueueExport(ExportObjectLeads, exportID)
+}
+
+// returns current status of export job of given object with error.
+func (c Client) StatusOfExport(object string, exportID string) (StatusOfExportResult, error) {
+	path := fmt.Sprintf("/bulk/v1/%s/export/%s/status.json", object, exportID)
 	response, err := c.Get(path)
 	if err != nil {
 		return StatusOfExportResult{}, err
@@ -125,6 +275,13 @@ func (c Client) StatusOfExportLeads(exportID string) (StatusOfExportResult, erro
 	return result[0], nil
 }
 
+// returns current status of export job of leads with error.
+//
+// Deprecated: use StatusOfExport with ExportObjectLeads.
+func (c Client) StatusOfExportLeads(exportID string) (StatusOfExportResult, error) {
+	return c.StatusOfExport(ExportObjectLeads, exportID)
+}
+
 type StatusOfExportResult struct {
 	ExportID        string    `json:"exportId"`
 	Format          string    `json:"format"`
@@ -138,9 +295,9 @@ type StatusOfExportResult struct {
 	FileChecksum    string    `json:"fileChecksum"`
 }
 
-// cancels export job.
-func (c Client) CancelExportLeads(exportID string) error {
-	path := fmt.Sprintf("/bulk/v1/leads/export/%s/cancel.json", exportID)
+// cancels export job of given object.
+func (c Client) CancelExport(object string, exportID string) error {
+	path := fmt.Sprintf("/bulk/v1/%s/export/%s/cancel.json", object, exportID)
 	response, err := c.Post(path, nil)
 	if err != nil {
 		return err
@@ -154,9 +311,32 @@ func (c Client) CancelExportLeads(exportID string) error {
 	return nil
 }
 
-// returns export job result in CSV format.
+// cancels export job of leads.
+//
+// Deprecated: use CancelExport with ExportObjectLeads.
+func (c Client) CancelExportLeads(exportID string) error {
+	return c.CancelExport(ExportObjectLeads, exportID)
+}
+
+// returns export job result of given object in CSV format.
+func (c Client) FileExport(ctx context.Context, endpoint string, object string, exportID string) (*[]byte, error) {
+	path := fmt.Sprintf("/bulk/v1/%s/export/%s/file.json", object, exportID)
+	body, err := c.getFile(ctx, endpoint, path)
+	if err != nil {
+		return nil, err
+	}
+	return &body, nil
+}
+
+// returns export job result of leads in CSV format.
+//
+// Deprecated: use FileExport with ExportObjectLeads.
 func (c Client) FileExportLeads(ctx context.Context, endpoint string, exportID string) (*[]byte, error) {
-	path := fmt.Sprintf("/bulk/v1/leads/export/%s/file.json", exportID)
+	return c.FileExport(ctx, endpoint, ExportObjectLeads, exportID)
+}
+
+// returns the file at given path of marketo bulk api, which isn't a JSON response the minimarketo client could read.
+func (c Client) getFile(ctx context.Context, endpoint string, path string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", endpoint+path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
@@ -165,17 +345,17 @@ func (c Client) FileExportLeads(ctx context.Context, endpoint string, exportID s
 	if err != nil {
 		return nil, fmt.Errorf("failed to get auth token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer"+token)
+	req.Header.Set("Authorization", "Bearer "+token)
+	err = c.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
 	response, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get response: %v", err)
 	}
 	defer response.Body.Close()
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
-	}
-	return &body, nil
+	return io.ReadAll(response.Body)
 }
 
 // returns token for marketo rest api.
@@ -216,6 +396,55 @@ type FolderResult struct {
 	} `json:"folderId"`
 }
 
+// asset types supported by ListAssets.
+const (
+	AssetPrograms       = "programs"
+	AssetSmartCampaigns = "smartCampaigns"
+	AssetEmails         = "emails"
+	AssetForms          = "forms"
+	AssetLandingPages   = "landingPages"
+	AssetFolders        = "folders"
+	AssetTagTypes       = "tagTypes"
+)
+
+// MaxAssetsReturn is the maximum number of assets returned by a single Asset API browse request.
+const MaxAssetsReturn = 200
+
+// returns a page of assets of given type, like AssetPrograms, from marketo asset api.
+func (c Client) ListAssets(asset string, offset int, maxReturn int) (*minimarketo.Response, error) {
+	path := fmt.Sprintf("/rest/asset/v1/%s.json?offset=%d&maxReturn=%d", asset, offset, maxReturn)
+	response, err := c.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("%+v", response.Errors)
+	}
+	return response, nil
+}
+
+// AssetsFilteredByUpdatedAt lists the asset types whose browse API filters by updatedAt, see ListUpdatedAssets.
+var AssetsFilteredByUpdatedAt = map[string]bool{
+	AssetPrograms:       true,
+	AssetSmartCampaigns: true,
+	AssetEmails:         true,
+}
+
+// returns a page of assets of given type updated between earliest and latest, from marketo asset api. The asset
+// type must be one of AssetsFilteredByUpdatedAt.
+func (c Client) ListUpdatedAssets(asset string, earliest time.Time, latest time.Time, offset int, maxReturn int) (*minimarketo.Response, error) {
+	path := fmt.Sprintf("/rest/asset/v1/%s.json?earliestUpdatedAt=%s&latestUpdatedAt=%s&offset=%d&maxReturn=%d",
+		asset, earliest.UTC().Format(time.RFC3339), latest.UTC().Format(time.RFC3339), offset, maxReturn)
+	response, err := c.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("%+v", response.Errors)
+	}
+	return response, nil
+}
+
 // returnss nextPageToken from marketo rest api.
 func (c Client) GetNextPageToken(sinceTime time.Time) (string, error) {
 	formattedTime := sinceTime.UTC().Format(time.RFC3339)
@@ -232,7 +461,23 @@ func (c Client) GetNextPageToken(sinceTime time.Time) (string, error) {
 
 // returns updated leads from marketo rest api.
 func (c Client) GetLeadChanges(nextPageToken string, fields []string) (*minimarketo.Response, error) {
-	path := fmt.Sprintf("/rest/v1/activities/leadchanges.json?nextPageToken=%s&fields=%s", nextPageToken, strings.Join(fields, ","))
+	path := fmt.Sprintf("/rest/v1/activities/leadchanges.json?nextPageToken=%s&fields=%s", url.QueryEscape(nextPageToken), strings.Join(fields, ","))
+	response, err := c.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("%+v", response.Errors)
+	}
+	return response, nil
+}
+
+// MaxActivityTypeIDs is the maximum number of activity type ids accepted by a single activities request.
+const MaxActivityTypeIDs = 10
+
+// returns activities of given types from marketo rest api.
+func (c Client) GetActivities(nextPageToken string, activityTypeIDs []int) (*minimarketo.Response, error) {
+	path := fmt.Sprintf("/rest/v1/activities.json?nextPageToken=%s&activityTypeIds=%s", url.QueryEscape(nextPageToken), JoinInts(activityTypeIDs))
 	response, err := c.Get(path)
 	if err != nil {
 		return nil, err
@@ -243,9 +488,162 @@ func (c Client) GetLeadChanges(nextPageToken string, fields []string) (*minimark
 	return response, nil
 }
 
+// returns all activity types from marketo rest api.
+func (c Client) GetActivityTypes() ([]ActivityType, error) {
+	response, err := c.Get("/rest/v1/activities/types.json")
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("%+v", response.Errors)
+	}
+	var result []ActivityType
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+type ActivityType struct {
+	ID               int                     `json:"id"`
+	APIName          string                  `json:"apiName"` // custom activity types only
+	Name             string                  `json:"name"`
+	Description      string                  `json:"description"`
+	Status           string                  `json:"status"` // custom activity types only
+	PrimaryAttribute ActivityTypeAttribute   `json:"primaryAttribute"`
+	Attributes       []ActivityTypeAttribute `json:"attributes"`
+}
+
+type ActivityTypeAttribute struct {
+	APIName  string `json:"apiName"` // custom activity types only
+	Name     string `json:"name"`
+	DataType string `json:"dataType"`
+}
+
+// returns custom activity types from marketo rest api.
+func (c Client) GetCustomActivityTypes() ([]ActivityType, error) {
+	response, err := c.Get("/rest/v1/activities/external/types.json")
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("%+v", response.Errors)
+	}
+	var result []ActivityType
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// returns ids of given activity types, each given either as id or as name. Names are matched case-insensitively
+// against the activity types of the instance.
+func (c Client) ResolveActivityTypeIDs(activityTypes []string) ([]int, error) {
+	var ids = make([]int, 0, len(activityTypes))
+	var types []ActivityType
+	for _, activityType := range activityTypes {
+		if id, err := strconv.Atoi(activityType); err == nil {
+			ids = append(ids, id)
+			continue
+		}
+		if types == nil {
+			var err error
+			types, err = c.GetActivityTypes()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get activity types: %w", err)
+			}
+		}
+		found := false
+		for _, t := range types {
+			if strings.EqualFold(t.Name, activityType) {
+				ids = append(ids, t.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown activity type %q", activityType)
+		}
+	}
+	return ids, nil
+}
+
+// returns custom objects of given API names from marketo rest api, all custom objects if no names are given.
+func (c Client) ListCustomObjects(apiNames []string) ([]ObjectDescription, error) {
+	path := "/rest/v1/customobjects.json"
+	if len(apiNames) > 0 {
+		path += "?names=" + url.QueryEscape(strings.Join(apiNames, ","))
+	}
+	response, err := c.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("%+v", response.Errors)
+	}
+	var result []ObjectDescription
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// returns the description of the custom object with given API name, including its fields, from marketo rest api.
+func (c Client) DescribeCustomObject(apiName string) (ObjectDescription, error) {
+	return c.DescribeObject("customobjects/" + url.PathEscape(apiName))
+}
+
+// returns the description of given object, like QueryObjectOpportunities, including its fields, from marketo rest
+// api.
+func (c Client) DescribeObject(object string) (ObjectDescription, error) {
+	return c.describe(fmt.Sprintf("/rest/v1/%s/describe.json", object))
+}
+
+// returns the description of leads, including their fields, from marketo rest api.
+func (c Client) DescribeLeads() (ObjectDescription, error) {
+	return c.describe("/rest/v1/leads/describe2.json")
+}
+
+// returns the description of the describe endpoint at given path.
+func (c Client) describe(path string) (ObjectDescription, error) {
+	response, err := c.Get(path)
+	if err != nil {
+		return ObjectDescription{}, err
+	}
+	if !response.Success {
+		return ObjectDescription{}, fmt.Errorf("%+v", response.Errors)
+	}
+	var result []ObjectDescription
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return ObjectDescription{}, err
+	}
+	if len(result) != 1 {
+		return ObjectDescription{}, fmt.Errorf("unexpected response from marketo rest api:%+v", result)
+	}
+	return result[0], nil
+}
+
+type ObjectDescription struct {
+	Name             string        `json:"name"`
+	DisplayName      string        `json:"displayName"`
+	Description      string        `json:"description"`
+	IDField          string        `json:"idField"`
+	DedupeFields     []string      `json:"dedupeFields"`
+	SearchableFields [][]string    `json:"searchableFields"`
+	Fields           []ObjectField `json:"fields"`
+}
+
+type ObjectField struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	DataType    string `json:"dataType"`
+	Length      int    `json:"length"`
+	Updateable  bool   `json:"updateable"`
+}
+
 // returns deleted leads from marketo rest api.
-func (c Client) GetDeletedLeads(nextPageToken string) (*json.RawMessage, error) {
-	path := fmt.Sprintf("/rest/v1/activities/deletedleads.json?nextPageToken=%s", nextPageToken)
+func (c Client) GetDeletedLeads(nextPageToken string) (*minimarketo.Response, error) {
+	path := fmt.Sprintf("/rest/v1/activities/deletedleads.json?nextPageToken=%s", url.QueryEscape(nextPageToken))
 	response, err := c.Get(path)
 	if err != nil {
 		return nil, err
@@ -253,7 +651,7 @@ func (c Client) GetDeletedLeads(nextPageToken string) (*json.RawMessage, error)
 	if !response.Success {
 		return nil, fmt.Errorf("%+v", response.Errors)
 	}
-	return &response.Result, nil
+	return response, nil
 }
 
 // returns Lead record from marketo rest api.
@@ -312,16 +710,387 @@ func WithRetry(ctx context.Context, r RetryFunc) error {
 	return nil
 }
 
-// returns filterd leads from marketo rest api
+// Marketo limits for filtered queries.
+const (
+	// MaxFilterValues is the maximum number of values accepted by a single filtered query.
+	MaxFilterValues = 300
+	// MaxURLLength is the maximum length of a GET request URL accepted by Marketo, leaving room for the endpoint.
+	MaxURLLength = 7168
+)
+
+// returns filterd leads from marketo rest api. Callers are expected to keep filterValues within the limits,
+// see ChunkFilterValues.
 func (c Client) FilterLeads(fileterType string, filterValues []int, fields []string, nextPageToken string) (*minimarketo.Response, error) {
-	var leads []string
+	path := filterLeadsPath(fileterType, JoinInts(filterValues), fields)
+	if nextPageToken != "" {
+		path += "&nextPageToken=" + url.QueryEscape(nextPageToken)
+	}
+	response, err := c.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("%+v", response.Errors)
+	}
+	return response, nil
+}
+
+// returns members of given program filtered by lead ids from marketo rest api. Callers are expected to keep leadIDs
+// within the limits, see ChunkFilterValues.
+func (c Client) GetProgramMembers(programID int, leadIDs []int, fields []string, nextPageToken string) (*minimarketo.Response, error) {
+	path := fmt.Sprintf("/rest/v1/programs/%d/members.json?filterType=leadId&filterValues=%s&fields=%s", programID, JoinInts(leadIDs), strings.Join(fields, ","))
+	if nextPageToken != "" {
+		path += "&nextPageToken=" + url.QueryEscape(nextPageToken)
+	}
+	response, err := c.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("%+v", response.Errors)
+	}
+	return response, nil
+}
+
+// returns leads of the static list with given id from marketo rest api.
+func (c Client) GetListLeads(listID int, fields []string, nextPageToken string) (*minimarketo.Response, error) {
+	path := fmt.Sprintf("/rest/v1/lists/%d/leads.json?fields=%s", listID, strings.Join(fields, ","))
+	if nextPageToken != "" {
+		path += "&nextPageToken=" + url.QueryEscape(nextPageToken)
+	}
+	response, err := c.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("%+v", response.Errors)
+	}
+	return response, nil
+}
+
+// splits filterValues into chunks, so each FilterLeads call stays within MaxFilterValues values and MaxURLLength,
+// including a paging token.
+func ChunkFilterValues(filterType string, filterValues []int, fields []string) [][]int {
+	const pageTokenLength = 128 // room for "&nextPageToken=" and an escaped paging token
+	budget := MaxURLLength - len(filterLeadsPath(filterType, "", fields)) - pageTokenLength
+	var chunks [][]int
+	var chunk []int
+	length := 0
+	for _, v := range filterValues {
+		valueLength := len(strconv.Itoa(v)) + 1 // value and its separator
+		if len(chunk) == MaxFilterValues || (len(chunk) > 0 && length+valueLength > budget) {
+			chunks = append(chunks, chunk)
+			chunk, length = nil, 0
+		}
+		chunk = append(chunk, v)
+		length += valueLength
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// MaxSyncRecords is the maximum number of records of a call writing records, like SyncLeads.
+const MaxSyncRecords = 300
+
+// actions of the calls writing records, like SyncLeads.
+const (
+	SyncActionCreateOrUpdate = "createOrUpdate"
+	SyncActionCreateOnly     = "createOnly"
+	SyncActionUpdateOnly     = "updateOnly"
+)
+
+// statuses of the records written by calls writing records. Records which couldn't be written have the status
+// skipped, and the reasons why.
+const (
+	SyncStatusCreated = "created"
+	SyncStatusUpdated = "updated"
+	SyncStatusDeleted = "deleted"
+	SyncStatusSkipped = "skipped"
+)
+
+// creates or updates given leads with given action, matching existing leads by lookupField, with marketo rest api.
+// returns the result of every lead in the order of given leads, callers are expected to send at most
+// MaxSyncRecords leads.
+func (c Client) SyncLeads(action string, lookupField string, leads []map[string]interface{}) ([]minimarketo.RecordResult, error) {
+	return c.syncRecords("/rest/v1/leads.json", map[string]interface{}{
+		"action":      action,
+		"lookupField": lookupField,
+		"input":       leads,
+	})
+}
+
+// ReasonLeadNotFound is the reason code of leads which couldn't be written since they don't exist, like leads deleted
+// already.
+const ReasonLeadNotFound = "1004"
+
+// deletes the leads with given ids with marketo rest api. returns the result of every lead in the order of given ids,
+// callers are expected to send at most MaxSyncRecords ids.
+func (c Client) DeleteLeads(ids []int) ([]minimarketo.RecordResult, error) {
+	return c.syncRecords("/rest/v1/leads/delete.json", idsInput(ids))
+}
+
+// changes the status of the leads with given ids in given program to given status, adding the leads which aren't
+// members of the program, with marketo rest api. returns the result of every lead in the order of given ids, callers
+// are expected to send at most MaxSyncRecords ids.
+func (c Client) ChangeProgramMemberStatus(programID int, status string, leadIDs []int) ([]minimarketo.RecordResult, error) {
+	body := idsInput(leadIDs)
+	body["status"] = status
+	return c.syncRecords(fmt.Sprintf("/rest/v1/leads/programs/%d/status.json", programID), body)
+}
+
+// ReasonLeadNotInList is the reason code of leads which couldn't be removed from a list since they aren't members.
+const ReasonLeadNotInList = "1015"
+
+// adds the leads with given ids to the static list with given id with marketo rest api. returns the result of every
+// lead in the order of given ids, callers are expected to send at most MaxSyncRecords ids.
+func (c Client) AddToList(listID int, leadIDs []int) ([]minimarketo.RecordResult, error) {
+	return c.writeRecords(c.Post, fmt.Sprintf("/rest/v1/lists/%d/leads.json", listID), idsInput(leadIDs))
+}
+
+// removes the leads with given ids from the static list with given id with marketo rest api. returns the result of
+// every lead in the order of given ids, callers are expected to send at most MaxSyncRecords ids.
+func (c Client) RemoveFromList(listID int, leadIDs []int) ([]minimarketo.RecordResult, error) {
+	return c.writeRecords(c.Delete, fmt.Sprintf("/rest/v1/lists/%d/leads.json", listID), idsInput(leadIDs))
+}
+
+// returns the body of a call writing the records with given ids.
+func idsInput(ids []int) map[string]interface{} {
+	var input = make([]map[string]int, 0, len(ids))
+	for _, id := range ids {
+		input = append(input, map[string]int{"id": id})
+	}
+	return map[string]interface{}{"input": input}
+}
+
+// adds given custom activities, holding the lead id, activity date, activity type id, primary attribute value and
+// attributes of every activity, with marketo rest api. returns the result of every activity in the order of given
+// activities, callers are expected to send at most MaxSyncRecords activities.
+func (c Client) AddCustomActivities(activities []map[string]interface{}) ([]minimarketo.RecordResult, error) {
+	return c.syncRecords("/rest/v1/activities/external.json", map[string]interface{}{
+		"input": activities,
+	})
+}
+
+// MaxTriggerLeads is the maximum number of leads of a call triggering a campaign.
+const MaxTriggerLeads = 100
+
+// Token is the value of a My Token of a smart campaign.
+type Token struct {
+	Name  string `json:"name"` // name of the token, like "{{my.trialEnd}}"
+	Value string `json:"value"`
+}
+
+// triggers the smart campaign with given id for the leads with given ids, with given My Token values, with marketo
+// rest api. Callers are expected to send at most MaxTriggerLeads ids.
+func (c Client) TriggerCampaign(campaignID int, leadIDs []int, tokens []Token) error {
+	var leads = make([]map[string]int, 0, len(leadIDs))
+	for _, id := range leadIDs {
+		leads = append(leads, map[string]int{"id": id})
+	}
+	input := map[string]interface{}{"leads": leads}
+	if len(tokens) > 0 {
+		input["tokens"] = tokens
+	}
+	reqBody, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return err
+	}
+	response, err := c.Post(fmt.Sprintf("/rest/v1/campaigns/%d/trigger.json", campaignID), reqBody)
+	if err != nil {
+		return err
+	}
+	if !response.Success {
+		return fmt.Errorf("%+v", response.Errors)
+	}
+	return nil
+}
+
+// fields matching custom object records with existing records.
+const (
+	DedupeByDedupeFields = "dedupeFields"
+	DedupeByIDField      = "idField"
+)
+
+// creates or updates given records of the custom object with given API name with given action, matching existing
+// records by dedupeBy, see DedupeByDedupeFields and DedupeByIDField, with marketo rest api. returns the result of
+// every record in the order of given records, callers are expected to send at most MaxSyncRecords records.
+func (c Client) SyncCustomObjects(apiName string, action string, dedupeBy string, records []map[string]interface{}) ([]minimarketo.RecordResult, error) {
+	return c.syncRecords(fmt.Sprintf("/rest/v1/customobjects/%s.json", url.PathEscape(apiName)), map[string]interface{}{
+		"action":   action,
+		"dedupeBy": dedupeBy,
+		"input":    records,
+	})
+}
+
+// deletes the records of the custom object with given API name identified by given keys, holding either the dedupe
+// fields or the id field according to deleteBy, with marketo rest api. returns the result of every record in the
+// order of given keys, callers are expected to send at most MaxSyncRecords keys.
+func (c Client) DeleteCustomObjects(apiName string, deleteBy string, keys []map[string]interface{}) ([]minimarketo.RecordResult, error) {
+	return c.syncRecords(fmt.Sprintf("/rest/v1/customobjects/%s/delete.json", url.PathEscape(apiName)), map[string]interface{}{
+		"deleteBy": deleteBy,
+		"input":    keys,
+	})
+}
+
+// posts given body to a path writing records, and returns the result of every record.
+func (c Client) syncRecords(path string, body map[string]interface{}) ([]minimarketo.RecordResult, error) {
+	return c.writeRecords(c.Post, path, body)
+}
+
+// sends given body to a path writing records with given method, and returns the result of every record.
+func (c Client) writeRecords(send func(resource string, data []byte) (*minimarketo.Response, error), path string, body map[string]interface{}) ([]minimarketo.RecordResult, error) {
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	response, err := send(path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("%+v", response.Errors)
+	}
+	var result []minimarketo.RecordResult
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// returns the reasons of a record result, like "1004: Lead not found", separated by "; ".
+func ResultReasons(result minimarketo.RecordResult) string {
+	var reasons = make([]string, 0, len(result.Reasons))
+	for _, reason := range result.Reasons {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", reason.Code, reason.Message))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// MaxImportFileSize is the maximum size of a file imported by a bulk import job, like ImportLeads.
+const MaxImportFileSize = 10 << 20
+
+// statuses of bulk import jobs.
+const (
+	ImportStatusQueued    = "Queued"
+	ImportStatusImporting = "Importing"
+	ImportStatusComplete  = "Complete"
+	ImportStatusFailed    = "Failed"
+)
+
+// columns added to the rows of the failures and warnings files of bulk import jobs.
+const (
+	ImportFailureColumn = "Import Failure Reason"
+	ImportWarningColumn = "Import Warning Reason"
+)
+
+type ImportResult struct {
+	BatchID              int    `json:"batchId"`
+	ImportID             string `json:"importId"`
+	Status               string `json:"status"`
+	NumOfLeadsProcessed  int    `json:"numOfLeadsProcessed"`
+	NumOfRowsFailed      int    `json:"numOfRowsFailed"`
+	NumOfRowsWithWarning int    `json:"numOfRowsWithWarning"`
+	Message              string `json:"message"`
+}
+
+// creates a bulk import job of leads from given CSV file, matching existing leads by lookupField. Callers are expected
+// to keep the file within MaxImportFileSize. return the job, whose batch id identifies it, and error.
+func (c Client) ImportLeads(ctx context.Context, endpoint string, lookupField string, file []byte) (ImportResult, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "leads.csv")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if _, err := part.Write(file); err != nil {
+		return ImportResult{}, err
+	}
+	if err := writer.Close(); err != nil {
+		return ImportResult{}, err
+	}
+	path := fmt.Sprintf("/bulk/v1/leads.json?format=csv&lookupField=%s", url.QueryEscape(lookupField))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint+path, &body)
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("failed to create request: %v", err)
+	}
+	token, err := c.GetAuthToken()
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("failed to get auth token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	err = c.wait(ctx)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("failed to get response: %v", err)
+	}
+	defer response.Body.Close()
+	var importResponse minimarketo.Response
+	if err := json.NewDecoder(response.Body).Decode(&importResponse); err != nil {
+		return ImportResult{}, fmt.Errorf("unexpected response from marketo bulk api with status %s: %w", response.Status, err)
+	}
+	return importResult(&importResponse)
+}
+
+// returns current status of the bulk import job of leads with given batch id.
+func (c Client) StatusOfImport(batchID int) (ImportResult, error) {
+	response, err := c.Get(fmt.Sprintf("/bulk/v1/leads/batch/%d.json", batchID))
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return importResult(response)
+}
+
+// returns the rows of the bulk import job of leads with given batch id which failed, in CSV format with the
+// ImportFailureColumn column.
+func (c Client) ImportFailures(ctx context.Context, endpoint string, batchID int) ([]byte, error) {
+	return c.getFile(ctx, endpoint, fmt.Sprintf("/bulk/v1/leads/batch/%d/failures.json", batchID))
+}
+
+// returns the rows of the bulk import job of leads with given batch id which were imported with warnings, in CSV
+// format with the ImportWarningColumn column.
+func (c Client) ImportWarnings(ctx context.Context, endpoint string, batchID int) ([]byte, error) {
+	return c.getFile(ctx, endpoint, fmt.Sprintf("/bulk/v1/leads/batch/%d/warnings.json", batchID))
+}
+
+func importResult(response *minimarketo.Response) (ImportResult, error) {
+	if !response.Success {
+		return ImportResult{}, fmt.Errorf("%+v", response.Errors)
+	}
+	var result []ImportResult
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return ImportResult{}, err
+	}
+	if len(result) != 1 {
+		return ImportResult{}, fmt.Errorf("unexpected response from marketo bulk api:%+v", result)
+	}
+	return result[0], nil
+}
+
+// objects supported by QueryObjects.
+const (
+	QueryObjectLeads            = "leads"
+	QueryObjectOpportunities    = "opportunities"
+	QueryObjectOpportunityRoles = "opportunities/roles"
+	QueryObjectCompanies        = "companies"
+	QueryObjectNamedAccounts    = "namedaccounts"
+)
+
+// returns records of given object, like QueryObjectOpportunities, filtered by the values of filterType from marketo
+// rest api. Callers are expected to keep filterValues within the limits, see ChunkQueryValues.
+func (c Client) QueryObjects(object string, filterType string, filterValues []string, fields []string, nextPageToken string) (*minimarketo.Response, error) {
+	var values = make([]string, 0, len(filterValues))
 	for _, v := range filterValues {
-		leads = append(leads, strconv.Itoa(v))
+		values = append(values, url.QueryEscape(v))
 	}
-	var path string
-	path = fmt.Sprintf("/rest/v1/leads.json?filterType=%s&filterValues=%s&fields=%s", fileterType, strings.Join(leads, ","), strings.Join(fields, ","))
+	path := queryObjectsPath(object, filterType, strings.Join(values, ","), fields)
 	if nextPageToken != "" {
-		path = fmt.Sprintf("/rest/v1/lead/filter.json?filterType=%s&filterValues=%s&fields=%s&nextPageToken=%s", fileterType, strings.Join(leads, ","), strings.Join(fields, ","), nextPageToken)
+		path += "&nextPageToken=" + url.QueryEscape(nextPageToken)
 	}
 	response, err := c.Get(path)
 	if err != nil {
@@ -332,3 +1101,43 @@ func (c Client) FilterLeads(fileterType string, filterValues []int, fields []str
 	}
 	return response, nil
 }
+
+// splits filterValues into chunks, so each QueryObjects call stays within MaxFilterValues values and MaxURLLength,
+// including a paging token.
+func ChunkQueryValues(object string, filterType string, filterValues []string, fields []string) [][]string {
+	const pageTokenLength = 128 // room for "&nextPageToken=" and an escaped paging token
+	budget := MaxURLLength - len(queryObjectsPath(object, filterType, "", fields)) - pageTokenLength
+	var chunks [][]string
+	var chunk []string
+	length := 0
+	for _, v := range filterValues {
+		valueLength := len(url.QueryEscape(v)) + 1 // escaped value and its separator
+		if len(chunk) == MaxFilterValues || (len(chunk) > 0 && length+valueLength > budget) {
+			chunks = append(chunks, chunk)
+			chunk, length = nil, 0
+		}
+		chunk = append(chunk, v)
+		length += valueLength
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func queryObjectsPath(object, filterType, filterValues string, fields []string) string {
+	return fmt.Sprintf("/rest/v1/%s.json?filterType=%s&filterValues=%s&fields=%s", object, url.QueryEscape(filterType), filterValues, strings.Join(fields, ","))
+}
+
+func filterLeadsPath(filterType, filterValues string, fields []string) string {
+	return fmt.Sprintf("/rest/v1/leads.json?filterType=%s&filterValues=%s&fields=%s", filterType, filterValues, strings.Join(fields, ","))
+}
+
+// returns the values separated by commas, as Marketo expects lists of ids.
+func JoinInts(values []int) string {
+	var s = make([]string, 0, len(values))
+	for _, v := range values {
+		s = append(s, strconv.Itoa(v))
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,245 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marketoclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SpeakData/minimarketo"
+	"golang.org/x/time/rate"
+)
+
+func TestChunkFilterValues(t *testing.T) {
+	var manyFields []string
+	for i := 0; i < 300; i++ {
+		manyFields = append(manyFields, fmt.Sprintf("customField%d", i))
+	}
+	var tests = []struct {
+		name   string
+		values int
+		fields []string
+		chunks int
+		size   int // values of every chunk but the last
+	}{
+		{
+			name:   "No values",
+			values: 0,
+			fields: []string{"id"},
+			chunks: 0,
+		},
+		{
+			name:   "Values within limit",
+			values: 300,
+			fields: []string{"id"},
+			chunks: 1,
+			size:   300,
+		},
+		{
+			name:   "Thousands of values",
+			values: 5000,
+			fields: []string{"id"},
+			chunks: 17,
+			size:   MaxFilterValues,
+		},
+		{
+			name:   "Long field list shortens chunks",
+			values: 5000,
+			fields: manyFields,
+			// the fields leave 2596 characters of the URL, 9 per value and separator
+			chunks: 18,
+			size:   288,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var values []int
+			for i := 0; i < tt.values; i++ {
+				values = append(values, 10000000+i)
+			}
+			chunks := ChunkFilterValues("id", values, tt.fields)
+			if len(chunks) != tt.chunks {
+				t.Errorf("expected %d chunks, got %d", tt.chunks, len(chunks))
+			}
+			var count int
+			for i, chunk := range chunks {
+				if i < len(chunks)-1 && len(chunk) != tt.size {
+					t.Errorf("chunk %d has %d values, expected %d", i, len(chunk), tt.size)
+				}
+				if len(chunk) > MaxFilterValues {
+					t.Errorf("chunk has %d values, expected at most %d", len(chunk), MaxFilterValues)
+				}
+				if l := len(filterLeadsPath("id", JoinInts(chunk), tt.fields)); l > MaxURLLength {
+					t.Errorf("chunk path length %d exceeds %d", l, MaxURLLength)
+				}
+				count += len(chunk)
+			}
+			if count != tt.values {
+				t.Errorf("expected %d values in chunks, got %d", tt.values, count)
+			}
+		})
+	}
+}
+
+func TestChunkQueryValues(t *testing.T) {
+	var values []string
+	for i := 0; i < 1000; i++ {
+		values = append(values, fmt.Sprintf("Company %d & Sons", i))
+	}
+	chunks := ChunkQueryValues(QueryObjectOpportunities, "externalCompanyId", values, []string{"marketoGUID"})
+	var count int
+	for _, chunk := range chunks {
+		if len(chunk) > MaxFilterValues {
+			t.Errorf("chunk has %d values, expected at most %d", len(chunk), MaxFilterValues)
+		}
+		var escaped []string
+		for _, v := range chunk {
+			escaped = append(escaped, url.QueryEscape(v))
+		}
+		path := queryObjectsPath(QueryObjectOpportunities, "externalCompanyId", strings.Join(escaped, ","), []string{"marketoGUID"})
+		if len(path) > MaxURLLength {
+			t.Errorf("chunk path length %d exceeds %d", len(path), MaxURLLength)
+		}
+		count += len(chunk)
+	}
+	if count != len(values) {
+		t.Errorf("expected %d values in chunks, got %d", len(values), count)
+	}
+}
+
+// minimarketo client answering every GET and POST with a response holding result.
+type fakeMinimarketo struct {
+	minimarketo.Client
+	result    string
+	gets      int
+	resources []string
+}
+
+func (f *fakeMinimarketo) Get(resource string) (*minimarketo.Response, error) {
+	f.gets++
+	f.resources = append(f.resources, resource)
+	return &minimarketo.Response{Success: true, Result: []byte(f.result)}, nil
+}
+
+func (f *fakeMinimarketo) Post(resource string, data []byte) (*minimarketo.Response, error) {
+	f.resources = append(f.resources, resource)
+	return &minimarketo.Response{Success: true, Result: []byte(f.result)}, nil
+}
+
+func TestWithRateLimit(t *testing.T) {
+	fake := &fakeMinimarketo{}
+	limiter := rate.NewLimiter(rate.Every(time.Hour), 2)
+	client := Client{fake}.WithRateLimit(context.Background(), limiter)
+	copied := client
+	for _, c := range []Client{client, copied} {
+		_, err := c.Get("/rest/v1/leads.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.gets != 2 {
+		t.Errorf("expected 2 calls, got %d", fake.gets)
+	}
+	if limiter.Allow() {
+		t.Error("expected the copies of the client to share the limiter burst")
+	}
+}
+
+func TestWithRateLimit_Cancel(t *testing.T) {
+	fake := &fakeMinimarketo{}
+	ctx, cancel := context.WithCancel(context.Background())
+	client := Client{fake}.WithRateLimit(ctx, rate.NewLimiter(rate.Every(time.Hour), 1))
+	if _, err := client.Get("/rest/v1/leads.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next call waits for the limiter until the context is canceled.
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.Get("/rest/v1/leads.json")
+		errs <- err
+	}()
+	cancel()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("expected an error once the context is canceled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the call to stop waiting once the context is canceled")
+	}
+	if fake.gets != 1 {
+		t.Errorf("expected 1 call, got %d", fake.gets)
+	}
+}
+
+func TestDeprecatedExportLeads(t *testing.T) {
+	fake := &fakeMinimarketo{result: `[{"exportId":"e1","status":"Queued"}]`}
+	client := Client{fake}
+	if _, err := client.EnqueueExportLeads("e1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.StatusOfExportLeads("e1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CancelExportLeads("e1"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"/bulk/v1/leads/export/e1/enqueue.json",
+		"/bulk/v1/leads/export/e1/status.json",
+		"/bulk/v1/leads/export/e1/cancel.json",
+	}
+	if !reflect.DeepEqual(fake.resources, want) {
+		t.Errorf("expected requests %v, got %v", want, fake.resources)
+	}
+}
+
+func TestFileExport_Authorization(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/identity/oauth/token":
+			_ = json.NewEncoder(w).Encode(minimarketo.AuthToken{AccessToken: "token", ExpiresIn: 3600})
+		case "/bulk/v1/activities/export/e1/file.json":
+			if got := r.Header.Get("Authorization"); got != "Bearer token" {
+				t.Errorf("expected authorization %q, got %q", "Bearer token", got)
+			}
+			_, _ = w.Write([]byte("id\n1\n"))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client, err := NewClient(minimarketo.ClientConfig{ID: "id", Secret: "secret", Endpoint: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := client.FileExport(context.Background(), server.URL, ExportObjectActivities, "e1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(*file) != "id\n1\n" {
+		t.Errorf("unexpected file %q", *file)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marketoclient_test
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+)
+
+func TestListCustomObjects(t *testing.T) {
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/customobjects.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []marketoclient.ObjectDescription{
+			{Name: "subscription_c", IDField: "marketoGUID", DedupeFields: []string{"subscriptionId"}},
+			{Name: "car_c", IDField: "marketoGUID"},
+		}, "", false)
+	})
+	client := f.Client(t)
+
+	objects, err := client.ListCustomObjects(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, object := range objects {
+		names = append(names, object.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"subscription_c", "car_c"}) {
+		t.Errorf("expected the custom objects listed, got %v", names)
+	}
+
+	if _, err := client.ListCustomObjects([]string{"subscription_c", "car_c"}); err != nil {
+		t.Fatal(err)
+	}
+	requests := f.RequestsTo("/rest/v1/customobjects.json")
+	if len(requests) != 2 || requests[0].URL.RawQuery != "" || requests[1].URL.Query().Get("names") != "subscription_c,car_c" {
+		t.Errorf("expected all custom objects listed, then the named ones, got %v", requests)
+	}
+}
+
+func TestListCustomObjects_Error(t *testing.T) {
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/customobjects.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":"603","message":"Access denied"}]}`))
+	})
+
+	_, err := f.Client(t).ListCustomObjects(nil)
+	if err == nil || !strings.Contains(err.Error(), "Access denied") {
+		t.Errorf("expected the error of the call, got %v", err)
+	}
+}
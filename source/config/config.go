@@ -17,6 +17,7 @@ package config
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,19 +30,142 @@ const (
 	KeyPollingPeriod = "pollingPeriod"
 	// KeySnapshotInitialDate is a date from which the snapshot iterator initially starts getting records.
 	KeySnapshotInitialDate = "snapshotInitialDate"
-	// Fields to retrieve from Marketo database
+	// Fields to retrieve from Marketo database. The fields of an object can be set with the object name as suffix,
+	// like "fields.customObject", which takes precedence over the fields of all objects.
 	KeyFields = "fields"
+	// KeyCDCMode selects how CDC builds update records, see CDCModeFull and CDCModePartial.
+	KeyCDCMode = "cdcMode"
+	// KeyCDCActivityTypes lists additional activity type IDs or names which trigger a lead refresh in CDC.
+	KeyCDCActivityTypes = "cdcActivityTypes"
+	// KeyObject lists the Marketo objects to read, see ObjectLeads and ObjectActivities.
+	KeyObject = "object"
+	// KeyActivityTypes lists the activity type IDs or names to read when the object is activities.
+	KeyActivityTypes = "activityTypes"
+	// KeyProgramIDs lists the IDs of the programs whose members are read when the object is program members.
+	KeyProgramIDs = "programIds"
+	// KeyListIDs lists the IDs of the static lists whose membership is read when the object is list members.
+	KeyListIDs = "listIds"
+	// KeyCustomObjectName is the API name of the custom object read when the object is custom object. Custom objects
+	// can also be listed with their API name as suffix, like "customObject.subscription_c", to read several of them.
+	KeyCustomObjectName = "customObjectName"
+	// KeyFilterType is the field filtering the records read when the object is queried by filter, like opportunities.
+	// The filter of an object can be set with the object name as suffix, like "filterType.companies", which takes
+	// precedence over the filter of all objects.
+	KeyFilterType = "filterType"
+	// KeyFilterValues lists the values of the filter field matching the records read, see KeyFilterType. The values
+	// of an object can be set with the object name as suffix, like "filterValues.companies".
+	KeyFilterValues = "filterValues"
+	// KeyRefreshPeriod is the period of full refreshes of the objects which are listed as a whole, like companies or
+	// assets. The period of an object can be set with the object name as suffix, like "refreshPeriod.companies".
+	KeyRefreshPeriod = "refreshPeriod"
+	// KeyExportPeriod is the period of the bulk exports reading the records of a custom object updated since the
+	// last export.
+	KeyExportPeriod = "exportPeriod"
+	// KeyAssetPollingPeriod is the polling period of the assets which can't be listed by updatedAt, like forms.
+	KeyAssetPollingPeriod = "assetPollingPeriod"
 	// DefaultPollingPeriod is the value assumed for the pooling period when the
 	// config omits the polling period parameter
 	DefaultPollingPeriod = time.Minute
+	// DefaultRefreshPeriod is the value assumed for the refresh period of refreshed objects when the config omits
+	// the refresh period parameter
+	DefaultRefreshPeriod = 24 * time.Hour
+	// DefaultExportPeriod is the value assumed for the export period of custom objects when the config omits the
+	// export period parameter
+	DefaultExportPeriod = time.Hour
+	// DefaultAssetPollingPeriod is the value assumed for the polling period of the assets which can't be listed by
+	// updatedAt when the config omits the asset polling period parameter
+	DefaultAssetPollingPeriod = time.Hour
 )
 
+// CDC modes
+const (
+	// CDCModeFull fetches the full lead for every changed lead.
+	CDCModeFull = "full"
+	// CDCModePartial builds update records from the changed fields of Change Data Value activities only,
+	// without fetching the lead.
+	CDCModePartial = "partial"
+)
+
+// Objects
+const (
+	// ObjectLeads reads leads, with a snapshot followed by lead changes.
+	ObjectLeads = "leads"
+	// ObjectActivities reads the activity stream, one record per activity.
+	ObjectActivities = "activities"
+	// ObjectProgramMembers reads the members of the configured programs, with a snapshot followed by membership
+	// changes.
+	ObjectProgramMembers = "programMembers"
+	// ObjectCustomObject reads the records of the configured custom object, with a snapshot followed by the records
+	// updated since. "customObject.<apiName>" reads the custom object with the API name.
+	ObjectCustomObject = "customObject"
+	// ObjectOpportunities reads the opportunities matching the configured filter, polling the ones updated since.
+	ObjectOpportunities = "opportunities"
+	// ObjectOpportunityRoles reads the opportunity roles matching the configured filter, polling the ones updated
+	// since.
+	ObjectOpportunityRoles = "opportunityRoles"
+	// ObjectCompanies reads the companies matching the configured filter, detecting changes by comparing polls.
+	ObjectCompanies = "companies"
+	// ObjectNamedAccounts reads the named accounts matching the configured filter, detecting changes by comparing
+	// polls.
+	ObjectNamedAccounts = "namedAccounts"
+	// ObjectListMembers reads the members of the configured static lists, with a snapshot followed by list adds and
+	// removes.
+	ObjectListMembers = "listMembers"
+)
+
+// Asset objects, read with the Asset API. Assets updated since the last poll are read every polling period, or every
+// asset polling period for the assets which can't be listed by updatedAt, and all assets are read every refresh
+// period.
+const (
+	ObjectPrograms       = "programs"
+	ObjectSmartCampaigns = "smartCampaigns"
+	ObjectEmails         = "emails"
+	ObjectForms          = "forms"
+	ObjectLandingPages   = "landingPages"
+	ObjectFolders        = "folders"
+	ObjectTags           = "tags"
+)
+
+// objects lists the supported objects.
+var objects = []string{ObjectLeads, ObjectActivities, ObjectProgramMembers, ObjectCustomObject, ObjectOpportunities, ObjectOpportunityRoles, ObjectCompanies, ObjectNamedAccounts, ObjectListMembers,
+	ObjectPrograms, ObjectSmartCampaigns, ObjectEmails, ObjectForms, ObjectLandingPages, ObjectFolders, ObjectTags}
+
+// filteredObjects lists the objects which can only be queried by filter, see KeyFilterType.
+var filteredObjects = []string{ObjectOpportunities, ObjectOpportunityRoles, ObjectCompanies, ObjectNamedAccounts}
+
+// refreshedObjects lists the objects which are fully refreshed periodically, see KeyRefreshPeriod.
+var refreshedObjects = []string{ObjectCompanies, ObjectNamedAccounts,
+	ObjectPrograms, ObjectSmartCampaigns, ObjectEmails, ObjectForms, ObjectLandingPages, ObjectFolders, ObjectTags}
+
+// unfilteredAssetObjects lists the asset objects which can't be listed by updatedAt, see KeyAssetPollingPeriod.
+var unfilteredAssetObjects = []string{ObjectForms, ObjectLandingPages, ObjectFolders, ObjectTags}
+
+// default fields of program members
+var programMemberFields = []string{"programId", "leadId", "updatedAt", "statusName", "reachedSuccess", "membershipDate", "acquiredBy"}
+
+// Filter selects the records of an object queried by filter.
+type Filter struct {
+	Type   string   // field filtering the records
+	Values []string // values of the field matching the records
+}
+
 // SourceConfig represents source configuration with GCS configurations
 type SourceConfig struct {
 	config.Config
 	PollingPeriod       time.Duration
 	SnapshotInitialDate time.Time
-	Fields              []string
+	Fields              map[string][]string // fields to read by object, for the objects which read fields only
+	CDCMode             string
+	CDCActivityTypes    []string
+	Objects             []string
+	ActivityTypes       []string
+	ProgramIDs          []int
+	ListIDs             []int
+	CustomObjectNames   map[string]string        // API names by custom object, see ObjectCustomObject
+	Filters             map[string]Filter        // filters by object, for the objects queried by filter
+	RefreshPeriods      map[string]time.Duration // refresh periods by object, for the refreshed objects
+	ExportPeriod        time.Duration
+	AssetPollingPeriod  time.Duration
 }
 
 // ParseSourceConfig attempts to parse the configurations into a SourceConfig struct that Source could utilize
@@ -58,7 +182,8 @@ func ParseSourceConfig(ctx context.Context, cfg map[string]string) (SourceConfig
 	sourceConfig := SourceConfig{
 		Config:        globalConfig,
 		PollingPeriod: DefaultPollingPeriod,
-		Fields:        []string{"id", "createdAt", "updatedAt", "firstName", "lastName", "email"},
+		CDCMode:       CDCModeFull,
+		Objects:       []string{ObjectLeads},
 	}
 
 	if pollingPeriodString := cfg[KeyPollingPeriod]; pollingPeriodString != "" {
@@ -89,11 +214,220 @@ func ParseSourceConfig(ctx context.Context, cfg map[string]string) (SourceConfig
 		}
 	}
 
-	if cfg[KeyFields] != "" {
-		sourceConfig.Fields = []string{"id", "createdAt", "updatedAt"}
-		sourceConfig.Fields = append(sourceConfig.Fields, strings.Split(cfg[KeyFields], ",")...)
+	if cdcMode := cfg[KeyCDCMode]; cdcMode != "" {
+		if cdcMode != CDCModeFull && cdcMode != CDCModePartial {
+			return SourceConfig{}, fmt.Errorf(
+				"%q config value should be one of %q or %q, got %q",
+				KeyCDCMode, CDCModeFull, CDCModePartial, cdcMode,
+			)
+		}
+		sourceConfig.CDCMode = cdcMode
+	}
+
+	sourceConfig.CDCActivityTypes = config.SplitList(cfg[KeyCDCActivityTypes])
+
+	if objectList := config.SplitList(cfg[KeyObject]); len(objectList) > 0 {
+		sourceConfig.Objects = nil
+		for _, object := range objectList {
+			if !config.Contains(objects, object) && ObjectType(object) != ObjectCustomObject {
+				return SourceConfig{}, fmt.Errorf(
+					"%q config value should be a list of %q, got %q",
+					KeyObject, objects, object,
+				)
+			}
+			if config.Contains(sourceConfig.Objects, object) {
+				return SourceConfig{}, fmt.Errorf("%q config value lists %q more than once", KeyObject, object)
+			}
+			sourceConfig.Objects = append(sourceConfig.Objects, object)
+		}
+	}
+
+	for _, object := range sourceConfig.Objects {
+		if objectFields := parseFields(ObjectType(object), objectValue(cfg, KeyFields, object)); objectFields != nil {
+			if sourceConfig.Fields == nil {
+				sourceConfig.Fields = make(map[string][]string)
+			}
+			sourceConfig.Fields[object] = objectFields
+		}
+	}
+
+	sourceConfig.ActivityTypes = config.SplitList(cfg[KeyActivityTypes])
+
+	for _, programID := range config.SplitList(cfg[KeyProgramIDs]) {
+		id, err := strconv.Atoi(programID)
+		if err != nil {
+			return SourceConfig{}, fmt.Errorf("%q config value should be a list of program IDs: %w", KeyProgramIDs, err)
+		}
+		sourceConfig.ProgramIDs = append(sourceConfig.ProgramIDs, id)
+	}
+	if sourceConfig.HasObject(ObjectProgramMembers) && len(sourceConfig.ProgramIDs) == 0 {
+		return SourceConfig{}, fmt.Errorf("%q config value is required for the %q object", KeyProgramIDs, ObjectProgramMembers)
+	}
+
+	for _, listID := range config.SplitList(cfg[KeyListIDs]) {
+		id, err := strconv.Atoi(listID)
+		if err != nil {
+			return SourceConfig{}, fmt.Errorf("%q config value should be a list of static list IDs: %w", KeyListIDs, err)
+		}
+		sourceConfig.ListIDs = append(sourceConfig.ListIDs, id)
+	}
+	if sourceConfig.HasObject(ObjectListMembers) && len(sourceConfig.ListIDs) == 0 {
+		return SourceConfig{}, fmt.Errorf("%q config value is required for the %q object", KeyListIDs, ObjectListMembers)
+	}
+
+	for _, object := range sourceConfig.Objects {
+		if ObjectType(object) != ObjectCustomObject {
+			continue
+		}
+		name := strings.TrimPrefix(object, ObjectCustomObject+".")
+		if object == ObjectCustomObject {
+			name = strings.TrimSpace(cfg[KeyCustomObjectName])
+			if name == "" {
+				return SourceConfig{}, fmt.Errorf("%q config value is required for the %q object", KeyCustomObjectName, ObjectCustomObject)
+			}
+		}
+		if sourceConfig.CustomObjectNames == nil {
+			sourceConfig.CustomObjectNames = make(map[string]string)
+		}
+		sourceConfig.CustomObjectNames[object] = name
+	}
+
+	for _, object := range sourceConfig.Objects {
+		if !config.Contains(filteredObjects, object) {
+			continue
+		}
+		filter := Filter{
+			Type:   strings.TrimSpace(objectValue(cfg, KeyFilterType, object)),
+			Values: config.SplitList(objectValue(cfg, KeyFilterValues, object)),
+		}
+		if filter.Type == "" {
+			return SourceConfig{}, fmt.Errorf("%q config value is required for the %q object", KeyFilterType, object)
+		}
+		if len(filter.Values) == 0 {
+			return SourceConfig{}, fmt.Errorf("%q config value is required for the %q object", KeyFilterValues, object)
+		}
+		if sourceConfig.Filters == nil {
+			sourceConfig.Filters = make(map[string]Filter)
+		}
+		sourceConfig.Filters[object] = filter
+	}
+
+	for _, object := range sourceConfig.Objects {
+		if !config.Contains(refreshedObjects, object) {
+			continue
+		}
+		refreshPeriod := DefaultRefreshPeriod
+		if refreshPeriodString := objectValue(cfg, KeyRefreshPeriod, object); refreshPeriodString != "" {
+			refreshPeriod, err = time.ParseDuration(refreshPeriodString)
+			if err != nil {
+				return SourceConfig{}, fmt.Errorf(
+					"%q config value of the %q object should be a valid duration: %w",
+					KeyRefreshPeriod, object, err,
+				)
+			}
+
+			if refreshPeriod <= 0 {
+				return SourceConfig{}, fmt.Errorf(
+					"%q config value of the %q object should be positive, got %s",
+					KeyRefreshPeriod, object,
+					refreshPeriod,
+				)
+			}
+		}
+		if sourceConfig.RefreshPeriods == nil {
+			sourceConfig.RefreshPeriods = make(map[string]time.Duration)
+		}
+		sourceConfig.RefreshPeriods[object] = refreshPeriod
+	}
+
+	if len(sourceConfig.CustomObjectNames) > 0 {
+		sourceConfig.ExportPeriod = DefaultExportPeriod
+	}
+	if exportPeriodString := cfg[KeyExportPeriod]; exportPeriodString != "" {
+		sourceConfig.ExportPeriod, err = time.ParseDuration(exportPeriodString)
+		if err != nil {
+			return SourceConfig{}, fmt.Errorf(
+				"%q config value should be a valid duration: %w",
+				KeyExportPeriod, err,
+			)
+		}
+
+		if sourceConfig.ExportPeriod <= 0 {
+			return SourceConfig{}, fmt.Errorf(
+				"%q config value should be positive, got %s",
+				KeyExportPeriod,
+				sourceConfig.ExportPeriod,
+			)
+		}
+	}
+
+	for _, object := range sourceConfig.Objects {
+		if config.Contains(unfilteredAssetObjects, object) {
+			sourceConfig.AssetPollingPeriod = DefaultAssetPollingPeriod
+		}
+	}
+	if assetPollingPeriodString := cfg[KeyAssetPollingPeriod]; assetPollingPeriodString != "" {
+		sourceConfig.AssetPollingPeriod, err = time.ParseDuration(assetPollingPeriodString)
+		if err != nil {
+			return SourceConfig{}, fmt.Errorf(
+				"%q config value should be a valid duration: %w",
+				KeyAssetPollingPeriod, err,
+			)
+		}
+
+		if sourceConfig.AssetPollingPeriod <= 0 {
+			return SourceConfig{}, fmt.Errorf(
+				"%q config value should be positive, got %s",
+				KeyAssetPollingPeriod,
+				sourceConfig.AssetPollingPeriod,
+			)
+		}
 	}
 
 	logger.Trace().Msg("Stop Parsing the Config")
 	return sourceConfig, nil
 }
+
+// returns true if the given object is one of the objects to read.
+func (c SourceConfig) HasObject(object string) bool {
+	return config.Contains(c.Objects, object)
+}
+
+// returns the type of the given object, ObjectCustomObject for the custom objects listed with their API name.
+func ObjectType(object string) string {
+	if strings.HasPrefix(object, ObjectCustomObject+".") && len(object) > len(ObjectCustomObject)+1 {
+		return ObjectCustomObject
+	}
+	return object
+}
+
+// returns the value of the given key for the given object, set with the object name as suffix, or the value of the
+// key for all objects.
+func objectValue(cfg map[string]string, key string, object string) string {
+	if value, ok := cfg[key+"."+object]; ok {
+		return value
+	}
+	return cfg[key]
+}
+
+// returns the fields to read of the given object from the comma separated fields of the config, or nil if the object
+// doesn't read fields.
+func parseFields(object string, fields string) []string {
+	switch {
+	case object == ObjectProgramMembers:
+		if fields == "" {
+			return programMemberFields
+		}
+		return append([]string{"programId", "leadId", "updatedAt"}, strings.Split(fields, ",")...)
+	case object == ObjectCustomObject || config.Contains(filteredObjects, object):
+		// all fields of the object by default, see the custom object and query iterators.
+		return config.SplitList(fields)
+	case object == ObjectLeads:
+		if fields == "" {
+			return []string{"id", "createdAt", "updatedAt", "firstName", "lastName", "email"}
+		}
+		return append([]string{"id", "createdAt", "updatedAt"}, strings.Split(fields, ",")...)
+	default:
+		return nil
+	}
+}
@@ -52,7 +52,9 @@ func TestParseGlobalConfig(t *testing.T) {
 					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
 				},
 				PollingPeriod: time.Minute,
-				Fields:        []string{"id", "createdAt", "updatedAt", "firstName", "lastName", "email"},
+				Fields:        map[string][]string{ObjectLeads: {"id", "createdAt", "updatedAt", "firstName", "lastName", "email"}},
+				CDCMode:       CDCModeFull,
+				Objects:       []string{ObjectLeads},
 			},
 		},
 		{
@@ -105,7 +107,9 @@ func TestParseGlobalConfig(t *testing.T) {
 					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
 				},
 				PollingPeriod: time.Minute,
-				Fields:        []string{"id", "createdAt", "updatedAt", "firstName", "lastName", "email"},
+				Fields:        map[string][]string{ObjectLeads: {"id", "createdAt", "updatedAt", "firstName", "lastName", "email"}},
+				CDCMode:       CDCModeFull,
+				Objects:       []string{ObjectLeads},
 			},
 		},
 		{
@@ -136,9 +140,552 @@ func TestParseGlobalConfig(t *testing.T) {
 				},
 				PollingPeriod:       time.Minute,
 				SnapshotInitialDate: time.Date(2022, time.September, 10, 0, 0, 0, 0, time.UTC),
-				Fields:              []string{"id", "createdAt", "updatedAt", "firstName", "lastName", "email"},
+				Fields:              map[string][]string{ObjectLeads: {"id", "createdAt", "updatedAt", "firstName", "lastName", "email"}},
+				CDCMode:             CDCModeFull,
+				Objects:             []string{ObjectLeads},
 			},
 		},
+		{
+			name:    "Partial cdcMode",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"cdcMode":        "partial",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod: time.Minute,
+				Fields:        map[string][]string{ObjectLeads: {"id", "createdAt", "updatedAt", "firstName", "lastName", "email"}},
+				CDCMode:       CDCModePartial,
+				Objects:       []string{ObjectLeads},
+			},
+		},
+		{
+			name:    "CDC activity types by id and name",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":         "client_id",
+				"clientSecret":     "client_secret",
+				"clientEndpoint":   "https://xxx-xxx-xxx.mktorest.com",
+				"cdcActivityTypes": "22, Change Status in Progression,",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod:    time.Minute,
+				Fields:           map[string][]string{ObjectLeads: {"id", "createdAt", "updatedAt", "firstName", "lastName", "email"}},
+				CDCMode:          CDCModeFull,
+				Objects:          []string{ObjectLeads},
+				CDCActivityTypes: []string{"22", "Change Status in Progression"},
+			},
+		},
+		{
+			name:    "Invalid cdcMode",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"cdcMode":        "wrong",
+			},
+			expectedCon: SourceConfig{},
+		},
+		{
+			name:    "Activities object with activity types",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "activities",
+				"activityTypes":  "1, Fill Out Form",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod: time.Minute,
+				CDCMode:       CDCModeFull,
+				Objects:       []string{ObjectActivities},
+				ActivityTypes: []string{"1", "Fill Out Form"},
+			},
+		},
+		{
+			name:    "Program members object",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "programMembers",
+				"programIds":     "1001, 1002",
+				"fields":         "statusName,nurtureCadence",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod: time.Minute,
+				Fields:        map[string][]string{ObjectProgramMembers: {"programId", "leadId", "updatedAt", "statusName", "nurtureCadence"}},
+				CDCMode:       CDCModeFull,
+				Objects:       []string{ObjectProgramMembers},
+				ProgramIDs:    []int{1001, 1002},
+			},
+		},
+		{
+			name:    "Program members object without program IDs",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "programMembers",
+			},
+			expectedCon: SourceConfig{},
+		},
+		{
+			name:    "Custom object",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":         "client_id",
+				"clientSecret":     "client_secret",
+				"clientEndpoint":   "https://xxx-xxx-xxx.mktorest.com",
+				"object":           "customObject",
+				"customObjectName": "subscription_c",
+				"fields":           "plan, seats",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod:     time.Minute,
+				Fields:            map[string][]string{ObjectCustomObject: {"plan", "seats"}},
+				CDCMode:           CDCModeFull,
+				Objects:           []string{ObjectCustomObject},
+				CustomObjectNames: map[string]string{ObjectCustomObject: "subscription_c"},
+				ExportPeriod:      time.Hour,
+			},
+		},
+		{
+			name:    "Custom object with export period",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":         "client_id",
+				"clientSecret":     "client_secret",
+				"clientEndpoint":   "https://xxx-xxx-xxx.mktorest.com",
+				"object":           "customObject",
+				"customObjectName": "subscription_c",
+				"exportPeriod":     "6h",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod:     time.Minute,
+				CDCMode:           CDCModeFull,
+				Objects:           []string{ObjectCustomObject},
+				CustomObjectNames: map[string]string{ObjectCustomObject: "subscription_c"},
+				ExportPeriod:      6 * time.Hour,
+			},
+		},
+		{
+			name:    "Invalid export period",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":         "client_id",
+				"clientSecret":     "client_secret",
+				"clientEndpoint":   "https://xxx-xxx-xxx.mktorest.com",
+				"object":           "customObject",
+				"customObjectName": "subscription_c",
+				"exportPeriod":     "0s",
+			},
+			expectedCon: SourceConfig{},
+		},
+		{
+			name:    "Custom object without name",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "customObject",
+			},
+			expectedCon: SourceConfig{},
+		},
+		{
+			name:    "Opportunities object",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "opportunities",
+				"filterType":     "externalCompanyId",
+				"filterValues":   "acme, initech",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod: time.Minute,
+				CDCMode:       CDCModeFull,
+				Objects:       []string{ObjectOpportunities},
+				Filters:       map[string]Filter{ObjectOpportunities: {Type: "externalCompanyId", Values: []string{"acme", "initech"}}},
+			},
+		},
+		{
+			name:    "Opportunity roles object without filter values",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "opportunityRoles",
+				"filterType":     "leadId",
+			},
+			expectedCon: SourceConfig{},
+		},
+		{
+			name:    "Companies object",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "companies",
+				"filterType":     "externalCompanyId",
+				"filterValues":   "acme",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod:  time.Minute,
+				CDCMode:        CDCModeFull,
+				Objects:        []string{ObjectCompanies},
+				Filters:        map[string]Filter{ObjectCompanies: {Type: "externalCompanyId", Values: []string{"acme"}}},
+				RefreshPeriods: map[string]time.Duration{ObjectCompanies: 24 * time.Hour},
+			},
+		},
+		{
+			name:    "Named accounts object with refresh period",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "namedAccounts",
+				"filterType":     "name",
+				"filterValues":   "Acme Corp",
+				"refreshPeriod":  "6h",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod:  time.Minute,
+				CDCMode:        CDCModeFull,
+				Objects:        []string{ObjectNamedAccounts},
+				Filters:        map[string]Filter{ObjectNamedAccounts: {Type: "name", Values: []string{"Acme Corp"}}},
+				RefreshPeriods: map[string]time.Duration{ObjectNamedAccounts: 6 * time.Hour},
+			},
+		},
+		{
+			name:    "Refresh period which is negative",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "companies",
+				"filterType":     "externalCompanyId",
+				"filterValues":   "acme",
+				"refreshPeriod":  "-1h",
+			},
+			expectedCon: SourceConfig{},
+		},
+		{
+			name:    "List members object",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "listMembers",
+				"listIds":        "1001, 1002",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod: time.Minute,
+				CDCMode:       CDCModeFull,
+				Objects:       []string{ObjectListMembers},
+				ListIDs:       []int{1001, 1002},
+			},
+		},
+		{
+			name:    "List members object with invalid list IDs",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "listMembers",
+				"listIds":        "first",
+			},
+			expectedCon: SourceConfig{},
+		},
+		{
+			name:    "Programs object",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "programs",
+				"refreshPeriod":  "12h",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod:  time.Minute,
+				CDCMode:        CDCModeFull,
+				Objects:        []string{ObjectPrograms},
+				RefreshPeriods: map[string]time.Duration{ObjectPrograms: 12 * time.Hour},
+			},
+		},
+		{
+			name:    "Forms object",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "forms",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod:      time.Minute,
+				CDCMode:            CDCModeFull,
+				Objects:            []string{ObjectForms},
+				RefreshPeriods:     map[string]time.Duration{ObjectForms: 24 * time.Hour},
+				AssetPollingPeriod: time.Hour,
+			},
+		},
+		{
+			name:    "Folders object with asset polling period",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":           "client_id",
+				"clientSecret":       "client_secret",
+				"clientEndpoint":     "https://xxx-xxx-xxx.mktorest.com",
+				"object":             "folders",
+				"assetPollingPeriod": "6h",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod:      time.Minute,
+				CDCMode:            CDCModeFull,
+				Objects:            []string{ObjectFolders},
+				RefreshPeriods:     map[string]time.Duration{ObjectFolders: 24 * time.Hour},
+				AssetPollingPeriod: 6 * time.Hour,
+			},
+		},
+		{
+			name:    "Invalid asset polling period",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":           "client_id",
+				"clientSecret":       "client_secret",
+				"clientEndpoint":     "https://xxx-xxx-xxx.mktorest.com",
+				"object":             "forms",
+				"assetPollingPeriod": "0s",
+			},
+			expectedCon: SourceConfig{},
+		},
+		{
+			name:    "Multiple objects with fields by object",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":                "client_id",
+				"clientSecret":            "client_secret",
+				"clientEndpoint":          "https://xxx-xxx-xxx.mktorest.com",
+				"object":                  "leads, activities, customObject",
+				"fields":                  "company",
+				"fields.customObject":     "plan",
+				"customObjectName":        "subscription_c",
+				"activityTypes":           "1",
+				"fields.unreadObjectName": "ignored",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod: time.Minute,
+				Fields: map[string][]string{
+					ObjectLeads:        {"id", "createdAt", "updatedAt", "company"},
+					ObjectCustomObject: {"plan"},
+				},
+				CDCMode:           CDCModeFull,
+				Objects:           []string{ObjectLeads, ObjectActivities, ObjectCustomObject},
+				ActivityTypes:     []string{"1"},
+				CustomObjectNames: map[string]string{ObjectCustomObject: "subscription_c"},
+				ExportPeriod:      time.Hour,
+			},
+		},
+		{
+			name:    "Multiple filtered objects with filters by object",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":                 "client_id",
+				"clientSecret":             "client_secret",
+				"clientEndpoint":           "https://xxx-xxx-xxx.mktorest.com",
+				"object":                   "opportunities, companies",
+				"filterType":               "externalCompanyId",
+				"filterValues":             "acme",
+				"filterType.opportunities": "externalOpportunityId",
+				"filterValues.companies":   "initech, globex",
+				"refreshPeriod.companies":  "6h",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod: time.Minute,
+				CDCMode:       CDCModeFull,
+				Objects:       []string{ObjectOpportunities, ObjectCompanies},
+				Filters: map[string]Filter{
+					ObjectOpportunities: {Type: "externalOpportunityId", Values: []string{"acme"}},
+					ObjectCompanies:     {Type: "externalCompanyId", Values: []string{"initech", "globex"}},
+				},
+				RefreshPeriods: map[string]time.Duration{ObjectCompanies: 6 * time.Hour},
+			},
+		},
+		{
+			name:    "Multiple custom objects",
+			wantErr: false,
+			in: map[string]string{
+				"clientID":                           "client_id",
+				"clientSecret":                       "client_secret",
+				"clientEndpoint":                     "https://xxx-xxx-xxx.mktorest.com",
+				"object":                             "customObject.subscription_c, customObject.car_c",
+				"fields.customObject.subscription_c": "plan",
+			},
+			expectedCon: SourceConfig{
+				Config: globalConfig.Config{
+					ClientID:       "client_id",
+					ClientSecret:   "client_secret",
+					ClientEndpoint: "https://xxx-xxx-xxx.mktorest.com",
+				},
+				PollingPeriod: time.Minute,
+				Fields:        map[string][]string{"customObject.subscription_c": {"plan"}},
+				CDCMode:       CDCModeFull,
+				Objects:       []string{"customObject.subscription_c", "customObject.car_c"},
+				CustomObjectNames: map[string]string{
+					"customObject.subscription_c": "subscription_c",
+					"customObject.car_c":          "car_c",
+				},
+				ExportPeriod: time.Hour,
+			},
+		},
+		{
+			name:    "Filtered object without its filter",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":                 "client_id",
+				"clientSecret":             "client_secret",
+				"clientEndpoint":           "https://xxx-xxx-xxx.mktorest.com",
+				"object":                   "opportunities, companies",
+				"filterType.opportunities": "externalOpportunityId",
+				"filterValues":             "acme",
+			},
+			expectedCon: SourceConfig{},
+		},
+		{
+			name:    "Custom object without API name",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "customObject.",
+			},
+			expectedCon: SourceConfig{},
+		},
+		{
+			name:    "Multiple objects without the program IDs of program members",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "leads,programMembers",
+			},
+			expectedCon: SourceConfig{},
+		},
+		{
+			name:    "Duplicate object",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "leads,leads",
+			},
+			expectedCon: SourceConfig{},
+		},
+		{
+			name:    "Invalid object",
+			wantErr: true,
+			in: map[string]string{
+				"clientID":       "client_id",
+				"clientSecret":   "client_secret",
+				"clientEndpoint": "https://xxx-xxx-xxx.mktorest.com",
+				"object":         "wrong",
+			},
+			expectedCon: SourceConfig{},
+		},
 	}
 
 	for _, tt := range configTests {
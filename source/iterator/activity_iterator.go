@@ -0,0 +1,333 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+// Metadata keys of activity records.
+const (
+	// MetadataActivityTypeID is the metadata key holding the activity type id of an activity.
+	MetadataActivityTypeID = "activityTypeId"
+	// MetadataActivityType is the metadata key holding the activity type name of an activity.
+	MetadataActivityType = "activityType"
+	// MetadataLeadID is the metadata key holding the id of the lead an activity belongs to.
+	MetadataLeadID = "leadId"
+)
+
+// activity read by the ActivityIterator
+type activityRecord struct {
+	id        int
+	data      map[string]interface{}
+	pageToken string // paging token of the page the activity was read from
+	batch     int    // index of the batch of activity types the page was requested for
+	pollToken string // paging token of the poll, if batches follow
+}
+
+type ActivityIterator struct {
+	*poller
+	client        *marketoclient.Client              // marketo client
+	activityTypes map[int]marketoclient.ActivityType // activity types to read by id
+	since         time.Time                          // time to start the first poll from, if pageToken is empty
+	pageToken     string                             // paging token to start the next poll from
+	batch         int                                // batch of activity types to start the next poll with
+	resumeToken   string                             // paging token of the page to resume the batch from, if any
+	resumeKey     int                                // id of the last activity read of the resumed batch
+	lastKey       int                                // id of the last activity read, activities up to it are skipped
+}
+
+// returns activity types of the instance by id, restricted to the given type ids if any.
+func GetActivityTypes(client *marketoclient.Client, activityTypeIDs []int) (map[int]marketoclient.ActivityType, error) {
+	types, err := client.GetActivityTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get activity types: %w", err)
+	}
+	var activityTypes = make(map[int]marketoclient.ActivityType)
+	for _, t := range types {
+		activityTypes[t.ID] = t
+	}
+	if len(activityTypeIDs) == 0 {
+		return activityTypes, nil
+	}
+	var filtered = make(map[int]marketoclient.ActivityType)
+	for _, id := range activityTypeIDs {
+		t, ok := activityTypes[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown activity type id %d", id)
+		}
+		filtered[id] = t
+	}
+	return filtered, nil
+}
+
+// returns NewActivityIterator which polls Marketo for activities of the given types, see GetActivityTypes.
+// If position holds a page token, polling resumes from that page of its batch of activity types, skipping the
+// activities up to the position key, and the following batches start from the poll token. Otherwise polling starts
+// from since, skipping the activities up to the position key, the last exported activity.
+func NewActivityIterator(ctx context.Context, client *marketoclient.Client, pollingPeriod time.Duration, activityTypes map[int]marketoclient.ActivityType, since time.Time, p position.Position) (*ActivityIterator, error) {
+	var key int
+	if p.Key != "" {
+		var err error
+		key, err = strconv.Atoi(p.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid activity position key %q: %w", p.Key, err)
+		}
+	}
+	iterator := &ActivityIterator{
+		client:        client,
+		activityTypes: activityTypes,
+		since:         since.UTC(),
+		pageToken:     p.PageToken,
+		batch:         p.Batch,
+		resumeToken:   p.PageToken,
+	}
+	if p.PollToken != "" {
+		iterator.pageToken = p.PollToken
+	}
+	if p.PageToken != "" {
+		iterator.resumeKey = key
+	} else {
+		iterator.lastKey = key
+	}
+	iterator.poller = newPoller(ctx, "activity", pollingPeriod, false, iterator.flushLatestActivities)
+	return iterator, nil
+}
+
+// fetches activities since the page token and pushes them to the buffer page by page, each page sorted by id.
+// Activity types are requested in batches of at most marketoclient.MaxActivityTypeIDs types, one batch after the
+// other, every batch starting from the page token of the poll. Every activity holds the page token of its page and
+// its batch in its position, so a restart resumes from the last acknowledged activity. Once the activities are
+// acknowledged, the next poll starts from the page token of the time this poll started, skipping the activities
+// already read.
+func (a *ActivityIterator) flushLatestActivities(ctx context.Context, push func(sdk.Record) error) (func(), error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "flushLatestActivities").Logger()
+	logger.Trace().Msg("Starting the flushLatestActivities")
+
+	var err error
+	pollToken := a.pageToken
+	if pollToken == "" {
+		pollToken, err = a.client.GetNextPageToken(a.since)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error while getting the next page token")
+			return nil, fmt.Errorf("error getting next page token %w", err)
+		}
+	}
+	nextToken, err := a.client.GetNextPageToken(time.Now())
+	if err != nil {
+		logger.Error().Err(err).Msg("Error while getting the next page token")
+		return nil, fmt.Errorf("error getting next page token %w", err)
+	}
+
+	var typeIDs = make([]int, 0, len(a.activityTypes))
+	for id := range a.activityTypes {
+		typeIDs = append(typeIDs, id)
+	}
+	sort.Ints(typeIDs)
+	var batches [][]int
+	for i := 0; i < len(typeIDs); i += marketoclient.MaxActivityTypeIDs {
+		end := i + marketoclient.MaxActivityTypeIDs
+		if end > len(typeIDs) {
+			end = len(typeIDs)
+		}
+		batches = append(batches, typeIDs[i:end])
+	}
+	lastKey := a.lastKey
+	if a.resumeKey > lastKey {
+		lastKey = a.resumeKey
+	}
+	var count int
+	for b := a.batch; b < len(batches); b++ {
+		pageToken, skipKey := pollToken, a.lastKey
+		if b == a.batch && a.resumeToken != "" {
+			pageToken, skipKey = a.resumeToken, a.resumeKey
+		}
+		var batchPollToken string
+		if b < len(batches)-1 {
+			batchPollToken = pollToken
+		}
+		moreResult := true
+		for moreResult {
+			response, err := a.client.GetActivities(pageToken, batches[b])
+			if err != nil {
+				logger.Error().Err(err).Msg("Error while getting the activities")
+				return nil, fmt.Errorf("error getting activities %w", err)
+			}
+			var activities []map[string]interface{}
+			if len(response.Result) > 0 {
+				err = json.Unmarshal(response.Result, &activities)
+				if err != nil {
+					return nil, fmt.Errorf("error unmarshalling activities %w", err)
+				}
+			}
+			var records = make([]activityRecord, 0, len(activities))
+			for _, activity := range activities {
+				id, ok := activity["id"].(float64)
+				if !ok || int(id) <= skipKey {
+					continue
+				}
+				records = append(records, activityRecord{id: int(id), data: activity, pageToken: pageToken, batch: b, pollToken: batchPollToken})
+			}
+			sort.Slice(records, func(i, j int) bool { return records[i].id < records[j].id })
+			for _, r := range records {
+				record, err := a.prepareRecord(r)
+				if err != nil {
+					return nil, err
+				}
+				err = push(record)
+				if err != nil {
+					return nil, err
+				}
+				if r.id > lastKey {
+					lastKey = r.id
+				}
+			}
+			count += len(records)
+			moreResult = response.MoreResult
+			pageToken = response.NextPageToken
+		}
+	}
+	logger.Trace().Msgf("Flushed %d activities", count)
+	return func() {
+		a.pageToken, a.batch, a.resumeToken, a.resumeKey, a.lastKey = nextToken, 0, "", 0, lastKey
+	}, nil
+}
+
+// returns record in the format of sdk.Record
+func (a *ActivityIterator) prepareRecord(r activityRecord) (sdk.Record, error) {
+	key := strconv.Itoa(r.id)
+	activityDate, err := time.Parse(time.RFC3339, fmt.Sprint(r.data["activityDate"]))
+	if err != nil {
+		return sdk.Record{}, fmt.Errorf("error parsing activityDate %w", err)
+	}
+	position := position.Position{
+		Type:      position.TypeCDC,
+		Key:       key,
+		CreatedAt: activityDate,
+		UpdatedAt: activityDate,
+		PageToken: r.pageToken,
+		Batch:     r.batch,
+		PollToken: r.pollToken,
+	}
+	pos, err := position.ToRecordPosition()
+	if err != nil {
+		return sdk.Record{}, err
+	}
+
+	data := typedActivity(a.activityTypes, r.data)
+	return sdk.Util.Source.NewRecordCreate(pos, activityMetadata(key, activityDate, data), sdk.RawData(key), sdk.StructuredData(data)), nil
+}
+
+// returns the metadata of an activity record.
+func activityMetadata(key string, activityDate time.Time, activity map[string]interface{}) sdk.Metadata {
+	metadata := make(sdk.Metadata)
+	metadata["id"] = key
+	metadata.SetCreatedAt(activityDate)
+	metadata[MetadataActivityTypeID] = fmt.Sprint(activity["activityTypeId"])
+	metadata[MetadataActivityType] = fmt.Sprint(activity["activityType"])
+	metadata[MetadataLeadID] = fmt.Sprint(activity["leadId"])
+	return metadata
+}
+
+// returns the activity with ids as integers, the activity type name, and the primary attribute value and
+// attributes converted to the data types of the activity type. Attributes are keyed by name, they are given either
+// as a list of name and value pairs (REST API) or as a map (bulk export).
+func typedActivity(activityTypes map[int]marketoclient.ActivityType, activity map[string]interface{}) map[string]interface{} {
+	var data = make(map[string]interface{}, len(activity)+1)
+	for k, v := range activity {
+		data[k] = v
+	}
+	for _, k := range []string{"id", "leadId", "activityTypeId", "campaignId", "primaryAttributeValueId"} {
+		if v, ok := typedValue("integer", data[k]).(int64); ok {
+			data[k] = v
+		}
+	}
+	activityTypeID, _ := data["activityTypeId"].(int64)
+	activityType := activityTypes[int(activityTypeID)]
+	data["activityType"] = activityType.Name
+	if v, ok := data["primaryAttributeValue"]; ok {
+		data["primaryAttributeValue"] = typedValue(activityType.PrimaryAttribute.DataType, v)
+	}
+	var dataTypes = make(map[string]string, len(activityType.Attributes))
+	for _, attribute := range activityType.Attributes {
+		dataTypes[attribute.Name] = attribute.DataType
+	}
+	var attributes = make(map[string]interface{})
+	switch list := activity["attributes"].(type) {
+	case []interface{}:
+		for _, item := range list {
+			attribute, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name := fmt.Sprint(attribute["name"])
+			attributes[name] = typedValue(dataTypes[name], attribute["value"])
+		}
+	case map[string]interface{}:
+		for name, value := range list {
+			attributes[name] = typedValue(dataTypes[name], value)
+		}
+	}
+	data["attributes"] = attributes
+	return data
+}
+
+// converts a string attribute value to the given Marketo data type. Values which can't be converted are returned
+// unchanged.
+func typedValue(dataType string, value interface{}) interface{} {
+	s, ok := value.(string)
+	if !ok {
+		switch v := value.(type) {
+		case float64:
+			if isIntegerType(dataType) {
+				return int64(v)
+			}
+		case int:
+			if isIntegerType(dataType) {
+				return int64(v)
+			}
+		}
+		return value
+	}
+	switch {
+	case isIntegerType(dataType):
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return v
+		}
+	case dataType == "float" || dataType == "currency" || dataType == "percent":
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			return v
+		}
+	case dataType == "boolean":
+		if v, err := strconv.ParseBool(strings.ToLower(s)); err == nil {
+			return v
+		}
+	}
+	return s
+}
+
+func isIntegerType(dataType string) bool {
+	return dataType == "integer" || dataType == "reference" || dataType == "score"
+}
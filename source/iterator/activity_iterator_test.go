@@ -0,0 +1,333 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+// returns a fake Marketo serving two pages of activities of types 2 and 7, the first out of id order.
+func newActivitiesServer(t *testing.T) *marketotest.Server {
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/activities/types.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []marketoclient.ActivityType{
+			{ID: 1, Name: "Visit Webpage"},
+			{
+				ID:               2,
+				Name:             "Fill Out Form",
+				PrimaryAttribute: marketoclient.ActivityTypeAttribute{Name: "Webform ID", DataType: "integer"},
+				Attributes:       []marketoclient.ActivityTypeAttribute{{Name: "Client IP Address", DataType: "string"}},
+			},
+			{
+				ID:               7,
+				Name:             "Email Delivered",
+				PrimaryAttribute: marketoclient.ActivityTypeAttribute{Name: "Mailing ID", DataType: "integer"},
+				Attributes: []marketoclient.ActivityTypeAttribute{
+					{Name: "Choice Number", DataType: "integer"},
+					{Name: "Is Mobile Device", DataType: "boolean"},
+				},
+			},
+		}, "", false)
+	})
+	f.Handle("/rest/v1/activities/pagingtoken.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, nil, "page-0", false)
+	})
+	f.Handle("/rest/v1/activities.json", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("activityTypeIds"); got != "2,7" {
+			t.Errorf("expected activity type ids %q, got %q", "2,7", got)
+		}
+		if r.URL.Query().Get("nextPageToken") == "page-0" {
+			marketotest.WriteResult(w, []map[string]interface{}{
+				testActivity(103, 7, "5", []map[string]interface{}{
+					{"name": "Choice Number", "value": "3"},
+					{"name": "Is Mobile Device", "value": "true"},
+				}),
+				testActivity(101, 2, "12", []map[string]interface{}{
+					{"name": "Client IP Address", "value": "10.0.0.1"},
+				}),
+			}, "page-1", true)
+			return
+		}
+		marketotest.WriteResult(w, []map[string]interface{}{
+			testActivity(104, 2, "13", nil),
+		}, "page-2", false)
+	})
+	return f
+}
+
+func testActivity(id, activityTypeID int, primaryAttributeValue string, attributes []map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                    id,
+		"marketoGUID":           "guid",
+		"leadId":                1000 + id,
+		"activityDate":          "2022-01-02T00:00:00Z",
+		"activityTypeId":        activityTypeID,
+		"primaryAttributeValue": primaryAttributeValue,
+		"attributes":            attributes,
+	}
+}
+
+func TestActivityIterator_TypedActivities(t *testing.T) {
+	ctx := context.Background()
+	f := newActivitiesServer(t)
+	client := *f.Client(t)
+	activityTypes, err := GetActivityTypes(&client, []int{7, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := NewActivityIterator(ctx, &client, 10*time.Millisecond, activityTypes, time.Now(), position.Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readRecords(ctx, t, it, 3)
+	for i, want := range []struct{ key, pageToken string }{{"101", "page-0"}, {"103", "page-0"}, {"104", "page-1"}} {
+		if got := string(records[i].Key.Bytes()); got != want.key {
+			t.Errorf("expected record %d to have key %s, got %s", i, want.key, got)
+		}
+		p, err := position.ParseRecordPosition(records[i].Position)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.PageToken != want.pageToken || p.Key != want.key || p.Type != position.TypeCDC || p.Batch != 0 || p.PollToken != "" {
+			t.Errorf("unexpected position %+v of record %s", p, want.key)
+		}
+	}
+
+	rec := records[1]
+	if rec.Operation != sdk.OperationCreate || rec.Metadata[MetadataActivityType] != "Email Delivered" ||
+		rec.Metadata[MetadataActivityTypeID] != "7" || rec.Metadata[MetadataLeadID] != "1103" {
+		t.Errorf("unexpected record %v with metadata %v", rec.Operation, rec.Metadata)
+	}
+	data := rec.Payload.After.(sdk.StructuredData)
+	if data["primaryAttributeValue"] != int64(5) {
+		t.Errorf("expected typed primary attribute value 5, got %#v", data["primaryAttributeValue"])
+	}
+	want := map[string]interface{}{"Choice Number": int64(3), "Is Mobile Device": true}
+	if !reflect.DeepEqual(data["attributes"], want) {
+		t.Errorf("expected attributes %v, got %v", want, data["attributes"])
+	}
+
+	// activities already read are skipped by the following polls.
+	time.Sleep(50 * time.Millisecond)
+	if it.HasNext(ctx) {
+		t.Error("expected no more records")
+	}
+}
+
+func TestActivityIterator_ResumeFromPosition(t *testing.T) {
+	ctx := context.Background()
+	f := newActivitiesServer(t)
+	client := *f.Client(t)
+	activityTypes, err := GetActivityTypes(&client, []int{2, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := NewActivityIterator(ctx, &client, 10*time.Millisecond, activityTypes, time.Now(), position.Position{
+		Type:      position.TypeCDC,
+		Key:       "101",
+		PageToken: "page-0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readRecords(ctx, t, it, 2)
+	for i, want := range []string{"103", "104"} {
+		if got := string(records[i].Key.Bytes()); got != want {
+			t.Errorf("expected record %d to be activity %s, got %s", i, want, got)
+		}
+	}
+}
+
+func TestActivityIterator_ResumeBatch(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	var activityTypes = make(map[int]marketoclient.ActivityType)
+	for id := 1; id <= 11; id++ {
+		activityTypes[id] = marketoclient.ActivityType{ID: id, Name: fmt.Sprintf("Type %d", id)}
+	}
+	f.Handle("/rest/v1/activities/pagingtoken.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, nil, "page-0", false)
+	})
+	// the first batch has two pages, the second batch one page.
+	f.Handle("/rest/v1/activities.json", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch query.Get("activityTypeIds") + "@" + query.Get("nextPageToken") {
+		case "1,2,3,4,5,6,7,8,9,10@page-0":
+			marketotest.WriteResult(w, []map[string]interface{}{testActivity(201, 1, "", nil)}, "batch-0-page-1", true)
+		case "1,2,3,4,5,6,7,8,9,10@batch-0-page-1":
+			marketotest.WriteResult(w, []map[string]interface{}{testActivity(203, 10, "", nil)}, "page-1", false)
+		case "11@page-0":
+			marketotest.WriteResult(w, []map[string]interface{}{testActivity(202, 11, "", nil)}, "page-1", false)
+		default:
+			marketotest.WriteResult(w, []interface{}{}, "page-1", false)
+		}
+	})
+	client := *f.Client(t)
+	open := func(p position.Position) *ActivityIterator {
+		it, err := NewActivityIterator(ctx, &client, 10*time.Millisecond, activityTypes, time.Now(), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return it
+	}
+	it := open(position.Position{})
+	records := readRecords(ctx, t, it, 3)
+	it.Stop()
+	var positions []position.Position
+	for _, rec := range records {
+		p, err := position.ParseRecordPosition(rec.Position)
+		if err != nil {
+			t.Fatal(err)
+		}
+		positions = append(positions, p)
+	}
+	want := []position.Position{
+		{Key: "201", PageToken: "page-0", Batch: 0, PollToken: "page-0"},
+		{Key: "203", PageToken: "batch-0-page-1", Batch: 0, PollToken: "page-0"},
+		{Key: "202", PageToken: "page-0", Batch: 1},
+	}
+	for i, p := range positions {
+		if p.Key != want[i].Key || p.PageToken != want[i].PageToken || p.Batch != want[i].Batch || p.PollToken != want[i].PollToken {
+			t.Errorf("expected record %d to have position %+v, got %+v", i, want[i], p)
+		}
+	}
+
+	// a restart from the first activity resumes the first batch, then reads the second batch from the poll token.
+	it = open(positions[0])
+	defer it.Stop()
+	records = readRecords(ctx, t, it, 2)
+	for i, want := range []string{"203", "202"} {
+		if got := string(records[i].Key.Bytes()); got != want {
+			t.Errorf("expected resumed record %d to be activity %s, got %s", i, want, got)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if it.HasNext(ctx) {
+		t.Error("expected no more records")
+	}
+}
+
+func TestCombinedActivityIterator_BulkExport(t *testing.T) {
+	ctx := context.Background()
+	f := newActivitiesServer(t)
+	var exports int
+	f.Handle("/bulk/v1/activities/export/create.json", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Filter struct {
+				CreatedAt       map[string]string `json:"createdAt"`
+				ActivityTypeIDs []int             `json:"activityTypeIds"`
+			} `json:"filter"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(body.Filter.ActivityTypeIDs, []int{2, 7}) || body.Filter.CreatedAt["startAt"] == "" {
+			t.Errorf("unexpected export filter %+v", body.Filter)
+		}
+		exports++
+		marketotest.WriteResult(w, []marketoclient.CreateExportResult{{ExportID: fmt.Sprintf("export-%d", exports)}}, "", false)
+	})
+	f.Handle("/bulk/v1/activities/export/export-1/enqueue.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, nil, "", false)
+	})
+	f.Handle("/bulk/v1/activities/export/export-2/enqueue.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, nil, "", false)
+	})
+	f.Handle("/bulk/v1/activities/export/export-1/status.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []marketoclient.StatusOfExportResult{{Status: "Completed", NumberOfRecords: 2}}, "", false)
+	})
+	f.Handle("/bulk/v1/activities/export/export-2/status.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []marketoclient.StatusOfExportResult{{Status: "Completed", NumberOfRecords: 1}}, "", false)
+	})
+	header := "marketoGUID,leadId,activityDate,activityTypeId,campaignId,primaryAttributeValueId,primaryAttributeValue,attributes\n"
+	files := map[string]string{
+		"export-1": header +
+			"90,1090,2022-01-02T00:00:00Z,7,,,5,\"{\"\"Choice Number\"\":3,\"\"Is Mobile Device\"\":true}\"\n" +
+			"91,1091,2022-01-03T00:00:00Z,2,,,12,\n",
+		"export-2": header +
+			"92,1092,2022-02-03T00:00:00Z,2,,,12,\"{\"\"Client IP Address\"\":\"\"10.0.0.1\"\"}\"\n",
+	}
+	for id, file := range files {
+		file := file
+		f.Handle("/bulk/v1/activities/export/"+id+"/file.json", func(w http.ResponseWriter, r *http.Request) {
+			if got := r.Header.Get("Authorization"); got != "Bearer token" {
+				t.Errorf("expected authorization %q, got %q", "Bearer token", got)
+			}
+			_, _ = w.Write([]byte(file))
+		})
+	}
+	client := *f.Client(t)
+	it, err := NewCombinedActivityIterator(ctx, f.URL, 10*time.Millisecond, client, position.Position{},
+		time.Now().Add(-40*24*time.Hour), []int{2, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	var records []sdk.Record
+	deadline := time.Now().Add(10 * time.Second)
+	for len(records) < 6 && time.Now().Before(deadline) {
+		if !it.HasNext(ctx) {
+			time.Sleep(5 * time.Millisecond)
+			continue
+		}
+		rec, err := it.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 6 {
+		t.Fatalf("expected 6 records, got %d", len(records))
+	}
+	// exported activities are followed by the activity stream, skipping the exported ones.
+	for i, want := range []string{"90", "91", "92", "101", "103", "104"} {
+		if got := string(records[i].Key.Bytes()); got != want {
+			t.Errorf("expected record %d to have key %s, got %s", i, want, got)
+		}
+	}
+	for _, rec := range records[:2] {
+		if rec.Operation != sdk.OperationSnapshot {
+			t.Errorf("expected snapshot record, got %v", rec.Operation)
+		}
+	}
+	data := records[0].Payload.After.(sdk.StructuredData)
+	want := map[string]interface{}{"Choice Number": int64(3), "Is Mobile Device": true}
+	if !reflect.DeepEqual(data["attributes"], want) || data["leadId"] != int64(1090) || data["activityType"] != "Email Delivered" {
+		t.Errorf("unexpected exported activity %v", data)
+	}
+	p, err := position.ParseRecordPosition(records[2].Position)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Type != position.TypeCDC || p.Key != "92" {
+		t.Errorf("expected the last exported activity to have a CDC position, got %+v", p)
+	}
+}
@@ -17,16 +17,15 @@ package iterator
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	sdk "github.com/conduitio/conduit-connector-sdk"
 	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
 	"github.com/rustiever/conduit-connector-marketo/source/position"
-	"gopkg.in/tomb.v2"
 )
 
 // ActivityTypeID to capture required CDC events.
@@ -34,80 +33,66 @@ import (
 const (
 	ActivityTypeIDNewLead         = 12
 	ActivityTypeIDChangeDataValue = 13
+	ActivityTypeIDMergeLeads      = 32
+)
+
+// Metadata keys of CDC records.
+const (
+	// MetadataChangedFields is the metadata key holding comma separated names of the fields changed by an update.
+	MetadataChangedFields = "changedFields"
+	// MetadataPartial is the metadata key set to "true" on update records holding the changed fields only.
+	MetadataPartial = "partial"
+	// MetadataMergedFrom is the metadata key holding comma separated ids of the leads merged into the lead.
+	MetadataMergedFrom = "mergedFrom"
+	// MetadataMergedInto is the metadata key holding the id of the lead a deleted lead was merged into.
+	MetadataMergedInto = "mergedInto"
 )
 
 // custom Record type to handle CDC
 type Record struct {
-	id      int
-	data    map[string]interface{}
-	deleted bool
+	id        int
+	data      map[string]interface{}
+	deleted   bool
+	changes   *leadChange // field changes of the lead, if known
+	partial   bool        // data holds the changed fields only
+	merged    []int       // ids of leads merged into the lead
+	mergedTo  int         // id of the lead the deleted lead was merged into
+	pageToken string      // paging token of the activity page the record was read from
+	resumeKey string      // key to skip up to when resuming from pageToken
 }
 
 type CDCIterator struct {
-	client       *marketoclient.Client // marketo client
-	fields       []string              // fields to fetch from marketo
-	buffer       chan Record           // buffer to store latest leads
-	ticker       *time.Ticker          // ticker to poll marketo
-	tomb         *tomb.Tomb            // tomb to handle errors in goRoutines
-	lastModified time.Time             // last time fetched from marketo
-	lastEntryKey string                // last key fetched from marketo
+	*poller
+	client          *marketoclient.Client // marketo client
+	fields          []string              // fields to fetch from marketo
+	lastModified    time.Time             // last time fetched from marketo
+	lastEntryKey    string                // last key fetched from marketo
+	partial         bool                  // builds update records from changed fields without fetching leads
+	activityTypeIDs []int                 // additional activity types which trigger a lead refresh
+	pageToken       string                // paging token to start the next poll from
+	resumeKey       string                // last key processed on the page of pageToken
 }
 
-func NewCDCIterator(ctx context.Context, client *marketoclient.Client, pollingPeriod time.Duration, fields []string, lastModifiedTime time.Time, lastKey string) (*CDCIterator, error) {
+// returns NewCDCIterator which polls Marketo from the supplied position. If position holds a page token,
+// polling resumes from that page, otherwise it starts from lastModifiedTime. If partial is set, updates are built
+// from the changed fields only, see config.CDCModePartial. Leads with activities of activityTypeIDs are refreshed
+// in addition to leads changed by New Lead and Change Data Value activities.
+func NewCDCIterator(ctx context.Context, client *marketoclient.Client, pollingPeriod time.Duration, fields []string, lastModifiedTime time.Time, p position.Position, partial bool, activityTypeIDs []int) (*CDCIterator, error) {
 	iterator := &CDCIterator{
-		client:       client,
-		buffer:       make(chan Record, 1),
-		ticker:       time.NewTicker(pollingPeriod),
-		tomb:         &tomb.Tomb{},
-		fields:       fields,
-		lastEntryKey: lastKey,
-		lastModified: lastModifiedTime.UTC(),
-	}
-	iterator.tomb.Go(func() error {
-		return iterator.poll(ctx)
-	})
-	return iterator, nil
-}
-
-// poll is the main goRoutine that polls marketo for new leads
-func (c *CDCIterator) poll(ctx context.Context) error {
-	defer close(c.buffer)
-	for {
-		select {
-		case <-c.tomb.Dying():
-			return c.tomb.Err()
-		case <-c.ticker.C:
-			err := c.flushLatestLeads(ctx)
-			if err != nil {
-				return err
-			}
-		}
+		client:          client,
+		fields:          fields,
+		lastModified:    lastModifiedTime.UTC(),
+		partial:         partial,
+		activityTypeIDs: activityTypeIDs,
 	}
-}
-
-// returns true if there are more records to be read from the iterator's buffer, otherwise returns false.
-func (c *CDCIterator) HasNext(ctx context.Context) bool {
-	logger := sdk.Logger(ctx).With().Str("Method", "Has Next").Logger()
-	logger.Trace().Msg("Checking iterator has next record...")
-	return len(c.buffer) > 0 || !c.tomb.Alive() // if tomb is dead we return true so caller will fetch error with Next
-}
-
-// returns Next record from the iterator's buffer, otherwise returns error.
-func (c *CDCIterator) Next(ctx context.Context) (sdk.Record, error) {
-	select {
-	case r := <-c.buffer:
-		return c.prepareRecord(r)
-	case <-c.tomb.Dead():
-		return sdk.Record{}, c.tomb.Err()
-	case <-ctx.Done():
-		return sdk.Record{}, ctx.Err()
+	if p.PageToken != "" {
+		iterator.pageToken = p.PageToken
+		iterator.resumeKey = p.Key
+	} else {
+		iterator.lastEntryKey = p.Key
 	}
-}
-
-func (c *CDCIterator) Stop() {
-	// stop the goRoutines
-	c.ticker.Stop()
-	c.tomb.Kill(errors.New("cdc iterator is stopped"))
+	iterator.poller = newPoller(ctx, "cdc", pollingPeriod, false, iterator.flushLatestLeads)
+	return iterator, nil
 }
 
 // returns record in the format of sdk.Record
@@ -116,9 +101,10 @@ func (c *CDCIterator) prepareRecord(r Record) (sdk.Record, error) {
 	if r.deleted {
 		position := position.Position{
 			Type:      position.TypeCDC,
-			Key:       key,
+			Key:       r.resumeKey,
 			CreatedAt: time.Now().UTC(),
 			UpdatedAt: time.Now().UTC(),
+			PageToken: r.pageToken,
 		}
 		pos, err := position.ToRecordPosition()
 		if err != nil {
@@ -127,9 +113,15 @@ func (c *CDCIterator) prepareRecord(r Record) (sdk.Record, error) {
 
 		metadata := make(sdk.Metadata)
 		metadata.SetCreatedAt(time.Now())
+		if r.mergedTo != 0 {
+			metadata[MetadataMergedInto] = strconv.Itoa(r.mergedTo)
+		}
 
 		return sdk.Util.Source.NewRecordDelete(pos, metadata, sdk.RawData(key)), nil
 	}
+	if r.partial {
+		return c.preparePartialRecord(r)
+	}
 	createdAt, err := time.Parse(time.RFC3339, fmt.Sprintf("%s", r.data["createdAt"]))
 	if err != nil {
 		return sdk.Record{}, fmt.Errorf("error parsing createdAt %w", err)
@@ -140,9 +132,10 @@ func (c *CDCIterator) prepareRecord(r Record) (sdk.Record, error) {
 	}
 	position, _ := position.Position{
 		Type:      position.TypeCDC,
-		Key:       key,
+		Key:       r.resumeKey,
 		CreatedAt: createdAt,
 		UpdatedAt: updatedAt,
+		PageToken: r.pageToken,
 	}.ToRecordPosition()
 	r.data["id"] = key
 
@@ -150,10 +143,18 @@ func (c *CDCIterator) prepareRecord(r Record) (sdk.Record, error) {
 	metadata["id"] = key
 	metadata.SetCreatedAt(createdAt)
 	metadata["updatedAt"] = strconv.FormatInt(updatedAt.UnixNano(), 10)
+	if len(r.merged) > 0 {
+		metadata[MetadataMergedFrom] = marketoclient.JoinInts(r.merged)
+	}
 
 	if createdAt != updatedAt {
+		var before sdk.Data
+		if r.changes != nil && len(r.changes.fields) > 0 {
+			before = r.changes.before(key)
+			metadata[MetadataChangedFields] = strings.Join(r.changes.fields, ",")
+		}
 		return sdk.Util.Source.NewRecordUpdate(
-			position, metadata, sdk.RawData(key), nil, sdk.StructuredData(r.data),
+			position, metadata, sdk.RawData(key), before, sdk.StructuredData(r.data),
 		), nil
 	}
 
@@ -163,130 +164,505 @@ func (c *CDCIterator) prepareRecord(r Record) (sdk.Record, error) {
 }
 
 // fetches latest leads from marketo and stores them in the buffer.
-func (c *CDCIterator) flushLatestLeads(ctx context.Context) error {
+// Deleted leads, merged leads and leads refreshed due to configured activity types are flushed first with the
+// position of the poll's first page, then changed leads are flushed
+// page by page, each record carrying the token of its page, so a restart resumes from the last acknowledged record.
+func (c *CDCIterator) flushLatestLeads(ctx context.Context, push func(sdk.Record) error) (func(), error) {
 	logger := sdk.Logger(ctx).With().Str("Method", "flushLatestLeads").Logger()
 	logger.Trace().Msg("Starting the flushLatestLeads")
-	token, err := c.client.GetNextPageToken(c.lastModified)
-	if err != nil {
-		logger.Error().Err(err).Msg("Error while getting the next page token")
-		return fmt.Errorf("error getting next page token %w", err)
+	pushRecord := func(r Record) error {
+		record, err := c.prepareRecord(r)
+		if err != nil {
+			return err
+		}
+		return push(record)
+	}
+	token := c.pageToken
+	if token == "" {
+		var err error
+		token, err = c.client.GetNextPageToken(c.lastModified)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error while getting the next page token")
+			return nil, fmt.Errorf("error getting next page token %w", err)
+		}
 	}
-	c.lastModified = time.Now().UTC() // updating last modified time here to avoid missing any records in the next poll.
-	changedLeadIds, changedLeadMaps, err := c.GetChangedLeadsIDs(ctx, token)
+	pages, nextToken, err := c.GetChangedLeadsIDs(ctx, token)
 	if err != nil {
 		logger.Error().Err(err).Msg("Error while getting the changed leads")
-		return fmt.Errorf("error getting changed leads %w", err)
+		return nil, fmt.Errorf("error getting changed leads %w", err)
 	}
+	// deleted leads are requested after the lead changes, so the next poll starting from nextToken can't miss any.
 	deletedLeadIds, err := c.GetDeletedLeadsIDs(ctx, token)
 	if err != nil {
 		logger.Error().Err(err).Msg("Error while getting the deleted leads")
-		return fmt.Errorf("error getting deleted leads %w", err)
+		return nil, fmt.Errorf("error getting deleted leads %w", err)
+	}
+	merges, err := c.GetMergedLeads(ctx, token)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error while getting the merged leads")
+		return nil, fmt.Errorf("error getting merged leads %w", err)
+	}
+	refreshedLeadIds, err := c.GetActivityLeadsIDs(ctx, token, pages)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error while getting the leads of configured activities")
+		return nil, fmt.Errorf("error getting leads of configured activities %w", err)
 	}
 	var lastKey = -1 // -1 indicates no last key, so proccess all leads
 	if c.lastEntryKey != "" {
 		lastKey, err = strconv.Atoi(c.lastEntryKey)
 		if err != nil {
 			logger.Error().Err(err).Msg("Error while parsing the last entry key")
-			return fmt.Errorf("error parsing last entry key %w", err)
+			return nil, fmt.Errorf("error parsing last entry key %w", err)
 		}
 	}
-	for _, id := range deletedLeadIds {
-		c.buffer <- Record{
-			id:      id,
-			deleted: true,
-			data:    nil,
+	var resumeKey = -1 // -1 indicates the poll doesn't resume in the middle of a page
+	if c.resumeKey != "" {
+		resumeKey, err = strconv.Atoi(c.resumeKey)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error while parsing the resume key")
+			return nil, fmt.Errorf("error parsing resume key %w", err)
 		}
 	}
-	if len(changedLeadIds) == 0 {
-		return nil
+	var merged = make(map[int]bool) // winners and losers of merges, which are flushed with merge metadata
+	var winners []int
+	for _, merge := range merges {
+		merged[merge.winner] = true
+		winners = append(winners, merge.winner)
+		for _, id := range merge.losers {
+			merged[id] = true
+		}
 	}
-	var leads []map[string]interface{}
-	var moreResult = true
-	token = ""
-	for moreResult {
-		res, err := c.client.FilterLeads("id", changedLeadIds, c.fields, token)
+	for _, id := range deletedLeadIds {
+		if merged[id] {
+			continue
+		}
+		err := pushRecord(Record{
+			id:        id,
+			deleted:   true,
+			data:      nil,
+			pageToken: token,
+		})
 		if err != nil {
-			logger.Error().Err(err).Msg("Error while getting the changed leads")
-			return fmt.Errorf("error getting changed leads %w", err)
+			return nil, err
 		}
-		moreResult = res.MoreResult
-		token = res.NextPageToken
-		err = json.Unmarshal(res.Result, &leads)
+	}
+	// merged and refreshed leads share the position of deleted leads.
+	mergeWinners, err := c.getLeads(ctx, winners)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error while getting the merged leads")
+		return nil, fmt.Errorf("error getting merged leads %w", err)
+	}
+	for _, merge := range merges {
+		for _, id := range merge.losers {
+			err := pushRecord(Record{
+				id:        id,
+				deleted:   true,
+				mergedTo:  merge.winner,
+				pageToken: token,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		if mergeWinners[merge.winner] == nil {
+			// winner was deleted or merged into another lead afterwards.
+			continue
+		}
+		err := pushRecord(Record{
+			id:        merge.winner,
+			data:      mergeWinners[merge.winner],
+			merged:    merge.losers,
+			pageToken: token,
+		})
 		if err != nil {
-			logger.Error().Err(err).Msg("Error while unmarshalling the changed leads")
-			return fmt.Errorf("error unmarshalling changed leads %w", err)
+			return nil, err
 		}
 	}
-
-	for _, lead := range leads {
-		id := int(lead["id"].(float64))
-		if id <= lastKey && changedLeadMaps[id] == ActivityTypeIDNewLead {
+	refreshedLeads, err := c.getLeads(ctx, refreshedLeadIds)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error while getting the leads of configured activities")
+		return nil, fmt.Errorf("error getting leads of configured activities %w", err)
+	}
+	for _, id := range refreshedLeadIds {
+		if refreshedLeads[id] == nil || merged[id] {
 			continue
 		}
-		c.buffer <- Record{
-			id:      id,
-			deleted: false,
-			data:    lead,
+		err := pushRecord(Record{
+			id:        id,
+			data:      refreshedLeads[id],
+			pageToken: token,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	// changes of merged leads are part of their merge records above.
+	for i, page := range pages {
+		var fetchIDs []int
+		for _, id := range page.leadIDs {
+			if !merged[id] && !c.isPartial(page.changes[id]) {
+				fetchIDs = append(fetchIDs, id)
+			}
+		}
+		leads, err := c.getLeads(ctx, fetchIDs)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error while getting the changed leads")
+			return nil, fmt.Errorf("error getting changed leads %w", err)
+		}
+		for _, id := range page.leadIDs {
+			if merged[id] || i == 0 && id <= resumeKey {
+				continue
+			}
+			if id <= lastKey && page.changes[id].activityTypeID == ActivityTypeIDNewLead {
+				continue
+			}
+			r := Record{
+				id:        id,
+				deleted:   false,
+				changes:   page.changes[id],
+				pageToken: page.token,
+				resumeKey: strconv.Itoa(id),
+			}
+			if c.isPartial(page.changes[id]) {
+				r.partial = true
+			} else if r.data = leads[id]; r.data == nil {
+				// lead was deleted after the change.
+				continue
+			}
+			err := pushRecord(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return func() {
+		c.pageToken, c.resumeKey = nextToken, ""
+	}, nil
+}
+
+// returns leads with given ids by id. ids are requested in chunks within Marketo's filter limits and results are
+// accumulated across chunks and pages.
+func (c *CDCIterator) getLeads(ctx context.Context, ids []int) (map[int]map[string]interface{}, error) {
+	var leads = make(map[int]map[string]interface{})
+	for _, chunk := range marketoclient.ChunkFilterValues("id", ids, c.fields) {
+		var moreResult = true
+		token := ""
+		for moreResult {
+			res, err := c.client.FilterLeads("id", chunk, c.fields, token)
+			if err != nil {
+				return nil, err
+			}
+			moreResult = res.MoreResult
+			token = res.NextPageToken
+			if len(res.Result) == 0 {
+				continue
+			}
+			var page []map[string]interface{}
+			err = json.Unmarshal(res.Result, &page)
+			if err != nil {
+				return nil, fmt.Errorf("error unmarshalling changed leads %w", err)
+			}
+			for _, lead := range page {
+				leads[int(lead["id"].(float64))] = lead
+			}
 		}
 	}
-	return nil
+	return leads, nil
+}
+
+// returns true if the lead change is emitted as a partial update record, without fetching the lead.
+// New leads are always fetched, since a create record needs the full lead.
+func (c *CDCIterator) isPartial(change *leadChange) bool {
+	return c.partial && !change.newLead && len(change.fields) > 0
+}
+
+// returns partial update record holding the changed fields of the lead only.
+func (c *CDCIterator) preparePartialRecord(r Record) (sdk.Record, error) {
+	key := strconv.Itoa(r.id)
+	updatedAt, err := time.Parse(time.RFC3339, r.changes.activityDate)
+	if err != nil {
+		return sdk.Record{}, fmt.Errorf("error parsing activityDate %w", err)
+	}
+	position, err := position.Position{
+		Type:      position.TypeCDC,
+		Key:       r.resumeKey,
+		UpdatedAt: updatedAt,
+		PageToken: r.pageToken,
+	}.ToRecordPosition()
+	if err != nil {
+		return sdk.Record{}, err
+	}
+
+	metadata := make(sdk.Metadata)
+	metadata["id"] = key
+	metadata["updatedAt"] = strconv.FormatInt(updatedAt.UnixNano(), 10)
+	metadata[MetadataChangedFields] = strings.Join(r.changes.fields, ",")
+	metadata[MetadataPartial] = "true"
+
+	return sdk.Util.Source.NewRecordUpdate(
+		position, metadata, sdk.RawData(key), r.changes.before(key), r.changes.after(key),
+	), nil
 }
 
 // returns list of deleted leads ids.
 func (c *CDCIterator) GetDeletedLeadsIDs(ctx context.Context, token string) ([]int, error) {
-	response, err := c.client.GetDeletedLeads(token)
+	var leadIds = make([]int, 0)
+	moreResult := true
+	for moreResult {
+		response, err := c.client.GetDeletedLeads(token)
+		if err != nil {
+			return nil, err
+		}
+		moreResult = response.MoreResult
+		token = response.NextPageToken
+		if len(response.Result) == 0 {
+			continue
+		}
+		var deletedLeadResults []map[string]interface{}
+		err = json.Unmarshal(response.Result, &deletedLeadResults)
+		if err != nil {
+			return nil, err
+		}
+		for _, deletedLeadResult := range deletedLeadResults {
+			var id = int(deletedLeadResult["leadId"].(float64))
+			leadIds = append(leadIds, id)
+		}
+	}
+	return leadIds, nil
+}
+
+// returns sorted ids of leads with activities of the configured activity types, excluding leads already changed
+// on given pages of lead changes.
+func (c *CDCIterator) GetActivityLeadsIDs(ctx context.Context, token string, pages []leadChangesPage) ([]int, error) {
+	if len(c.activityTypeIDs) == 0 {
+		return nil, nil
+	}
+	var changed = make(map[int]bool)
+	for _, page := range pages {
+		for _, id := range page.leadIDs {
+			changed[id] = true
+		}
+	}
+	activities, err := getActivities(c.client, token, c.activityTypeIDs)
 	if err != nil {
 		return nil, err
 	}
-	if len(*response) == 0 {
-		return nil, nil
+	var leadIds = make(map[int]bool) // using map to avoid duplicates
+	for _, activity := range activities {
+		var id = int(activity["leadId"].(float64))
+		if !changed[id] {
+			leadIds[id] = true
+		}
+	}
+	var keys = make([]int, 0, len(leadIds))
+	for id := range leadIds {
+		keys = append(keys, id)
 	}
-	var deletedLeadResults []map[string]interface{}
-	err = json.Unmarshal(*response, &deletedLeadResults)
+	sort.Ints(keys)
+	return keys, nil
+}
+
+// returns activities of given types since token, requesting at most marketoclient.MaxActivityTypeIDs types at once.
+func getActivities(client *marketoclient.Client, token string, activityTypeIDs []int) ([]map[string]interface{}, error) {
+	var activities []map[string]interface{}
+	for i := 0; i < len(activityTypeIDs); i += marketoclient.MaxActivityTypeIDs {
+		end := i + marketoclient.MaxActivityTypeIDs
+		if end > len(activityTypeIDs) {
+			end = len(activityTypeIDs)
+		}
+		moreResult := true
+		pageToken := token
+		for moreResult {
+			response, err := client.GetActivities(pageToken, activityTypeIDs[i:end])
+			if err != nil {
+				return nil, err
+			}
+			moreResult = response.MoreResult
+			pageToken = response.NextPageToken
+			if len(response.Result) == 0 {
+				continue
+			}
+			var page []map[string]interface{}
+			err = json.Unmarshal(response.Result, &page)
+			if err != nil {
+				return nil, err
+			}
+			activities = append(activities, page...)
+		}
+	}
+	return activities, nil
+}
+
+// holds the ids of leads merged by a Merge Leads activity.
+type leadMerge struct {
+	winner int   // id of the lead the others were merged into
+	losers []int // ids of the leads merged into the winner, which no longer exist
+}
+
+// returns lead merges since token, in the order they happened.
+func (c *CDCIterator) GetMergedLeads(ctx context.Context, token string) ([]leadMerge, error) {
+	activities, err := getActivities(c.client, token, []int{ActivityTypeIDMergeLeads})
 	if err != nil {
 		return nil, err
 	}
-	var leadIds = make([]int, 0)
-	for _, deletedLeadResult := range deletedLeadResults {
-		var id = int(deletedLeadResult["leadId"].(float64))
-		leadIds = append(leadIds, id)
+	var merges []leadMerge
+	for _, activity := range activities {
+		merge := leadMerge{winner: int(activity["leadId"].(float64))}
+		attributes, _ := activity["attributes"].([]interface{})
+		for _, a := range attributes {
+			attribute, ok := a.(map[string]interface{})
+			if !ok || !strings.EqualFold(fmt.Sprint(attribute["name"]), "Merge IDs") {
+				continue
+			}
+			ids, err := parseMergeIDs(attribute["value"])
+			if err != nil {
+				return nil, fmt.Errorf("error parsing merge ids of lead %d: %w", merge.winner, err)
+			}
+			for _, id := range ids {
+				if id != merge.winner {
+					merge.losers = append(merge.losers, id)
+				}
+			}
+		}
+		if len(merge.losers) > 0 {
+			merges = append(merges, merge)
+		}
 	}
-	return leadIds, nil
+	return merges, nil
+}
+
+// parses the value of the "Merge IDs" attribute, which is either a list of ids or its JSON encoding.
+func parseMergeIDs(value interface{}) ([]int, error) {
+	var ids []int
+	switch v := value.(type) {
+	case []interface{}:
+		for _, id := range v {
+			f, ok := id.(float64)
+			if !ok {
+				return nil, fmt.Errorf("unexpected merge id %v", id)
+			}
+			ids = append(ids, int(f))
+		}
+	case string:
+		if err := json.Unmarshal([]byte(v), &ids); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unexpected merge ids %v", value)
+	}
+	return ids, nil
+}
+
+// holds changed leads ids of a single page of lead changes.
+type leadChangesPage struct {
+	token   string              // paging token the page was requested with
+	leadIDs []int               // sorted ids of changed leads
+	changes map[int]*leadChange // changes of the poll by lead id, shared by all pages
+}
+
+// holds the changes of a lead collected from its activities.
+type leadChange struct {
+	activityTypeID int                    // type of the latest activity of the lead
+	fields         []string               // names of changed fields, in order of their first change
+	oldValues      map[string]interface{} // value of each changed field before its first change
+	newValues      map[string]interface{} // value of each changed field after its latest change
+	activityDate   string                 // date of the latest activity of the lead
+	newLead        bool                   // lead was created within the poll
+}
+
+// returns the lead id with the values of changed fields after their latest change and updatedAt.
+func (l *leadChange) after(key string) sdk.StructuredData {
+	after := sdk.StructuredData{"id": key, "updatedAt": l.activityDate}
+	for name, value := range l.newValues {
+		after[name] = value
+	}
+	return after
+}
+
+// returns the lead id with the values of changed fields before their first change.
+func (l *leadChange) before(key string) sdk.StructuredData {
+	before := sdk.StructuredData{"id": key}
+	for name, value := range l.oldValues {
+		before[name] = value
+	}
+	return before
 }
 
-// returns list of changed leads ids.
-func (c *CDCIterator) GetChangedLeadsIDs(ctx context.Context, token string) ([]int, map[int]int, error) {
-	var leadIds = make(map[int]int) // using map to avoid duplicates
+// adds the field changes of a Change Data Value activity.
+func (l *leadChange) add(activity map[string]interface{}) {
+	l.activityTypeID = int(activity["activityTypeId"].(float64))
+	if l.activityTypeID == ActivityTypeIDNewLead {
+		l.newLead = true
+	}
+	if date, ok := activity["activityDate"].(string); ok {
+		l.activityDate = date
+	}
+	fields, _ := activity["fields"].([]interface{})
+	for _, f := range fields {
+		field, ok := f.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, ok := field["name"].(string)
+		if !ok {
+			continue
+		}
+		if _, ok := l.oldValues[name]; !ok {
+			l.fields = append(l.fields, name)
+			l.oldValues[name] = field["oldValue"]
+		}
+		l.newValues[name] = field["newValue"]
+	}
+}
+
+// returns pages of changed leads ids and the paging token to continue polling from.
+func (c *CDCIterator) GetChangedLeadsIDs(ctx context.Context, token string) ([]leadChangesPage, string, error) {
+	var pages []leadChangesPage
+	var changes = make(map[int]*leadChange) // using map to avoid duplicates
+	var lastPage = make(map[int]int)        // a lead changed on several pages is fetched only once, with the last page it appears on
 	moreResult := true
 	for moreResult {
 		response, err := c.client.GetLeadChanges(token, c.fields)
 		if err != nil {
-			return nil, nil, err
+			return nil, "", err
 		}
-		if len(response.Result) == 0 {
-			return nil, nil, nil
+		page := leadChangesPage{
+			token:   token,
+			changes: changes,
 		}
 		moreResult = response.MoreResult
 		token = response.NextPageToken
+		if len(response.Result) == 0 {
+			continue
+		}
 		var leadChangeResults []map[string]interface{}
 		err = json.Unmarshal(response.Result, &leadChangeResults)
 		if err != nil {
-			return nil, nil, err
+			return nil, "", err
 		}
 		for _, leadChangeResult := range leadChangeResults {
 			var activityTypeID = leadChangeResult["activityTypeId"].(float64)
 			if activityTypeID == ActivityTypeIDNewLead || activityTypeID == ActivityTypeIDChangeDataValue {
 				var id = int(leadChangeResult["leadId"].(float64))
-				leadIds[id] = int(activityTypeID)
+				if changes[id] == nil {
+					changes[id] = &leadChange{
+						oldValues: make(map[string]interface{}),
+						newValues: make(map[string]interface{}),
+					}
+				}
+				changes[id].add(leadChangeResult)
+				lastPage[id] = len(pages)
 			}
 		}
+		pages = append(pages, page)
 	}
-	keys := make([]int, 0, len(leadIds))
-	for k := range leadIds {
-		keys = append(keys, k)
+	for id, i := range lastPage {
+		pages[i].leadIDs = append(pages[i].leadIDs, id)
 	}
-	// sorting helps in choosing last processed lead which handles
-	// the case when there are multiple leads with same createdAt and updatedAt time.
-	sort.Ints(keys)
-	return keys, leadIds, nil
+	for i := range pages {
+		// sorting helps in choosing last processed lead which handles
+		// the case when there are multiple leads with same createdAt and updatedAt time.
+		sort.Ints(pages[i].leadIDs)
+	}
+	return pages, token, nil
 }
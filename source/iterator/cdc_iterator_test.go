@@ -0,0 +1,493 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+const (
+	testActivityPages    = 10  // pages of lead changes returned by the fake server
+	testActivitiesByPage = 300 // lead changes on each page
+	testLeadsPageSize    = 200 // leads returned on each page of filtered leads
+)
+
+var testFields = []string{"id", "createdAt", "updatedAt", "firstName", "lastName", "email"}
+
+// returns fake marketo serving testActivityPages pages of lead changes, one change per lead.
+func newLeadChangesServer(t *testing.T) *marketotest.Server {
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/activities/pagingtoken.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, nil, "page-0", false)
+	})
+	f.Handle("/rest/v1/activities.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []interface{}{}, "end", false)
+	})
+	f.Handle("/rest/v1/activities/leadchanges.json", func(w http.ResponseWriter, r *http.Request) {
+		var page int
+		if _, err := fmt.Sscanf(r.URL.Query().Get("nextPageToken"), "page-%d", &page); err != nil || page >= testActivityPages {
+			marketotest.WriteResult(w, []interface{}{}, "end", false)
+			return
+		}
+		var changes []map[string]interface{}
+		for i := 1; i <= testActivitiesByPage; i++ {
+			id := page*testActivitiesByPage + i
+			changes = append(changes, map[string]interface{}{
+				"leadId":         id,
+				"activityTypeId": ActivityTypeIDChangeDataValue,
+				"activityDate":   "2022-01-02T00:00:00Z",
+				"fields": []map[string]interface{}{{
+					"name":     "email",
+					"oldValue": fmt.Sprintf("old%d@example.com", id),
+					"newValue": fmt.Sprintf("lead%d@example.com", id),
+				}},
+			})
+		}
+		marketotest.WriteResult(w, changes, fmt.Sprintf("page-%d", page+1), page+1 < testActivityPages)
+	})
+	f.Handle("/rest/v1/activities/deletedleads.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []interface{}{}, "end", false)
+	})
+	f.Handle("/rest/v1/leads.json", func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.String()) > marketoclient.MaxURLLength {
+			t.Errorf("request URL length %d exceeds %d", len(r.URL.String()), marketoclient.MaxURLLength)
+		}
+		ids := strings.Split(r.URL.Query().Get("filterValues"), ",")
+		if len(ids) > marketoclient.MaxFilterValues {
+			t.Errorf("got %d filter values, expected at most %d", len(ids), marketoclient.MaxFilterValues)
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("nextPageToken"))
+		end := offset + testLeadsPageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var leads []map[string]interface{}
+		for _, v := range ids[offset:end] {
+			id, _ := strconv.Atoi(v)
+			leads = append(leads, map[string]interface{}{
+				"id":        id,
+				"createdAt": "2022-01-01T00:00:00Z",
+				"updatedAt": "2022-01-02T00:00:00Z",
+				"email":     fmt.Sprintf("lead%d@example.com", id),
+			})
+		}
+		marketotest.WriteResult(w, leads, strconv.Itoa(end), end < len(ids))
+	})
+	return f
+}
+
+// reads n records from the iterator.
+func readRecords(ctx context.Context, t *testing.T, it interface {
+	Next(ctx context.Context) (sdk.Record, error)
+}, n int) []sdk.Record {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var records []sdk.Record
+	for i := 0; i < n; i++ {
+		rec, err := it.Next(ctx)
+		if err != nil {
+			t.Fatalf("expected no error reading record %d, got %v", i, err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// reads n records from the iterator, acknowledging each one like the source does, so polls aren't held back.
+func readAckedRecords(ctx context.Context, t *testing.T, it changeIterator, n int) []sdk.Record {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var records []sdk.Record
+	for i := 0; i < n; i++ {
+		rec, err := it.Next(ctx)
+		if err != nil {
+			t.Fatalf("expected no error reading record %d, got %v", i, err)
+		}
+		p, err := position.ParseRecordPosition(rec.Position)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := it.Ack(ctx, p); err != nil {
+			t.Fatalf("expected no error acknowledging record %d, got %v", i, err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestCDCIterator_ChunkedFilterLeads(t *testing.T) {
+	ctx := context.Background()
+	f := newLeadChangesServer(t)
+	client := *f.Client(t)
+	it, err := NewCDCIterator(ctx, &client, 10*time.Millisecond, testFields, time.Now(), position.Position{}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	total := testActivityPages * testActivitiesByPage
+	records := readRecords(ctx, t, it, total)
+	for i, rec := range records {
+		want := strconv.Itoa(i + 1)
+		if got := string(rec.Key.Bytes()); got != want {
+			t.Fatalf("expected record %d to have key %s, got %s", i, want, got)
+		}
+		if rec.Operation != sdk.OperationUpdate {
+			t.Errorf("expected operation %v, got %v", sdk.OperationUpdate, rec.Operation)
+		}
+		wantBefore := sdk.StructuredData{"id": want, "email": fmt.Sprintf("old%s@example.com", want)}
+		if before, ok := rec.Payload.Before.(sdk.StructuredData); !ok || !reflect.DeepEqual(before, wantBefore) {
+			t.Errorf("expected before data %v, got %v", wantBefore, rec.Payload.Before)
+		}
+		if got := rec.Metadata[MetadataChangedFields]; got != "email" {
+			t.Errorf("expected changed fields %q, got %q", "email", got)
+		}
+		p, err := position.ParseRecordPosition(rec.Position)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wantToken := fmt.Sprintf("page-%d", i/testActivitiesByPage); p.PageToken != wantToken || p.Key != want {
+			t.Errorf("expected position %s/%s, got %s/%s", wantToken, want, p.PageToken, p.Key)
+		}
+	}
+	if calls, min := len(f.RequestsTo("/rest/v1/leads.json")), total/testLeadsPageSize; calls < min {
+		t.Errorf("expected at least %d filter calls, got %d", min, calls)
+	}
+}
+
+func TestCDCIterator_ResumeFromPosition(t *testing.T) {
+	ctx := context.Background()
+	f := newLeadChangesServer(t)
+	client := *f.Client(t)
+	p := position.Position{
+		Type:      position.TypeCDC,
+		PageToken: "page-7",
+		Key:       strconv.Itoa(7*testActivitiesByPage + 100),
+	}
+	it, err := NewCDCIterator(ctx, &client, 10*time.Millisecond, testFields, time.Now(), p, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	remaining := (testActivityPages-7)*testActivitiesByPage - 100
+	records := readRecords(ctx, t, it, remaining)
+	if got, want := string(records[0].Key.Bytes()), strconv.Itoa(7*testActivitiesByPage+101); got != want {
+		t.Errorf("expected first record key %s, got %s", want, got)
+	}
+	if got, want := string(records[remaining-1].Key.Bytes()), strconv.Itoa(testActivityPages*testActivitiesByPage); got != want {
+		t.Errorf("expected last record key %s, got %s", want, got)
+	}
+	if len(f.RequestsTo("/rest/v1/activities/pagingtoken.json")) != 0 {
+		t.Error("expected no paging token request when resuming from a position")
+	}
+}
+
+func TestCDCIterator_AdvancesOnlyOnAck(t *testing.T) {
+	ctx := context.Background()
+	f := newLeadChangesServer(t)
+	client := *f.Client(t)
+	it, err := NewCDCIterator(ctx, &client, 10*time.Millisecond, testFields, time.Now(), position.Position{}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readRecords(ctx, t, it, testActivityPages*testActivitiesByPage)
+	time.Sleep(100 * time.Millisecond)
+	if got := len(f.RequestsTo("/rest/v1/activities/leadchanges.json")); got != testActivityPages {
+		t.Fatalf("expected no poll before records are acknowledged, got %d lead changes requests", got)
+	}
+
+	for _, rec := range records {
+		p, err := position.ParseRecordPosition(rec.Position)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := it.Ack(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.RequestsTo("/rest/v1/activities/leadchanges.json")) == testActivityPages {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a new poll after all records were acknowledged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	requests := f.RequestsTo("/rest/v1/activities/leadchanges.json")
+	want := fmt.Sprintf("page-%d", testActivityPages)
+	if got := requests[testActivityPages].URL.Query().Get("nextPageToken"); got != want {
+		t.Errorf("expected next poll to start from token %q, got %q", want, got)
+	}
+}
+
+func TestCDCIterator_PartialUpdates(t *testing.T) {
+	ctx := context.Background()
+	f := newLeadChangesServer(t)
+	client := *f.Client(t)
+	it, err := NewCDCIterator(ctx, &client, 10*time.Millisecond, testFields, time.Now(), position.Position{}, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readRecords(ctx, t, it, testActivityPages*testActivitiesByPage)
+	if calls := len(f.RequestsTo("/rest/v1/leads.json")); calls != 0 {
+		t.Errorf("expected no filter calls in partial mode, got %d", calls)
+	}
+	rec := records[0]
+	if rec.Operation != sdk.OperationUpdate || rec.Metadata[MetadataPartial] != "true" {
+		t.Errorf("expected partial update record, got %v with metadata %v", rec.Operation, rec.Metadata)
+	}
+	wantAfter := sdk.StructuredData{"id": "1", "email": "lead1@example.com", "updatedAt": "2022-01-02T00:00:00Z"}
+	if !reflect.DeepEqual(rec.Payload.After, wantAfter) {
+		t.Errorf("expected after data %v, got %v", wantAfter, rec.Payload.After)
+	}
+}
+
+func TestCDCIterator_ConfiguredActivityTypes(t *testing.T) {
+	ctx := context.Background()
+	f := newLeadChangesServer(t)
+	f.Handle("/rest/v1/activities.json", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("activityTypeIds"); got != "22,104" {
+			if got != strconv.Itoa(ActivityTypeIDMergeLeads) {
+				t.Errorf("expected activity type ids %q, got %q", "22,104", got)
+			}
+			marketotest.WriteResult(w, []interface{}{}, "end", false)
+			return
+		}
+		if r.URL.Query().Get("nextPageToken") != "page-0" {
+			marketotest.WriteResult(w, []interface{}{}, "end", false)
+			return
+		}
+		marketotest.WriteResult(w, []map[string]interface{}{
+			{"leadId": 1, "activityTypeId": 22},       // also changed, refreshed with its change
+			{"leadId": 999999, "activityTypeId": 104}, // refreshed
+		}, "end", false)
+	})
+	client := *f.Client(t)
+	it, err := NewCDCIterator(ctx, &client, 10*time.Millisecond, testFields, time.Now(), position.Position{}, false, []int{22, 104})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readRecords(ctx, t, it, testActivityPages*testActivitiesByPage+1)
+	if got := string(records[0].Key.Bytes()); got != "999999" {
+		t.Errorf("expected refreshed lead first, got key %s", got)
+	}
+	if got := string(records[1].Key.Bytes()); got != "1" {
+		t.Errorf("expected changed leads after refreshed leads, got key %s", got)
+	}
+}
+
+func TestCDCIterator_MergedLeads(t *testing.T) {
+	ctx := context.Background()
+	f := newLeadChangesServer(t)
+	f.Handle("/rest/v1/activities.json", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("nextPageToken") != "page-0" {
+			marketotest.WriteResult(w, []interface{}{}, "end", false)
+			return
+		}
+		marketotest.WriteResult(w, []map[string]interface{}{{
+			"leadId":         500000,
+			"activityTypeId": ActivityTypeIDMergeLeads,
+			"attributes": []map[string]interface{}{
+				{"name": "Merge IDs", "value": "[500000,500001,500002]"},
+				{"name": "Master Updated", "value": true},
+			},
+		}}, "end", false)
+	})
+	f.Handle("/rest/v1/activities/deletedleads.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []map[string]interface{}{{"leadId": 500001}}, "end", false)
+	})
+	client := *f.Client(t)
+	it, err := NewCDCIterator(ctx, &client, 10*time.Millisecond, testFields, time.Now(), position.Position{}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readRecords(ctx, t, it, 3)
+	for i, want := range []string{"500001", "500002"} {
+		rec := records[i]
+		if rec.Operation != sdk.OperationDelete || string(rec.Key.Bytes()) != want || rec.Metadata[MetadataMergedInto] != "500000" {
+			t.Errorf("expected delete of %s merged into 500000, got %v of %s with metadata %v", want, rec.Operation, rec.Key.Bytes(), rec.Metadata)
+		}
+	}
+	winner := records[2]
+	if winner.Operation != sdk.OperationUpdate || string(winner.Key.Bytes()) != "500000" || winner.Metadata[MetadataMergedFrom] != "500001,500002" {
+		t.Errorf("expected update of 500000 merged from 500001,500002, got %v of %s with metadata %v", winner.Operation, winner.Key.Bytes(), winner.Metadata)
+	}
+}
+
+func TestCDCIterator_MergedChangedLeads(t *testing.T) {
+	ctx := context.Background()
+	f := newLeadChangesServer(t)
+	// leads 1 and 2 are also on the first page of lead changes.
+	f.Handle("/rest/v1/activities.json", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("nextPageToken") != "page-0" {
+			marketotest.WriteResult(w, []interface{}{}, "end", false)
+			return
+		}
+		marketotest.WriteResult(w, []map[string]interface{}{{
+			"leadId":         1,
+			"activityTypeId": ActivityTypeIDMergeLeads,
+			"attributes": []map[string]interface{}{
+				{"name": "Merge IDs", "value": "[1,2]"},
+			},
+		}}, "end", false)
+	})
+	client := *f.Client(t)
+	it, err := NewCDCIterator(ctx, &client, 10*time.Millisecond, testFields, time.Now(), position.Position{}, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readRecords(ctx, t, it, testActivityPages*testActivitiesByPage)
+	loser, winner := records[0], records[1]
+	if loser.Operation != sdk.OperationDelete || string(loser.Key.Bytes()) != "2" || loser.Metadata[MetadataMergedInto] != "1" {
+		t.Errorf("expected delete of 2 merged into 1, got %v of %s with metadata %v", loser.Operation, loser.Key.Bytes(), loser.Metadata)
+	}
+	if winner.Operation != sdk.OperationUpdate || string(winner.Key.Bytes()) != "1" || winner.Metadata[MetadataPartial] == "true" {
+		t.Errorf("expected full update of 1, got %v of %s with metadata %v", winner.Operation, winner.Key.Bytes(), winner.Metadata)
+	}
+	for i, rec := range records[2:] {
+		if key := string(rec.Key.Bytes()); key == "1" || key == "2" {
+			t.Errorf("expected merged lead %s to be emitted by its merge only, got record %d %v", key, i+2, rec.Operation)
+		}
+	}
+	if got := string(records[2].Key.Bytes()); got != "3" {
+		t.Errorf("expected changed leads to continue with 3, got %s", got)
+	}
+}
+
+func TestCDCIterator_BatchedActivityTypes(t *testing.T) {
+	ctx := context.Background()
+	f := newLeadChangesServer(t)
+	var activityTypeIDs []int
+	for id := 101; id <= 112; id++ {
+		activityTypeIDs = append(activityTypeIDs, id)
+	}
+	f.Handle("/rest/v1/activities.json", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("nextPageToken") != "page-0" {
+			marketotest.WriteResult(w, []interface{}{}, "end", false)
+			return
+		}
+		switch r.URL.Query().Get("activityTypeIds") {
+		case "101,102,103,104,105,106,107,108,109,110":
+			marketotest.WriteResult(w, []map[string]interface{}{{"leadId": 999998, "activityTypeId": 101}}, "end", false)
+		case "111,112":
+			marketotest.WriteResult(w, []map[string]interface{}{{"leadId": 999999, "activityTypeId": 112}}, "end", false)
+		default:
+			marketotest.WriteResult(w, []interface{}{}, "end", false)
+		}
+	})
+	client := *f.Client(t)
+	it, err := NewCDCIterator(ctx, &client, 10*time.Millisecond, testFields, time.Now(), position.Position{}, false, activityTypeIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readRecords(ctx, t, it, 2)
+	for i, want := range []string{"999998", "999999"} {
+		if got := string(records[i].Key.Bytes()); got != want {
+			t.Errorf("expected refreshed lead %d to be %s, got %s", i, want, got)
+		}
+	}
+	var got []string
+	for _, r := range f.RequestsTo("/rest/v1/activities.json") {
+		ids := r.URL.Query().Get("activityTypeIds")
+		if r.URL.Query().Get("nextPageToken") == "page-0" && ids != strconv.Itoa(ActivityTypeIDMergeLeads) {
+			got = append(got, ids)
+		}
+	}
+	want := []string{"101,102,103,104,105,106,107,108,109,110", "111,112"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected activity type ids %q, got %q", want, got)
+	}
+}
+
+// returns fake marketo serving the activity types of the instance.
+func newActivityTypesServer(t *testing.T) *marketotest.Server {
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/activities/types.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []map[string]interface{}{
+			{"id": 1, "name": "Visit Webpage"},
+			{"id": 22, "name": "Change Score"},
+		}, "", false)
+	})
+	return f
+}
+
+func TestResolveActivityTypeIDs(t *testing.T) {
+	tests := []struct {
+		name          string
+		activityTypes []string
+		want          []int
+		typesCalls    int
+	}{
+		{
+			name:          "ids only",
+			activityTypes: []string{"22", "104"},
+			want:          []int{22, 104},
+			typesCalls:    0,
+		},
+		{
+			name:          "names and ids",
+			activityTypes: []string{"Visit Webpage", "104", "change score"},
+			want:          []int{1, 104, 22},
+			typesCalls:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newActivityTypesServer(t)
+			got, err := f.Client(t).ResolveActivityTypeIDs(tt.activityTypes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected ids %v, got %v", tt.want, got)
+			}
+			if calls := len(f.RequestsTo("/rest/v1/activities/types.json")); calls != tt.typesCalls {
+				t.Errorf("expected %d activity types calls, got %d", tt.typesCalls, calls)
+			}
+		})
+	}
+}
+
+func TestResolveActivityTypeIDs_UnknownName(t *testing.T) {
+	f := newActivityTypesServer(t)
+	_, err := f.Client(t).ResolveActivityTypeIDs([]string{"Change Score", "Fill Out Form"})
+	if err == nil || !strings.Contains(err.Error(), `unknown activity type "Fill Out Form"`) {
+		t.Errorf("expected unknown activity type error, got %v", err)
+	}
+}
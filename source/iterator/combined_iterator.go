@@ -18,6 +18,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
+	"sync"
 	"time"
 
 	sdk "github.com/conduitio/conduit-connector-sdk"
@@ -26,62 +28,250 @@ import (
 )
 
 type CombinedIterator struct {
+	mu               sync.Mutex // guards the iterators and pendingSnapshot, since Ack runs concurrently with Next
 	snapshotIterator *SnapshotIterator
-	cdcIterator      *CDCIterator
+	cdcIterator      changeIterator
+	pendingSnapshot  int // snapshot records read and not acknowledged yet
 
-	endpoint      string
-	pollingPeriod time.Duration
-	fields        []string
-	client        marketoclient.Client
+	// creates the iterator following the snapshot, reading changes since lastModified from the given position.
+	newCDCIterator func(ctx context.Context, lastModified time.Time, p position.Position) (changeIterator, error)
+}
+
+// changeIterator is implemented by the iterators polling the changes of an object, like CDCIterator for leads.
+type changeIterator interface {
+	HasNext(ctx context.Context) bool
+	Next(ctx context.Context) (sdk.Record, error)
+	Ack(ctx context.Context, p position.Position) error
+	Stop()
 }
 
 var ErrDone = errors.New("no more records in iterator")
 
-func NewCombinedIterator(ctx context.Context, endpoint string, pollingPeriod time.Duration, client marketoclient.Client, p position.Position, fields []string, initialDate time.Time) (*CombinedIterator, error) {
+func NewCombinedIterator(ctx context.Context, endpoint string, pollingPeriod time.Duration, client marketoclient.Client, p position.Position, fields []string, initialDate time.Time, partial bool, activityTypes []int) (*CombinedIterator, error) {
 	logger := sdk.Logger(ctx).With().Str("Method", "NewCombinedIterator").Logger()
 	logger.Trace().Msg("Starting the NewCombinedIterator")
 
-	var err error
 	c := &CombinedIterator{
-		endpoint:      endpoint,
-		pollingPeriod: pollingPeriod,
-		client:        client,
-		fields:        fields,
+		newCDCIterator: func(ctx context.Context, lastModified time.Time, p position.Position) (changeIterator, error) {
+			return NewCDCIterator(ctx, &client, pollingPeriod, fields, lastModified, p, partial, activityTypes)
+		},
+	}
+	err := c.start(ctx, p, func() (*SnapshotIterator, error) {
+		return NewSnapshotIterator(ctx, endpoint, fields, client, p, initialDate)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// returns NewCombinedActivityIterator which reads activities of the given types, or of all types if none are given.
+// If initialDate is set, activities since then are exported in bulk first, then the activity stream is polled.
+// Otherwise the activity stream is polled from now on.
+func NewCombinedActivityIterator(ctx context.Context, endpoint string, pollingPeriod time.Duration, client marketoclient.Client, p position.Position, initialDate time.Time, activityTypeIDs []int) (*CombinedIterator, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "NewCombinedActivityIterator").Logger()
+	logger.Trace().Msg("Starting the NewCombinedActivityIterator")
+
+	activities, err := GetActivityTypes(&client, activityTypeIDs)
+	if err != nil {
+		return nil, err
+	}
+	c := &CombinedIterator{
+		newCDCIterator: func(ctx context.Context, lastModified time.Time, p position.Position) (changeIterator, error) {
+			return NewActivityIterator(ctx, &client, pollingPeriod, activities, lastModified, p)
+		},
+	}
+	if p.Type == position.TypeSnapshot && initialDate.IsZero() && reflect.ValueOf(p).IsZero() {
+		// nothing to backfill, poll the activity stream from now on.
+		c.cdcIterator, err = c.newCDCIterator(ctx, time.Now(), p)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error while creating a new activity iterator")
+			return nil, err
+		}
+		return c, nil
+	}
+	err = c.start(ctx, p, func() (*SnapshotIterator, error) {
+		return NewActivitySnapshotIterator(ctx, endpoint, client, p, initialDate, activityTypeIDs, activities)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// returns NewCombinedProgramMemberIterator which exports the members of the given programs, then polls their
+// membership changes.
+func NewCombinedProgramMemberIterator(ctx context.Context, endpoint string, pollingPeriod time.Duration, client marketoclient.Client, p position.Position, fields []string, programIDs []int) (*CombinedIterator, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "NewCombinedProgramMemberIterator").Logger()
+	logger.Trace().Msg("Starting the NewCombinedProgramMemberIterator")
+
+	c := &CombinedIterator{
+		newCDCIterator: func(ctx context.Context, lastModified time.Time, p position.Position) (changeIterator, error) {
+			return NewProgramMemberIterator(ctx, &client, pollingPeriod, fields, programIDs, lastModified, p)
+		},
+	}
+	err := c.start(ctx, p, func() (*SnapshotIterator, error) {
+		return NewProgramMemberSnapshotIterator(ctx, endpoint, fields, client, p, programIDs)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return c, nil
+}
 
+// returns NewCombinedCustomObjectIterator which exports the records of the custom object with given API name updated
+// since initialDate, then polls the records updated since. fields are exported in addition to the key fields,
+// createdAt and updatedAt, all fields are exported if none are given.
+func NewCombinedCustomObjectIterator(ctx context.Context, endpoint string, exportPeriod time.Duration, client marketoclient.Client, p position.Position, fields []string, initialDate time.Time, apiName string) (*CombinedIterator, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "NewCombinedCustomObjectIterator").Logger()
+	logger.Trace().Msg("Starting the NewCombinedCustomObjectIterator")
+
+	customObject, err := client.DescribeCustomObject(apiName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe custom object %q: %w", apiName, err)
+	}
+	fields = customObjectFields(customObject, fields)
+	c := &CombinedIterator{
+		newCDCIterator: func(ctx context.Context, lastModified time.Time, p position.Position) (changeIterator, error) {
+			return NewCustomObjectIterator(ctx, endpoint, &client, exportPeriod, fields, customObject, lastModified)
+		},
+	}
+	err = c.start(ctx, p, func() (*SnapshotIterator, error) {
+		return NewCustomObjectSnapshotIterator(ctx, endpoint, fields, client, p, initialDate, customObject)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// returns NewCombinedQueryIterator which reads the records of given object, like
+// marketoclient.QueryObjectOpportunities, matching the filter values. The records matching the filter are read first,
+// then the records updated since are polled, see QueryIterator. fields are read in addition to marketoGUID,
+// createdAt and updatedAt, all fields are read if none are given.
+func NewCombinedQueryIterator(ctx context.Context, pollingPeriod time.Duration, client marketoclient.Client, p position.Position, object string, filterType string, filterValues []string, fields []string) (*CombinedIterator, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "NewCombinedQueryIterator").Logger()
+	logger.Trace().Msg("Starting the NewCombinedQueryIterator")
+
+	if p.Type != position.TypeSnapshot && p.Type != position.TypeCDC {
+		return nil, fmt.Errorf("invalid position type (%d)", p.Type)
+	}
+	description, err := client.DescribeObject(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe %s: %w", object, err)
+	}
+	fields = objectFields(description, []string{"marketoGUID"}, fields)
+	c := &CombinedIterator{}
+	c.cdcIterator, err = NewQueryIterator(ctx, &client, pollingPeriod, object, filterType, filterValues, fields, p)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error while creating a new query iterator")
+		return nil, err
+	}
+	return c, nil
+}
+
+// returns NewCombinedRefreshIterator which reads the records of given object, like marketoclient.QueryObjectCompanies,
+// matching the filter values, detecting changes by comparing the records of successive polls, see RefreshIterator.
+// Records are keyed by the id field of the object. fields are read in addition to the id field, createdAt and
+// updatedAt, all fields are read if none are given.
+func NewCombinedRefreshIterator(ctx context.Context, pollingPeriod time.Duration, refreshPeriod time.Duration, client marketoclient.Client, p position.Position, object string, filterType string, filterValues []string, fields []string) (*CombinedIterator, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "NewCombinedRefreshIterator").Logger()
+	logger.Trace().Msg("Starting the NewCombinedRefreshIterator")
+
+	if p.Type != position.TypeSnapshot && p.Type != position.TypeCDC {
+		return nil, fmt.Errorf("invalid position type (%d)", p.Type)
+	}
+	description, err := client.DescribeObject(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe %s: %w", object, err)
+	}
+	fields = objectFields(description, []string{description.IDField}, fields)
+	c := &CombinedIterator{}
+	c.cdcIterator, err = NewRefreshIterator(ctx, &client, pollingPeriod, refreshPeriod, object, description.IDField, filterType, filterValues, fields, p)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error while creating a new refresh iterator")
+		return nil, err
+	}
+	return c, nil
+}
+
+// returns NewCombinedListMemberIterator which reads the members of the given static lists, then polls their adds
+// and removes, see ListMemberIterator.
+func NewCombinedListMemberIterator(ctx context.Context, pollingPeriod time.Duration, client marketoclient.Client, p position.Position, listIDs []int) (*CombinedIterator, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "NewCombinedListMemberIterator").Logger()
+	logger.Trace().Msg("Starting the NewCombinedListMemberIterator")
+
+	var err error
+	c := &CombinedIterator{}
+	c.cdcIterator, err = NewListMemberIterator(ctx, &client, pollingPeriod, listIDs, p)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error while creating a new list member iterator")
+		return nil, err
+	}
+	return c, nil
+}
+
+// returns NewCombinedAssetIterator which reads the assets of the given type, like marketoclient.AssetPrograms, then
+// polls the assets updated since and fully refreshes them every refreshPeriod, see QueryIterator.
+func NewCombinedAssetIterator(ctx context.Context, pollingPeriod time.Duration, refreshPeriod time.Duration, client marketoclient.Client, p position.Position, asset string) (*CombinedIterator, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "NewCombinedAssetIterator").Logger()
+	logger.Trace().Msg("Starting the NewCombinedAssetIterator")
+
+	if p.Type != position.TypeSnapshot && p.Type != position.TypeCDC {
+		return nil, fmt.Errorf("invalid position type (%d)", p.Type)
+	}
+	keyField := "id"
+	if asset == marketoclient.AssetTagTypes {
+		keyField = "tagType" // tag types have no id
+	}
+	var err error
+	c := &CombinedIterator{}
+	c.cdcIterator, err = NewAssetIterator(ctx, &client, pollingPeriod, refreshPeriod, asset, keyField, p)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error while creating a new asset iterator")
+		return nil, err
+	}
+	return c, nil
+}
+
+// starts the snapshot iterator or the CDC iterator, depending on the position type.
+func (c *CombinedIterator) start(ctx context.Context, p position.Position, newSnapshotIterator func() (*SnapshotIterator, error)) error {
+	logger := sdk.Logger(ctx).With().Str("Method", "start").Logger()
+
+	var err error
 	switch p.Type {
 	case position.TypeSnapshot:
 		logger.Trace().Msg("Starting creating a New Snaphot iterator")
 
-		c.snapshotIterator, err = NewSnapshotIterator(ctx, endpoint, fields, client, p, initialDate)
+		c.snapshotIterator, err = newSnapshotIterator()
 		if err != nil {
 			logger.Error().Err(err).Msg("Error while creating a new snapshot iterator")
-			return nil, err
+			return err
 		}
 
 		logger.Trace().Msg("Sucessfully created the New Snaphot iterator")
 	case position.TypeCDC:
 		logger.Trace().Msg("Starting creating a New CDC iterator")
 
-		c.cdcIterator, err = NewCDCIterator(ctx, &client, pollingPeriod, fields, p.UpdatedAt, p.Key)
+		c.cdcIterator, err = c.newCDCIterator(ctx, p.UpdatedAt, p)
 		if err != nil {
 			logger.Error().Err(err).Msg("Error while creating a new CDC iterator")
-			return nil, err
+			return err
 		}
 
 	default:
 		// this case should never happen
-		return nil, fmt.Errorf("invalid position type (%d)", p.Type)
+		return fmt.Errorf("invalid position type (%d)", p.Type)
 	}
-
-	return c, nil
+	return nil
 }
 
 func (c *CombinedIterator) HasNext(ctx context.Context) bool {
+	snapshotIterator, cdcIterator := c.iterators()
 	switch {
-	case c.snapshotIterator != nil:
+	case snapshotIterator != nil:
 		// case of empty database or end of database
-		if !c.snapshotIterator.HasNext(ctx) {
+		if !snapshotIterator.HasNext(ctx) {
 			sdk.Logger(ctx).Info().Msg("Switching to CDC iterator...")
 			err := c.switchToCDCIterator(ctx, "") // empty string no last key, so process all records
 			if err != nil {
@@ -91,8 +281,8 @@ func (c *CombinedIterator) HasNext(ctx context.Context) bool {
 			return false
 		}
 		return true
-	case c.cdcIterator != nil:
-		return c.cdcIterator.HasNext(ctx)
+	case cdcIterator != nil:
+		return cdcIterator.HasNext(ctx)
 	default:
 		return false
 	}
@@ -102,13 +292,17 @@ func (c *CombinedIterator) Next(ctx context.Context) (sdk.Record, error) {
 	logger := sdk.Logger(ctx).With().Str("Method", "Next").Logger()
 	logger.Trace().Msg("Starting the Combined Iterator Next")
 
+	snapshotIterator, cdcIterator := c.iterators()
 	switch {
-	case c.snapshotIterator != nil:
-		record, err := c.snapshotIterator.Next(ctx)
+	case snapshotIterator != nil:
+		record, err := snapshotIterator.Next(ctx)
 		if err != nil {
 			return sdk.Record{}, err
 		}
-		if !c.snapshotIterator.HasNext(ctx) {
+		c.mu.Lock()
+		c.pendingSnapshot++
+		c.mu.Unlock()
+		if !snapshotIterator.HasNext(ctx) {
 			logger.Info().Msg("Switching to CDC iterator...")
 			err := c.switchToCDCIterator(ctx, string(record.Key.Bytes()))
 			if err != nil {
@@ -121,30 +315,59 @@ func (c *CombinedIterator) Next(ctx context.Context) (sdk.Record, error) {
 		}
 		return record, nil
 
-	case c.cdcIterator != nil:
-		return c.cdcIterator.Next(ctx)
+	case cdcIterator != nil:
+		return cdcIterator.Next(ctx)
 	default:
 		logger.Error().Msg("Both the itertors are not initailsed")
 		return sdk.Record{}, errors.New("no initialized iterator")
 	}
 }
 
+// Ack forwards the acknowledged position to the CDC iterator. Snapshot records need no acknowledgment handling,
+// since the snapshot restarts from the position Conduit passes to Open. Acks come in the order records were read,
+// so the acks of the snapshot records come before the acks of the CDC records.
+func (c *CombinedIterator) Ack(ctx context.Context, p position.Position) error {
+	c.mu.Lock()
+	if c.pendingSnapshot > 0 {
+		c.pendingSnapshot--
+		c.mu.Unlock()
+		return nil
+	}
+	cdcIterator := c.cdcIterator
+	c.mu.Unlock()
+	if cdcIterator == nil {
+		return nil
+	}
+	return cdcIterator.Ack(ctx, p)
+}
+
 func (c *CombinedIterator) Stop() {
-	if c.cdcIterator != nil {
-		c.cdcIterator.Stop()
+	_, cdcIterator := c.iterators()
+	if cdcIterator != nil {
+		cdcIterator.Stop()
 	}
 }
 
+// returns the snapshot iterator and the CDC iterator, nil if not started or done.
+func (c *CombinedIterator) iterators() (*SnapshotIterator, changeIterator) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.snapshotIterator, c.cdcIterator
+}
+
 func (c *CombinedIterator) switchToCDCIterator(ctx context.Context, fromKey string) error {
-	lastModifiedTime := c.snapshotIterator.lastMaxModified
+	snapshotIterator, _ := c.iterators()
+	lastModifiedTime := snapshotIterator.lastMaxModified
 	if lastModifiedTime.IsZero() {
 		lastModifiedTime = time.Now().UTC()
 	}
-	var err error
-	c.cdcIterator, err = NewCDCIterator(ctx, &c.client, c.pollingPeriod, c.fields, lastModifiedTime, fromKey)
+	cdcIterator, err := c.newCDCIterator(ctx, lastModifiedTime, position.Position{Key: fromKey})
 	if err != nil {
 		return fmt.Errorf("could not create cdc iterator: %w", err)
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cdcIterator = cdcIterator
 	c.snapshotIterator = nil
 	return nil
 }
@@ -0,0 +1,103 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+// changeIterator counting the acks it receives.
+type fakeChangeIterator struct {
+	mu   sync.Mutex
+	acks []position.Position
+}
+
+func (f *fakeChangeIterator) HasNext(ctx context.Context) bool { return false }
+
+func (f *fakeChangeIterator) Next(ctx context.Context) (sdk.Record, error) {
+	return sdk.Record{}, ErrDone
+}
+
+func (f *fakeChangeIterator) Ack(ctx context.Context, p position.Position) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.acks = append(f.acks, p)
+	return nil
+}
+
+func (f *fakeChangeIterator) Stop() {}
+
+func (f *fakeChangeIterator) ackCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.acks)
+}
+
+// returns a snapshot iterator which has no more records.
+func doneSnapshotIterator() *SnapshotIterator {
+	hasData := make(chan struct{})
+	close(hasData)
+	return &SnapshotIterator{hasData: hasData, data: make(chan exportRow)}
+}
+
+func TestCombinedIterator_AckRoutesSnapshotRecords(t *testing.T) {
+	ctx := context.Background()
+	cdc := &fakeChangeIterator{}
+	c := &CombinedIterator{cdcIterator: cdc, pendingSnapshot: 2}
+
+	for i := 0; i < 3; i++ {
+		err := c.Ack(ctx, position.Position{Type: position.TypeCDC, Key: "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cdc.ackCount() != 1 {
+		t.Errorf("expected the acks of the snapshot records kept from the CDC iterator, got %d acks", cdc.ackCount())
+	}
+}
+
+func TestCombinedIterator_AckDuringSwitch(t *testing.T) {
+	ctx := context.Background()
+	cdc := &fakeChangeIterator{}
+	c := &CombinedIterator{
+		snapshotIterator: doneSnapshotIterator(),
+		newCDCIterator: func(ctx context.Context, lastModified time.Time, p position.Position) (changeIterator, error) {
+			return cdc, nil
+		},
+	}
+
+	// run with -race: the switch to the CDC iterator races with the acks unless the iterators are guarded.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_ = c.Ack(ctx, position.Position{Type: position.TypeCDC, Key: "1"})
+		}
+	}()
+	if c.HasNext(ctx) {
+		t.Errorf("expected no record once the snapshot is done")
+	}
+	wg.Wait()
+	if _, cdcIterator := c.iterators(); cdcIterator != cdc {
+		t.Errorf("expected the CDC iterator started once the snapshot is done")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+// CustomObjectIterator polls the records of a custom object updated since the last poll. Marketo can't filter
+// custom objects by updatedAt through the REST API, so every poll runs bulk exports of the records updated since
+// the last one, see NewCustomObjectSnapshotIterator.
+type CustomObjectIterator struct {
+	*poller
+	client       *marketoclient.Client           // marketo client
+	endpoint     string                          // endpoint of the marketo instance
+	fields       []string                        // fields to fetch from marketo
+	customObject marketoclient.ObjectDescription // custom object to read
+	lastModified time.Time                       // updatedAt of the latest record read
+}
+
+// returns NewCustomObjectIterator which exports the records of the custom object updated after lastModifiedTime
+// every exportPeriod. Every export creates a bulk export job, which count against the daily export quota, so the
+// export period is usually much longer than the polling period of the other objects.
+func NewCustomObjectIterator(ctx context.Context, endpoint string, client *marketoclient.Client, exportPeriod time.Duration, fields []string, customObject marketoclient.ObjectDescription, lastModifiedTime time.Time) (*CustomObjectIterator, error) {
+	iterator := &CustomObjectIterator{
+		client:       client,
+		endpoint:     endpoint,
+		fields:       fields,
+		customObject: customObject,
+		lastModified: lastModifiedTime.UTC(),
+	}
+	iterator.poller = newPoller(ctx, "custom object", exportPeriod, false, iterator.flushLatestRecords)
+	return iterator, nil
+}
+
+// exports the records updated since the latest record read and pushes them to the buffer, as create records if
+// they were created by the update and as update records otherwise.
+func (c *CustomObjectIterator) flushLatestRecords(ctx context.Context, push func(sdk.Record) error) (func(), error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "flushLatestRecords").Logger()
+	logger.Trace().Msg("Starting the flushLatestRecords")
+
+	// marketo handles updatedAt at seconds level, records of the latest second were already read.
+	export, err := NewCustomObjectSnapshotIterator(ctx, c.endpoint, c.fields, *c.client, position.Position{}, c.lastModified.Add(time.Second), c.customObject)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error while exporting the updated records")
+		return nil, fmt.Errorf("error exporting updated records %w", err)
+	}
+	for export.HasNext(ctx) {
+		record, err := export.Next(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error exporting updated records %w", err)
+		}
+		record, err = c.changeRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		err = push(record)
+		if err != nil {
+			return nil, err
+		}
+	}
+	<-export.done
+	select {
+	case err := <-export.errChan:
+		return nil, fmt.Errorf("error exporting updated records %w", err)
+	default:
+	}
+	return func() {
+		if export.lastMaxModified.After(c.lastModified) {
+			c.lastModified = export.lastMaxModified
+		}
+	}, nil
+}
+
+// converts an exported snapshot record to a change record with a CDC position.
+func (c *CustomObjectIterator) changeRecord(record sdk.Record) (sdk.Record, error) {
+	pos, err := position.ConvertToCDCPosition(record.Position)
+	if err != nil {
+		return sdk.Record{}, err
+	}
+	data := record.Payload.After.(sdk.StructuredData)
+	if data["createdAt"] == data["updatedAt"] {
+		return sdk.Util.Source.NewRecordCreate(pos, record.Metadata, record.Key, data), nil
+	}
+	return sdk.Util.Source.NewRecordUpdate(pos, record.Metadata, record.Key, nil, data), nil
+}
+
+// returns the fields keying the records of the custom object, its dedupe fields or its id field if it has none.
+func customObjectKeyFields(customObject marketoclient.ObjectDescription) []string {
+	if len(customObject.DedupeFields) > 0 {
+		return customObject.DedupeFields
+	}
+	return []string{customObject.IDField}
+}
+
+// returns the fields to export of the custom object, all its fields if none are requested. The key fields,
+// createdAt and updatedAt are always exported.
+func customObjectFields(customObject marketoclient.ObjectDescription, requested []string) []string {
+	return objectFields(customObject, customObjectKeyFields(customObject), requested)
+}
+
+// returns the fields to read of the described object, all its fields if none are requested. The key fields,
+// createdAt and updatedAt are always read.
+func objectFields(object marketoclient.ObjectDescription, keyFields []string, requested []string) []string {
+	var fields []string
+	var seen = make(map[string]bool)
+	add := func(field string) {
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	if len(requested) == 0 {
+		for _, field := range object.Fields {
+			add(field.Name)
+		}
+		return fields
+	}
+	for _, field := range keyFields {
+		add(field)
+	}
+	add("createdAt")
+	add("updatedAt")
+	for _, field := range requested {
+		add(field)
+	}
+	return fields
+}
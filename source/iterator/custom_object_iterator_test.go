@@ -0,0 +1,151 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+func TestCombinedCustomObjectIterator(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/customobjects/subscription_c/describe.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []marketoclient.ObjectDescription{{
+			Name:         "subscription_c",
+			IDField:      "marketoGUID",
+			DedupeFields: []string{"subscriptionId"},
+			Fields: []marketoclient.ObjectField{
+				{Name: "marketoGUID"}, {Name: "subscriptionId"}, {Name: "plan"}, {Name: "createdAt"}, {Name: "updatedAt"},
+			},
+		}}, "", false)
+	})
+	header := "subscriptionId,createdAt,updatedAt,plan\n"
+	files := []string{
+		header + "s1,2022-01-02T00:00:00Z,2022-01-02T00:00:00Z,basic\ns2,2022-01-02T00:00:00Z,2022-01-03T00:00:00Z,basic\n",
+		header + "s1,2022-01-02T00:00:00Z,2022-01-04T00:00:00Z,premium\n",
+	}
+	var mu sync.Mutex
+	var startDates []string
+	f.Handle("/bulk/v1/customobjects/subscription_c/export/create.json", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Filter struct {
+				UpdatedAt map[string]string `json:"updatedAt"`
+			} `json:"filter"`
+			Fields []string `json:"fields"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		want := []string{"subscriptionId", "createdAt", "updatedAt", "plan"}
+		if !reflect.DeepEqual(body.Fields, want) {
+			t.Errorf("expected fields %v, got %v", want, body.Fields)
+		}
+		mu.Lock()
+		startDates = append(startDates, body.Filter.UpdatedAt["startAt"])
+		n := len(startDates)
+		mu.Unlock()
+		marketotest.WriteResult(w, []marketoclient.CreateExportResult{{ExportID: fmt.Sprintf("export-%d", n)}}, "", false)
+	})
+	// serves enqueue.json, status.json and file.json of every export, exports past the files hold no records.
+	const exportPath = "/bulk/v1/customobjects/subscription_c/export/"
+	f.HandlePrefix(exportPath, func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		var action string
+		if _, err := fmt.Sscanf(strings.TrimPrefix(r.URL.Path, exportPath), "export-%d/%s", &n, &action); err != nil {
+			t.Errorf("unexpected export request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		file := ""
+		if n <= len(files) {
+			file = files[n-1]
+		}
+		switch action {
+		case "enqueue.json":
+			marketotest.WriteResult(w, nil, "", false)
+		case "status.json":
+			records := 0
+			if file != "" {
+				records = 1
+			}
+			marketotest.WriteResult(w, []marketoclient.StatusOfExportResult{{Status: "Completed", NumberOfRecords: records}}, "", false)
+		case "file.json":
+			_, _ = w.Write([]byte(file))
+		default:
+			t.Errorf("unexpected export request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	})
+	client := *f.Client(t)
+	it, err := NewCombinedCustomObjectIterator(ctx, f.URL, 10*time.Millisecond, client, position.Position{}, []string{"plan"},
+		time.Now().Add(-time.Hour), "subscription_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	var records []sdk.Record
+	deadline := time.Now().Add(10 * time.Second)
+	for len(records) < 3 && time.Now().Before(deadline) {
+		if !it.HasNext(ctx) {
+			time.Sleep(5 * time.Millisecond)
+			continue
+		}
+		rec, err := it.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	want := []struct {
+		operation sdk.Operation
+		key       string
+	}{
+		{sdk.OperationSnapshot, "s1"},
+		{sdk.OperationSnapshot, "s2"},
+		{sdk.OperationUpdate, "s1"},
+	}
+	for i, w := range want {
+		key := sdk.StructuredData{"subscriptionId": w.key}
+		if records[i].Operation != w.operation || !reflect.DeepEqual(records[i].Key, key) {
+			t.Errorf("expected record %d to be %v of %v, got %v of %v", i, w.operation, key, records[i].Operation, records[i].Key)
+		}
+	}
+	if plan := records[2].Payload.After.(sdk.StructuredData)["plan"]; plan != "premium" {
+		t.Errorf("expected updated plan premium, got %v", plan)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// the poll exports the records updated after the latest exported one.
+	if startDates[1] != "2022-01-03T00:00:01Z" {
+		t.Errorf("expected the first poll to start at %s, got %s", "2022-01-03T00:00:01Z", startDates[1])
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+// Activity types of static list membership changes. The primary attribute of their activities is the list id.
+const (
+	ActivityTypeIDAddToList      = 24
+	ActivityTypeIDRemoveFromList = 25
+)
+
+// Metadata keys of list member records.
+const (
+	// MetadataListID is the metadata key holding the id of the static list of a list member.
+	MetadataListID = "listId"
+)
+
+// list membership read by the ListMemberIterator
+type listMemberRecord struct {
+	listID     int
+	leadID     int
+	removed    bool // true if the lead was removed from the list
+	snapshot   bool // true if the member was read by the snapshot of the list
+	activityID int  // id of the membership activity, 0 for snapshot records
+	date       time.Time
+	pageToken  string // paging token of the poll the activity was read in, or of the snapshot start
+}
+
+// ListMemberIterator reads the members of static lists. The current members of every list are read first as
+// snapshot records, then the Add to List and Remove from List activities of the lists are polled and emitted as
+// create and delete records.
+type ListMemberIterator struct {
+	*poller
+	client        *marketoclient.Client // marketo client
+	lists         map[int]bool          // lists to read the members of
+	snapshotLists []int                 // lists whose members are still to be read by the snapshot
+	lastLead      int                   // id of the last lead read of the first snapshot list, leads up to it are skipped
+	pageToken     string                // paging token to start the next poll from
+	lastKey       int                   // id of the last activity read, activities up to it are skipped
+}
+
+// returns NewListMemberIterator which reads the members of the given lists. If position is zero or a snapshot
+// position, the snapshot starts right away, resuming after the list and lead of the position key. Otherwise polling resumes
+// from the page token of the position, skipping activities up to the position key.
+func NewListMemberIterator(ctx context.Context, client *marketoclient.Client, pollingPeriod time.Duration, listIDs []int, p position.Position) (*ListMemberIterator, error) {
+	iterator := &ListMemberIterator{
+		client:    client,
+		lists:     make(map[int]bool, len(listIDs)),
+		pageToken: p.PageToken,
+	}
+	for _, id := range listIDs {
+		iterator.lists[id] = true
+	}
+	switch p.Type {
+	case position.TypeSnapshot:
+		iterator.snapshotLists = listIDs
+		if listID, leadID, found := cut(p.Key, ":"); found {
+			for i, id := range listIDs {
+				if strconv.Itoa(id) == listID {
+					iterator.snapshotLists = listIDs[i:]
+					lastLead, err := strconv.Atoi(leadID)
+					if err != nil {
+						return nil, fmt.Errorf("invalid list member position key %q: %w", p.Key, err)
+					}
+					iterator.lastLead = lastLead
+					break
+				}
+			}
+		}
+	case position.TypeCDC:
+		var err error
+		iterator.lastKey, err = strconv.Atoi(p.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid list member position key %q: %w", p.Key, err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid position type (%d)", p.Type)
+	}
+	iterator.poller = newPoller(ctx, "list member", pollingPeriod, len(iterator.snapshotLists) > 0, iterator.flush)
+	return iterator, nil
+}
+
+// reads the snapshot if it isn't read yet, otherwise polls marketo for membership changes.
+func (l *ListMemberIterator) flush(ctx context.Context, push func(sdk.Record) error) (func(), error) {
+	if len(l.snapshotLists) > 0 {
+		return l.flushSnapshot(ctx, push)
+	}
+	return l.flushLatestMembers(ctx, push)
+}
+
+// reads the members of the lists still to be read by the snapshot and pushes them to the buffer. Polling starts
+// from the page token of the time the snapshot started, so no membership change is missed.
+func (l *ListMemberIterator) flushSnapshot(ctx context.Context, push func(sdk.Record) error) (func(), error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "flushSnapshot").Logger()
+	logger.Trace().Msg("Starting the flushSnapshot")
+
+	startedAt := time.Now().UTC()
+	pageToken := l.pageToken
+	if pageToken == "" {
+		var err error
+		pageToken, err = l.client.GetNextPageToken(startedAt)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error while getting the next page token")
+			return nil, fmt.Errorf("error getting next page token %w", err)
+		}
+	}
+	for i, listID := range l.snapshotLists {
+		var moreResult = true
+		token := ""
+		for moreResult {
+			res, err := l.client.GetListLeads(listID, []string{"id"}, token)
+			if err != nil {
+				logger.Error().Err(err).Msgf("Error while getting the leads of list %d", listID)
+				return nil, fmt.Errorf("error getting leads of list %d %w", listID, err)
+			}
+			moreResult = res.MoreResult
+			token = res.NextPageToken
+			if len(res.Result) == 0 {
+				continue
+			}
+			var leads []struct {
+				ID int `json:"id"`
+			}
+			err = json.Unmarshal(res.Result, &leads)
+			if err != nil {
+				return nil, fmt.Errorf("error unmarshalling leads of list %d %w", listID, err)
+			}
+			for _, lead := range leads {
+				// leads are returned ordered by id, the ones up to the position were already read.
+				if i == 0 && lead.ID <= l.lastLead {
+					continue
+				}
+				r := listMemberRecord{
+					listID:    listID,
+					leadID:    lead.ID,
+					snapshot:  true,
+					date:      startedAt,
+					pageToken: pageToken,
+				}
+				record, err := l.prepareRecord(r)
+				if err != nil {
+					return nil, err
+				}
+				err = push(record)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return func() {
+		l.pageToken = pageToken
+		l.snapshotLists, l.lastLead = nil, 0
+	}, nil
+}
+
+// fetches the Add to List and Remove from List activities of the lists since the page token and stores them in the
+// buffer, ordered by id. Like the ActivityIterator, the next poll starts from the page token of the time this poll
+// started.
+func (l *ListMemberIterator) flushLatestMembers(ctx context.Context, push func(sdk.Record) error) (func(), error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "flushLatestMembers").Logger()
+	logger.Trace().Msg("Starting the flushLatestMembers")
+
+	nextToken, err := l.client.GetNextPageToken(time.Now())
+	if err != nil {
+		logger.Error().Err(err).Msg("Error while getting the next page token")
+		return nil, fmt.Errorf("error getting next page token %w", err)
+	}
+	activities, err := getActivities(l.client, l.pageToken, []int{ActivityTypeIDAddToList, ActivityTypeIDRemoveFromList})
+	if err != nil {
+		logger.Error().Err(err).Msg("Error while getting the list membership activities")
+		return nil, fmt.Errorf("error getting list membership activities %w", err)
+	}
+	var records = make([]listMemberRecord, 0, len(activities))
+	for _, activity := range activities {
+		id := int(toInt64(activity["id"]))
+		listID := int(toInt64(activity["primaryAttributeValueId"]))
+		if id <= l.lastKey || !l.lists[listID] {
+			continue
+		}
+		activityDate, err := time.Parse(time.RFC3339, fmt.Sprint(activity["activityDate"]))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing activityDate %w", err)
+		}
+		records = append(records, listMemberRecord{
+			listID:     listID,
+			leadID:     int(toInt64(activity["leadId"])),
+			removed:    toInt64(activity["activityTypeId"]) == ActivityTypeIDRemoveFromList,
+			activityID: id,
+			date:       activityDate,
+			pageToken:  l.pageToken,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].activityID < records[j].activityID })
+	lastKey := l.lastKey
+	for _, r := range records {
+		record, err := l.prepareRecord(r)
+		if err != nil {
+			return nil, err
+		}
+		err = push(record)
+		if err != nil {
+			return nil, err
+		}
+		lastKey = r.activityID
+	}
+	logger.Trace().Msgf("Flushed %d list membership changes", len(records))
+	return func() {
+		l.pageToken, l.lastKey = nextToken, lastKey
+	}, nil
+}
+
+// returns record in the format of sdk.Record
+func (l *ListMemberIterator) prepareRecord(r listMemberRecord) (sdk.Record, error) {
+	// snapshot positions are keyed by list and lead, change positions by activity.
+	positionType, positionKey := position.TypeCDC, strconv.Itoa(r.activityID)
+	if r.snapshot {
+		positionType, positionKey = position.TypeSnapshot, fmt.Sprintf("%d:%d", r.listID, r.leadID)
+	}
+	position := position.Position{
+		Type:      positionType,
+		Key:       positionKey,
+		CreatedAt: r.date,
+		UpdatedAt: r.date,
+		PageToken: r.pageToken,
+	}
+	pos, err := position.ToRecordPosition()
+	if err != nil {
+		return sdk.Record{}, err
+	}
+	metadata := make(sdk.Metadata)
+	metadata.SetCreatedAt(r.date)
+	metadata[MetadataListID] = strconv.Itoa(r.listID)
+	metadata[MetadataLeadID] = strconv.Itoa(r.leadID)
+	key := sdk.StructuredData{"listId": r.listID, "leadId": r.leadID}
+	payload := sdk.StructuredData{"listId": r.listID, "leadId": r.leadID}
+	switch {
+	case r.snapshot:
+		return sdk.Util.Source.NewRecordSnapshot(pos, metadata, key, payload), nil
+	case r.removed:
+		return sdk.Util.Source.NewRecordDelete(pos, metadata, key), nil
+	default:
+		return sdk.Util.Source.NewRecordCreate(pos, metadata, key, payload), nil
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+func TestCombinedListMemberIterator(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/activities/pagingtoken.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, nil, "token", false)
+	})
+	f.Handle("/rest/v1/lists/1001/leads.json", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("nextPageToken") == "" {
+			marketotest.WriteResult(w, []map[string]interface{}{{"id": 1}}, "page2", true)
+			return
+		}
+		marketotest.WriteResult(w, []map[string]interface{}{{"id": 2}}, "", false)
+	})
+	f.Handle("/rest/v1/lists/1002/leads.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, nil, "", false)
+	})
+	f.Handle("/rest/v1/activities.json", func(w http.ResponseWriter, r *http.Request) {
+		if types := r.URL.Query().Get("activityTypeIds"); types != "24,25" {
+			t.Errorf("unexpected activity types %s", types)
+		}
+		marketotest.WriteResult(w, []map[string]interface{}{
+			{"id": 11, "leadId": 1, "activityTypeId": 25, "primaryAttributeValueId": 1001, "activityDate": "2022-01-02T00:00:00Z"},
+			{"id": 10, "leadId": 3, "activityTypeId": 24, "primaryAttributeValueId": 1002, "activityDate": "2022-01-01T00:00:00Z"},
+			{"id": 12, "leadId": 3, "activityTypeId": 24, "primaryAttributeValueId": 9999, "activityDate": "2022-01-02T00:00:00Z"},
+		}, "", false)
+	})
+	client := *f.Client(t)
+	it, err := NewCombinedListMemberIterator(ctx, 10*time.Millisecond, client, position.Position{}, []int{1001, 1002})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readAckedRecords(ctx, t, it, 4)
+	want := []struct {
+		operation sdk.Operation
+		listID    int
+		leadID    int
+	}{
+		{sdk.OperationSnapshot, 1001, 1},
+		{sdk.OperationSnapshot, 1001, 2},
+		{sdk.OperationCreate, 1002, 3},
+		{sdk.OperationDelete, 1001, 1},
+	}
+	for i, w := range want {
+		key := sdk.StructuredData{"listId": w.listID, "leadId": w.leadID}
+		if records[i].Operation != w.operation || !reflect.DeepEqual(records[i].Key, key) {
+			t.Errorf("expected record %d to be %v of %v, got %v of %v", i, w.operation, key, records[i].Operation, records[i].Key)
+		}
+	}
+	p, err := position.ParseRecordPosition(records[3].Position)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Type != position.TypeCDC || p.Key != "11" || p.PageToken != "token" {
+		t.Errorf("expected change position at activity 11 of page token, got %+v", p)
+	}
+}
+
+func TestListMemberIterator_ResumeSnapshot(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/lists/1001/leads.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []map[string]interface{}{{"id": 1}, {"id": 2}, {"id": 3}}, "", false)
+	})
+	f.Handle("/rest/v1/lists/1002/leads.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []map[string]interface{}{{"id": 1}}, "", false)
+	})
+	client := *f.Client(t)
+	p := position.Position{Type: position.TypeSnapshot, Key: "1001:2", PageToken: "token"}
+	it, err := NewListMemberIterator(ctx, &client, time.Hour, []int{1000, 1001, 1002}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	// the snapshot resumes after lead 2 of list 1001 and reads the following lists in full.
+	records := readAckedRecords(ctx, t, it, 2)
+	for i, key := range []sdk.StructuredData{{"listId": 1001, "leadId": 3}, {"listId": 1002, "leadId": 1}} {
+		if records[i].Operation != sdk.OperationSnapshot || !reflect.DeepEqual(records[i].Key, key) {
+			t.Errorf("expected record %d to be a snapshot of %v, got %v of %v", i, key, records[i].Operation, records[i].Key)
+		}
+	}
+	if got := len(f.RequestsTo("/rest/v1/lists/1000/leads.json")); got != 0 {
+		t.Errorf("expected the lists before the position not to be read, got %d requests", got)
+	}
+}
+
+func TestNewListMemberIterator_InvalidSnapshotKey(t *testing.T) {
+	ctx := context.Background()
+	client := *marketotest.NewServer(t).Client(t)
+	p := position.Position{Type: position.TypeSnapshot, Key: "1001:lead"}
+	if _, err := NewListMemberIterator(ctx, &client, time.Hour, []int{1001}, p); err == nil {
+		t.Error("expected an error for an invalid lead id in the position key")
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+	"gopkg.in/tomb.v2"
+)
+
+// Metadata keys of all records.
+const (
+	// MetadataObject is the metadata key holding the object a record was read from.
+	MetadataObject = "object"
+)
+
+// idlePeriod is the period of checking an iterator without records for new ones.
+const idlePeriod = 100 * time.Millisecond
+
+// record read by the iterator of an object
+type objectRecord struct {
+	object string
+	record sdk.Record
+}
+
+// MultiIterator reads several objects, with one iterator per object reading in its own goRoutine. Record positions
+// are composite positions holding the position of the last record read of every object, so every object resumes
+// from its own position.
+type MultiIterator struct {
+	iterators map[string]*CombinedIterator // iterators by object
+	positions map[string]position.Position // position of the last record read by object
+	buffer    chan objectRecord            // buffer to store the records read by the iterators
+	tomb      *tomb.Tomb                   // tomb to handle errors in goRoutines
+}
+
+// returns NewMultiIterator which reads the given objects, creating the iterator of every object with newIterator
+// from the position of the object in the given composite position.
+func NewMultiIterator(ctx context.Context, p position.CompositePosition, objects []string, newIterator func(ctx context.Context, object string, p position.Position) (*CombinedIterator, error)) (*MultiIterator, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "NewMultiIterator").Logger()
+	logger.Trace().Msg("Starting the NewMultiIterator")
+
+	if len(objects) == 0 {
+		return nil, errors.New("no objects to read")
+	}
+	m := &MultiIterator{
+		iterators: make(map[string]*CombinedIterator, len(objects)),
+		positions: make(map[string]position.Position, len(objects)),
+		buffer:    make(chan objectRecord, 1),
+		tomb:      &tomb.Tomb{},
+	}
+	for _, object := range objects {
+		objectPosition, ok := p.Positions[object]
+		if ok {
+			m.positions[object] = objectPosition
+		}
+		it, err := newIterator(ctx, object, objectPosition)
+		if err != nil {
+			logger.Error().Err(err).Msgf("Error while creating the iterator of %s", object)
+			for _, it := range m.iterators {
+				it.Stop()
+			}
+			return nil, fmt.Errorf("error creating iterator of %s %w", object, err)
+		}
+		m.iterators[object] = it
+	}
+	for object, it := range m.iterators {
+		object, it := object, it
+		m.tomb.Go(func() error {
+			return m.read(m.tomb.Context(ctx), object, it)
+		})
+	}
+	return m, nil
+}
+
+// read is the goRoutine reading the records of an object into the buffer. Like the source, it checks the iterator
+// has a record before reading it, and checks it again after idlePeriod if not.
+func (m *MultiIterator) read(ctx context.Context, object string, it *CombinedIterator) error {
+	for {
+		if !it.HasNext(ctx) {
+			select {
+			case <-time.After(idlePeriod):
+				continue
+			case <-m.tomb.Dying():
+				return tomb.ErrDying
+			}
+		}
+		r, err := it.Next(ctx)
+		switch {
+		case errors.Is(err, sdk.ErrBackoffRetry):
+			continue
+		case err != nil && !m.tomb.Alive():
+			return tomb.ErrDying
+		case err != nil:
+			return fmt.Errorf("error reading %s %w", object, err)
+		}
+		select {
+		case m.buffer <- objectRecord{object: object, record: r}:
+		case <-m.tomb.Dying():
+			return tomb.ErrDying
+		}
+	}
+}
+
+// returns true if there are more records to be read from the iterator's buffer, otherwise returns false.
+func (m *MultiIterator) HasNext(ctx context.Context) bool {
+	return len(m.buffer) > 0 || !m.tomb.Alive() // if tomb is dead we return true so caller will fetch error with Next
+}
+
+// returns Next record from the iterator's buffer, with a composite position and tagged with its object, otherwise
+// returns error.
+func (m *MultiIterator) Next(ctx context.Context) (sdk.Record, error) {
+	select {
+	case r := <-m.buffer:
+		return m.prepareRecord(r)
+	case <-m.tomb.Dead():
+		return sdk.Record{}, m.tomb.Err()
+	case <-ctx.Done():
+		return sdk.Record{}, ctx.Err()
+	}
+}
+
+// Ack forwards the position of the acknowledged record's object to the iterator of the object.
+func (m *MultiIterator) Ack(ctx context.Context, p position.CompositePosition) error {
+	it, ok := m.iterators[p.Object]
+	if !ok {
+		return fmt.Errorf("ack of unknown object %q", p.Object)
+	}
+	return it.Ack(ctx, p.Positions[p.Object])
+}
+
+func (m *MultiIterator) Stop() {
+	// stop the goRoutines, then the iterators once no goRoutine reads them anymore
+	m.tomb.Kill(errors.New("multi iterator is stopped"))
+	<-m.tomb.Dead()
+	for _, it := range m.iterators {
+		it.Stop()
+	}
+}
+
+// returns the record of an object with the composite position of the objects read so far.
+func (m *MultiIterator) prepareRecord(r objectRecord) (sdk.Record, error) {
+	p, err := position.ParseRecordPosition(r.record.Position)
+	if err != nil {
+		return sdk.Record{}, fmt.Errorf("error parsing position of %s %w", r.object, err)
+	}
+	m.positions[r.object] = p
+	positions := make(map[string]position.Position, len(m.positions))
+	for object, objectPosition := range m.positions {
+		positions[object] = objectPosition
+	}
+	r.record.Position, err = position.CompositePosition{Object: r.object, Positions: positions}.ToRecordPosition()
+	if err != nil {
+		return sdk.Record{}, err
+	}
+	if r.record.Metadata == nil {
+		r.record.Metadata = make(sdk.Metadata)
+	}
+	r.record.Metadata[MetadataObject] = r.object
+	return r.record, nil
+}
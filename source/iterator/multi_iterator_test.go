@@ -0,0 +1,102 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+	"golang.org/x/time/rate"
+)
+
+func TestMultiIterator(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/asset/v1/programs.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []map[string]interface{}{
+			{"id": 1, "name": "Webinar", "createdAt": "2022-01-01T00:00:00Z+0000", "updatedAt": "2022-01-01T00:00:00Z+0000"},
+			{"id": 2, "name": "Newsletter", "createdAt": "2022-01-01T00:00:00Z+0000", "updatedAt": "2022-01-02T00:00:00Z+0000"},
+		}, "", false)
+	})
+	f.Handle("/rest/asset/v1/folders.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []map[string]interface{}{
+			{"id": 10, "name": "Marketing", "createdAt": "2022-01-01T00:00:00Z+0000", "updatedAt": "2022-01-03T00:00:00Z+0000"},
+		}, "", false)
+	})
+	// the iterators share the client, like the iterators of the source.
+	client := f.Client(t).WithRateLimit(ctx, rate.NewLimiter(rate.Inf, 1))
+	assets := map[string]string{"programs": marketoclient.AssetPrograms, "folders": marketoclient.AssetFolders}
+	newIterator := func(ctx context.Context, object string, p position.Position) (*CombinedIterator, error) {
+		return NewCombinedAssetIterator(ctx, 10*time.Millisecond, time.Hour, client, p, assets[object])
+	}
+	it, err := NewMultiIterator(ctx, position.CompositePosition{}, []string{"programs", "folders"}, newIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := readRecords(ctx, t, it, 3)
+	it.Stop()
+
+	keys := map[string][]string{}
+	for _, rec := range records {
+		object := rec.Metadata[MetadataObject]
+		keys[object] = append(keys[object], string(rec.Key.Bytes()))
+		p, err := position.ParseCompositePosition(rec.Position, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Object != object {
+			t.Errorf("expected position of %s, got %s", object, p.Object)
+		}
+		if err := it.Ack(ctx, p); err != nil {
+			t.Errorf("expected no error acking %s, got %v", object, err)
+		}
+	}
+	if len(keys["programs"]) != 2 || keys["programs"][1] != "2" || len(keys["folders"]) != 1 || keys["folders"][0] != "10" {
+		t.Fatalf("expected programs 1, 2 and folder 10, got %v", keys)
+	}
+	last, err := position.ParseCompositePosition(records[len(records)-1].Position, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last.Positions) != 2 {
+		t.Fatalf("expected the last position to hold both objects, got %+v", last)
+	}
+	if err := it.Ack(ctx, position.CompositePosition{Object: "emails"}); err == nil {
+		t.Error("expected an error acking an unknown object")
+	}
+
+	// resuming from the last position skips the records read by both objects, and polls the new ones.
+	f.Handle("/rest/asset/v1/programs.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []map[string]interface{}{
+			{"id": 1, "name": "Webinar", "createdAt": "2022-01-01T00:00:00Z+0000", "updatedAt": "2022-01-01T00:00:00Z+0000"},
+			{"id": 2, "name": "Newsletter", "createdAt": "2022-01-01T00:00:00Z+0000", "updatedAt": "2022-01-02T00:00:00Z+0000"},
+			{"id": 3, "name": "Launch", "createdAt": "2022-01-04T00:00:00Z+0000", "updatedAt": "2022-01-04T00:00:00Z+0000"},
+		}, "", false)
+	})
+	it, err = NewMultiIterator(ctx, last, []string{"programs", "folders"}, newIterator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+	records = readRecords(ctx, t, it, 1)
+	if records[0].Metadata[MetadataObject] != "programs" || string(records[0].Key.Bytes()) != "3" {
+		t.Errorf("expected program 3, got %s %s", records[0].Metadata[MetadataObject], records[0].Key.Bytes())
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+	"gopkg.in/tomb.v2"
+)
+
+// pollFunc reads the records changed since the iterator's checkpoint, passing each one to push, and returns the
+// function advancing the checkpoint past them.
+type pollFunc func(ctx context.Context, push func(sdk.Record) error) (commit func(), err error)
+
+// poller runs the polls of an iterator and buffers the records they read. A poll is skipped while records of the
+// previous one are not acknowledged, and its checkpoint is committed once all of them are, so a poll never starts
+// from records which may have to be read again.
+type poller struct {
+	name   string          // name of the iterator, used in errors
+	buffer chan sdk.Record // buffer to store latest records
+	ticker *time.Ticker    // ticker to poll marketo
+	tomb   *tomb.Tomb      // tomb to handle errors in goRoutines
+
+	mu      sync.Mutex // guards the state below, which is shared with Ack
+	unacked int        // records pushed to the buffer and not yet acknowledged
+	commit  func()     // commits the checkpoint of the latest poll once all its records are acknowledged
+}
+
+// returns newPoller which runs poll every pollingPeriod, and right away if immediate is set.
+func newPoller(ctx context.Context, name string, pollingPeriod time.Duration, immediate bool, poll pollFunc) *poller {
+	p := &poller{
+		name:   name,
+		buffer: make(chan sdk.Record, 1),
+		ticker: time.NewTicker(pollingPeriod),
+		tomb:   &tomb.Tomb{},
+	}
+	p.tomb.Go(func() error {
+		return p.run(ctx, immediate, poll)
+	})
+	return p
+}
+
+// run is the main goRoutine that polls marketo for changes
+func (p *poller) run(ctx context.Context, immediate bool, poll pollFunc) error {
+	defer close(p.buffer)
+	if immediate {
+		err := p.flush(ctx, poll)
+		if err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-p.tomb.Dying():
+			return p.tomb.Err()
+		case <-p.ticker.C:
+			err := p.flush(ctx, poll)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// runs poll unless records of the previous poll are not acknowledged yet, and commits its checkpoint right away
+// if all its records are already acknowledged.
+func (p *poller) flush(ctx context.Context, poll pollFunc) error {
+	p.mu.Lock()
+	unacked := p.unacked
+	p.mu.Unlock()
+	if unacked > 0 {
+		sdk.Logger(ctx).Debug().Msgf("Skipping poll, %d records of the previous poll are not acknowledged yet", unacked)
+		return nil
+	}
+	commit, err := poll(p.tomb.Context(ctx), p.push)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.unacked == 0 {
+		// nothing pushed or everything already acknowledged.
+		commit()
+		return nil
+	}
+	p.commit = commit
+	return nil
+}
+
+// pushes record to the buffer, counting it as unacknowledged. Returns an error if the iterator is stopped.
+func (p *poller) push(r sdk.Record) error {
+	select {
+	case <-p.tomb.Dying():
+		return tomb.ErrDying
+	default:
+	}
+	p.mu.Lock()
+	p.unacked++
+	p.mu.Unlock()
+	select {
+	case p.buffer <- r:
+		return nil
+	case <-p.tomb.Dying():
+		return tomb.ErrDying
+	}
+}
+
+// returns true if there are more records to be read from the iterator's buffer, otherwise returns false.
+func (p *poller) HasNext(ctx context.Context) bool {
+	return len(p.buffer) > 0 || !p.tomb.Alive() // if tomb is dead we return true so caller will fetch error with Next
+}
+
+// returns Next record from the iterator's buffer, otherwise returns error.
+func (p *poller) Next(ctx context.Context) (sdk.Record, error) {
+	select {
+	case r, ok := <-p.buffer:
+		if !ok {
+			<-p.tomb.Dead()
+			return sdk.Record{}, p.tomb.Err()
+		}
+		return r, nil
+	case <-p.tomb.Dead():
+		return sdk.Record{}, p.tomb.Err()
+	case <-ctx.Done():
+		return sdk.Record{}, ctx.Err()
+	}
+}
+
+// Ack counts the record of the position as acknowledged. Once every record of a poll is acknowledged, its
+// checkpoint is committed and the next poll starts from it.
+func (p *poller) Ack(ctx context.Context, pos position.Position) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.unacked == 0 {
+		return fmt.Errorf("unexpected ack for key %q, no records pending", pos.Key)
+	}
+	p.unacked--
+	if p.unacked == 0 && p.commit != nil {
+		sdk.Logger(ctx).Trace().Msg("All records of the poll acknowledged, committing its checkpoint")
+		p.commit()
+		p.commit = nil
+	}
+	return nil
+}
+
+func (p *poller) Stop() {
+	// stop the goRoutines
+	p.ticker.Stop()
+	p.tomb.Kill(errors.New(p.name + " iterator is stopped"))
+}
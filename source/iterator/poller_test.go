@@ -0,0 +1,95 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+func TestPoller_CommitsOnAck(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var polls, checkpoint int
+	poll := func(ctx context.Context, push func(sdk.Record) error) (func(), error) {
+		mu.Lock()
+		polls++
+		from := checkpoint
+		mu.Unlock()
+		for i := from + 1; i <= from+2; i++ {
+			pos, _ := position.Position{Type: position.TypeCDC, Key: strconv.Itoa(i)}.ToRecordPosition()
+			if err := push(sdk.Util.Source.NewRecordCreate(pos, nil, sdk.RawData(strconv.Itoa(i)), nil)); err != nil {
+				return nil, err
+			}
+		}
+		return func() {
+			mu.Lock()
+			checkpoint = from + 2
+			mu.Unlock()
+		}, nil
+	}
+	p := newPoller(ctx, "test", 10*time.Millisecond, true, poll)
+	defer p.Stop()
+
+	records := readRecords(ctx, t, p, 2)
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	if polls != 1 || checkpoint != 0 {
+		t.Errorf("expected a single poll and no checkpoint before acks, got %d polls and checkpoint %d", polls, checkpoint)
+	}
+	mu.Unlock()
+
+	if err := p.Ack(ctx, position.Position{Key: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if checkpoint != 0 {
+		t.Errorf("expected no checkpoint before every record is acknowledged, got %d", checkpoint)
+	}
+	mu.Unlock()
+	if err := p.Ack(ctx, position.Position{Key: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if checkpoint != 2 {
+		t.Errorf("expected checkpoint 2 once every record is acknowledged, got %d", checkpoint)
+	}
+	mu.Unlock()
+
+	// the next poll starts from the committed checkpoint.
+	records = readRecords(ctx, t, p, 1)
+	if got := string(records[0].Key.Bytes()); got != "3" {
+		t.Errorf("expected the next poll to start with record 3, got %s", got)
+	}
+}
+
+func TestPoller_UnexpectedAck(t *testing.T) {
+	ctx := context.Background()
+	poll := func(ctx context.Context, push func(sdk.Record) error) (func(), error) {
+		return func() {}, nil
+	}
+	p := newPoller(ctx, "test", time.Hour, false, poll)
+	defer p.Stop()
+
+	if err := p.Ack(ctx, position.Position{Key: "1"}); err == nil {
+		t.Error("expected an error acknowledging a record which wasn't read")
+	}
+}
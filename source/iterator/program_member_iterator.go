@@ -0,0 +1,251 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+// ActivityTypeIDChangeStatusInProgression is the activity type of program membership changes. The primary
+// attribute of its activities is the program id.
+const ActivityTypeIDChangeStatusInProgression = 104
+
+// Metadata keys of program member records.
+const (
+	// MetadataProgramID is the metadata key holding the id of the program of a program member.
+	MetadataProgramID = "programId"
+)
+
+// program member change read by the ProgramMemberIterator
+type programMemberRecord struct {
+	programID    int
+	leadID       int
+	data         map[string]interface{} // nil if the lead isn't a member of the program anymore
+	activityID   int                    // id of the latest membership activity of the member
+	activityDate time.Time
+	pageToken    string // paging token of the poll the activity was read in
+}
+
+type ProgramMemberIterator struct {
+	*poller
+	client     *marketoclient.Client // marketo client
+	fields     []string              // fields to fetch from marketo
+	programIDs map[int]bool          // programs to read the members of
+	since      time.Time             // time to start the first poll from, if pageToken is empty
+	pageToken  string                // paging token to start the next poll from
+	lastKey    int                   // id of the last activity read, activities up to it are skipped
+}
+
+// returns NewProgramMemberIterator which polls Marketo for membership changes of the given programs. If position
+// holds a page token, polling resumes from that page skipping activities up to the position key, otherwise it starts
+// from since.
+func NewProgramMemberIterator(ctx context.Context, client *marketoclient.Client, pollingPeriod time.Duration, fields []string, programIDs []int, since time.Time, p position.Position) (*ProgramMemberIterator, error) {
+	iterator := &ProgramMemberIterator{
+		client:     client,
+		fields:     fields,
+		programIDs: make(map[int]bool, len(programIDs)),
+		since:      since.UTC(),
+		pageToken:  p.PageToken,
+	}
+	for _, id := range programIDs {
+		iterator.programIDs[id] = true
+	}
+	if p.PageToken != "" {
+		// the key of a position without page token is the key of the last snapshot record.
+		var err error
+		iterator.lastKey, err = strconv.Atoi(p.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid program member position key %q: %w", p.Key, err)
+		}
+	}
+	iterator.poller = newPoller(ctx, "program member", pollingPeriod, false, iterator.flushLatestMembers)
+	return iterator, nil
+}
+
+// fetches the members changed by membership activities since the page token and stores them in the buffer, ordered
+// by their latest activity. Members which are not returned anymore are flushed as deleted. Like the
+// ActivityIterator, the next poll starts from the page token of the time this poll started.
+func (m *ProgramMemberIterator) flushLatestMembers(ctx context.Context, push func(sdk.Record) error) (func(), error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "flushLatestMembers").Logger()
+	logger.Trace().Msg("Starting the flushLatestMembers")
+
+	var err error
+	pageToken := m.pageToken
+	if pageToken == "" {
+		pageToken, err = m.client.GetNextPageToken(m.since)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error while getting the next page token")
+			return nil, fmt.Errorf("error getting next page token %w", err)
+		}
+	}
+	nextToken, err := m.client.GetNextPageToken(time.Now())
+	if err != nil {
+		logger.Error().Err(err).Msg("Error while getting the next page token")
+		return nil, fmt.Errorf("error getting next page token %w", err)
+	}
+
+	activities, err := getActivities(m.client, pageToken, []int{ActivityTypeIDChangeStatusInProgression})
+	if err != nil {
+		logger.Error().Err(err).Msg("Error while getting the membership activities")
+		return nil, fmt.Errorf("error getting membership activities %w", err)
+	}
+	var changes = make(map[[2]int]programMemberRecord)
+	for _, activity := range activities {
+		id := int(toInt64(activity["id"]))
+		programID := int(toInt64(activity["primaryAttributeValueId"]))
+		if id <= m.lastKey || !m.programIDs[programID] {
+			continue
+		}
+		leadID := int(toInt64(activity["leadId"]))
+		key := [2]int{programID, leadID}
+		if change, ok := changes[key]; ok && change.activityID > id {
+			continue
+		}
+		activityDate, err := time.Parse(time.RFC3339, fmt.Sprint(activity["activityDate"]))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing activityDate %w", err)
+		}
+		changes[key] = programMemberRecord{
+			programID:    programID,
+			leadID:       leadID,
+			activityID:   id,
+			activityDate: activityDate,
+			pageToken:    pageToken,
+		}
+	}
+
+	var leadIDs = make(map[int][]int)
+	for key := range changes {
+		leadIDs[key[0]] = append(leadIDs[key[0]], key[1])
+	}
+	var records = make([]programMemberRecord, 0, len(changes))
+	for programID, ids := range leadIDs {
+		sort.Ints(ids)
+		members, err := m.getMembers(programID, ids)
+		if err != nil {
+			logger.Error().Err(err).Msgf("Error while getting the members of program %d", programID)
+			return nil, fmt.Errorf("error getting members of program %d %w", programID, err)
+		}
+		for _, leadID := range ids {
+			r := changes[[2]int{programID, leadID}]
+			r.data = members[leadID]
+			records = append(records, r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].activityID < records[j].activityID })
+	lastKey := m.lastKey
+	for _, r := range records {
+		record, err := m.prepareRecord(r)
+		if err != nil {
+			return nil, err
+		}
+		err = push(record)
+		if err != nil {
+			return nil, err
+		}
+		lastKey = r.activityID
+	}
+	logger.Trace().Msgf("Flushed %d program members", len(records))
+	return func() {
+		m.pageToken, m.lastKey = nextToken, lastKey
+	}, nil
+}
+
+// returns members of the program with given lead ids by lead id. Lead ids are requested in chunks within
+// Marketo's filter limits.
+func (m *ProgramMemberIterator) getMembers(programID int, leadIDs []int) (map[int]map[string]interface{}, error) {
+	var members = make(map[int]map[string]interface{})
+	for _, chunk := range marketoclient.ChunkFilterValues("leadId", leadIDs, m.fields) {
+		var moreResult = true
+		token := ""
+		for moreResult {
+			res, err := m.client.GetProgramMembers(programID, chunk, m.fields, token)
+			if err != nil {
+				return nil, err
+			}
+			moreResult = res.MoreResult
+			token = res.NextPageToken
+			if len(res.Result) == 0 {
+				continue
+			}
+			var page []map[string]interface{}
+			err = json.Unmarshal(res.Result, &page)
+			if err != nil {
+				return nil, fmt.Errorf("error unmarshalling program members %w", err)
+			}
+			for _, member := range page {
+				leadID := int(toInt64(member["leadId"]))
+				member["programId"], member["leadId"] = programID, leadID
+				members[leadID] = member
+			}
+		}
+	}
+	return members, nil
+}
+
+// returns record in the format of sdk.Record
+func (m *ProgramMemberIterator) prepareRecord(r programMemberRecord) (sdk.Record, error) {
+	position := position.Position{
+		Type:      position.TypeCDC,
+		Key:       strconv.Itoa(r.activityID),
+		CreatedAt: r.activityDate,
+		UpdatedAt: r.activityDate,
+		PageToken: r.pageToken,
+	}
+	pos, err := position.ToRecordPosition()
+	if err != nil {
+		return sdk.Record{}, err
+	}
+	metadata := programMemberMetadata(r.programID, r.leadID, r.activityDate)
+	key := programMemberRecordKey(r.programID, r.leadID)
+	if r.data == nil {
+		return sdk.Util.Source.NewRecordDelete(pos, metadata, key), nil
+	}
+	return sdk.Util.Source.NewRecordUpdate(pos, metadata, key, nil, sdk.StructuredData(r.data)), nil
+}
+
+// returns the position key of a program member snapshot record.
+func programMemberKey(programID, leadID int) string {
+	return fmt.Sprintf("%d:%d", programID, leadID)
+}
+
+// returns the composite key of a program member record.
+func programMemberRecordKey(programID, leadID int) sdk.StructuredData {
+	return sdk.StructuredData{"programId": programID, "leadId": leadID}
+}
+
+// returns the metadata of a program member record.
+func programMemberMetadata(programID, leadID int, date time.Time) sdk.Metadata {
+	metadata := make(sdk.Metadata)
+	metadata.SetCreatedAt(date)
+	metadata[MetadataProgramID] = strconv.Itoa(programID)
+	metadata[MetadataLeadID] = strconv.Itoa(leadID)
+	return metadata
+}
+
+// returns value as int64 if it's a JSON number or a numeric string, otherwise 0.
+func toInt64(value interface{}) int64 {
+	v, _ := typedValue("integer", value).(int64)
+	return v
+}
@@ -0,0 +1,146 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+var testProgramMemberFields = []string{"programId", "leadId", "updatedAt", "statusName"}
+
+func TestCombinedProgramMemberIterator(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/bulk/v1/program/members/export/create.json", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Filter struct {
+				ProgramID int `json:"programId"`
+			} `json:"filter"`
+			Fields []string `json:"fields"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(body.Fields, testProgramMemberFields) {
+			t.Errorf("expected fields %v, got %v", testProgramMemberFields, body.Fields)
+		}
+		marketotest.WriteResult(w, []marketoclient.CreateExportResult{{ExportID: fmt.Sprintf("program-%d", body.Filter.ProgramID)}}, "", false)
+	})
+	for _, programID := range []int{1001, 1002} {
+		exportID := fmt.Sprintf("program-%d", programID)
+		f.Handle("/bulk/v1/program/members/export/"+exportID+"/enqueue.json", func(w http.ResponseWriter, r *http.Request) {
+			marketotest.WriteResult(w, nil, "", false)
+		})
+		f.Handle("/bulk/v1/program/members/export/"+exportID+"/status.json", func(w http.ResponseWriter, r *http.Request) {
+			marketotest.WriteResult(w, []marketoclient.StatusOfExportResult{{Status: "Completed", NumberOfRecords: 1}}, "", false)
+		})
+		file := fmt.Sprintf("leadId,programId,updatedAt,statusName\n5,%d,2022-01-02T00:00:00Z,Member\n", programID)
+		f.Handle("/bulk/v1/program/members/export/"+exportID+"/file.json", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(file))
+		})
+	}
+	f.Handle("/rest/v1/activities/pagingtoken.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, nil, "page-0", false)
+	})
+	f.Handle("/rest/v1/activities.json", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("activityTypeIds"); got != "104" {
+			t.Errorf("expected activity type ids %q, got %q", "104", got)
+		}
+		activity := func(id, programID, leadID int) map[string]interface{} {
+			return map[string]interface{}{
+				"id":                      id,
+				"leadId":                  leadID,
+				"activityDate":            "2022-01-03T00:00:00Z",
+				"activityTypeId":          ActivityTypeIDChangeStatusInProgression,
+				"primaryAttributeValueId": programID,
+			}
+		}
+		marketotest.WriteResult(w, []map[string]interface{}{
+			activity(203, 1001, 6),
+			activity(201, 1001, 5),
+			activity(202, 9999, 5), // not a configured program
+			activity(204, 1002, 5),
+		}, "page-1", false)
+	})
+	f.Handle("/rest/v1/programs/1001/members.json", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("filterValues"); got != "5,6" {
+			t.Errorf("expected lead ids %q, got %q", "5,6", got)
+		}
+		marketotest.WriteResult(w, []map[string]interface{}{
+			{"leadId": 5, "statusName": "Attended", "updatedAt": "2022-01-03T00:00:00Z"},
+			{"leadId": 6, "statusName": "Member", "updatedAt": "2022-01-03T00:00:00Z"},
+		}, "", false)
+	})
+	f.Handle("/rest/v1/programs/1002/members.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []interface{}{}, "", false) // lead 5 was removed from the program
+	})
+	client := *f.Client(t)
+	it, err := NewCombinedProgramMemberIterator(ctx, f.URL, 10*time.Millisecond, client, position.Position{}, testProgramMemberFields, []int{1001, 1002})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	var records []sdk.Record
+	deadline := time.Now().Add(10 * time.Second)
+	for len(records) < 5 && time.Now().Before(deadline) {
+		if !it.HasNext(ctx) {
+			time.Sleep(5 * time.Millisecond)
+			continue
+		}
+		rec, err := it.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 5 {
+		t.Fatalf("expected 5 records, got %d", len(records))
+	}
+	want := []struct {
+		operation sdk.Operation
+		programID int
+		leadID    int
+		status    string
+	}{
+		{sdk.OperationSnapshot, 1001, 5, "Member"},
+		{sdk.OperationSnapshot, 1002, 5, "Member"},
+		{sdk.OperationUpdate, 1001, 5, "Attended"},
+		{sdk.OperationUpdate, 1001, 6, "Member"},
+		{sdk.OperationDelete, 1002, 5, ""},
+	}
+	for i, w := range want {
+		rec := records[i]
+		key := sdk.StructuredData{"programId": w.programID, "leadId": w.leadID}
+		if rec.Operation != w.operation || !reflect.DeepEqual(rec.Key, key) {
+			t.Errorf("expected record %d to be %v of %v, got %v of %v", i, w.operation, key, rec.Operation, rec.Key)
+			continue
+		}
+		if w.status != "" && rec.Payload.After.(sdk.StructuredData)["statusName"] != w.status {
+			t.Errorf("expected record %d to have status %s, got %v", i, w.status, rec.Payload.After)
+		}
+	}
+}
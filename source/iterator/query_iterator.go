@@ -0,0 +1,378 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/SpeakData/minimarketo"
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+// record read by the QueryIterator
+type queryRecord struct {
+	key         string
+	createdAt   time.Time
+	updatedAt   time.Time
+	data        map[string]interface{} // nil if the record isn't listed anymore
+	snapshot    bool                   // true if the record was read by the first poll or a full refresh
+	positionKey string                 // key of the last record read, the record key unless the record is deleted
+}
+
+// QueryIterator polls the records of an object which can only be listed as a whole, like opportunities matching a
+// filter or assets. Every poll lists all records and emits the ones updated since the last record read, ordered by
+// updatedAt. If the object can be listed with the key and dates of the records only, polls do so and read the
+// updated records by key afterwards, and if it can be listed by updatedAt, polls list the records updated since the
+// last record read only. The records of the first poll are emitted as snapshot records. If a refresh period is set,
+// all records are emitted again as snapshot records every refresh period, and records which are not listed anymore
+// are emitted as deleted.
+type QueryIterator struct {
+	*poller
+	object        string                                                  // object to list, like marketoclient.QueryObjectOpportunities
+	keyField      string                                                  // field keying the records of the object
+	list          func() ([]map[string]interface{}, error)                // lists all records of the object
+	listDates     func() ([]map[string]interface{}, error)                // lists the keys and dates of all records, nil if not supported
+	get           func(keys []string) ([]map[string]interface{}, error)   // reads the records with given keys, set with listDates
+	listUpdated   func(since time.Time) ([]map[string]interface{}, error) // lists the records updated since, nil if not supported
+	refreshPeriod time.Duration                                           // period of full refreshes, 0 if disabled
+	snapshot      bool                                                    // true while the records of the first poll are read
+	lastModified  time.Time                                               // updatedAt of the last record read
+	lastKey       string                                                  // key of the last record read
+	lastRefresh   time.Time                                               // start of the latest full refresh
+	keys          map[string]bool                                         // keys listed by the previous poll, nil before the first poll
+}
+
+// returns NewQueryIterator which polls Marketo for records of the object matching the filter, keyed by their
+// marketoGUID. If position is zero or a snapshot position, the first poll runs right away and its records are
+// emitted as snapshot records. Records up to the updatedAt and key of the position are skipped. Following polls
+// query the marketoGUID and dates of the records only, and read the fields of the updated records by marketoGUID.
+func NewQueryIterator(ctx context.Context, client *marketoclient.Client, pollingPeriod time.Duration, object string, filterType string, filterValues []string, fields []string, p position.Position) (*QueryIterator, error) {
+	q := newQueryIterator(object, "marketoGUID", 0, func() ([]map[string]interface{}, error) {
+		return queryObjects(client, object, filterType, filterValues, fields, "marketoGUID")
+	}, p)
+	q.listDates = func() ([]map[string]interface{}, error) {
+		return queryObjects(client, object, filterType, filterValues, []string{"marketoGUID", "createdAt", "updatedAt"}, "marketoGUID")
+	}
+	q.get = func(keys []string) ([]map[string]interface{}, error) {
+		return queryObjects(client, object, "marketoGUID", keys, fields, "marketoGUID")
+	}
+	q.start(ctx, pollingPeriod)
+	return q, nil
+}
+
+// returns NewAssetIterator which polls the Asset API for assets of the given type, like
+// marketoclient.AssetPrograms, keyed by keyField. Assets are fully refreshed every refreshPeriod, see QueryIterator.
+// Polls of the assets in marketoclient.AssetsFilteredByUpdatedAt list the assets updated since the last asset read
+// only.
+func NewAssetIterator(ctx context.Context, client *marketoclient.Client, pollingPeriod time.Duration, refreshPeriod time.Duration, asset string, keyField string, p position.Position) (*QueryIterator, error) {
+	q := newQueryIterator(asset, keyField, refreshPeriod, func() ([]map[string]interface{}, error) {
+		return listAssets(asset, func(offset int) (*minimarketo.Response, error) {
+			return client.ListAssets(asset, offset, marketoclient.MaxAssetsReturn)
+		})
+	}, p)
+	if marketoclient.AssetsFilteredByUpdatedAt[asset] {
+		q.listUpdated = func(since time.Time) ([]map[string]interface{}, error) {
+			until := time.Now().UTC()
+			return listAssets(asset, func(offset int) (*minimarketo.Response, error) {
+				return client.ListUpdatedAssets(asset, since, until, offset, marketoclient.MaxAssetsReturn)
+			})
+		}
+	}
+	q.start(ctx, pollingPeriod)
+	return q, nil
+}
+
+// returns a QueryIterator listing the records of the object with list, which polls once started.
+func newQueryIterator(object string, keyField string, refreshPeriod time.Duration, list func() ([]map[string]interface{}, error), p position.Position) *QueryIterator {
+	return &QueryIterator{
+		object:        object,
+		keyField:      keyField,
+		list:          list,
+		refreshPeriod: refreshPeriod,
+		snapshot:      p.Type == position.TypeSnapshot,
+		lastModified:  p.UpdatedAt.UTC(),
+		lastKey:       p.Key,
+		lastRefresh:   time.Now().UTC(),
+	}
+}
+
+// starts polling every pollingPeriod, and right away if the records of the first poll are snapshot records.
+func (q *QueryIterator) start(ctx context.Context, pollingPeriod time.Duration) {
+	q.poller = newPoller(ctx, "query", pollingPeriod, q.snapshot, q.flushLatestRecords)
+}
+
+// lists the records and pushes the ones updated since the last record read to the buffer, ordered by updatedAt and
+// key, or all records if a full refresh is due. If full refreshes are enabled, records listed by the previous poll
+// which are missing are pushed first as deleted.
+func (q *QueryIterator) flushLatestRecords(ctx context.Context, push func(sdk.Record) error) (func(), error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "flushLatestRecords").Logger()
+	logger.Trace().Msg("Starting the flushLatestRecords")
+
+	polledAt := time.Now().UTC()
+	refresh := q.refreshPeriod > 0 && polledAt.Sub(q.lastRefresh) >= q.refreshPeriod
+	list := q.list
+	datesOnly := !q.snapshot && !refresh && q.listDates != nil
+	if datesOnly {
+		list = q.listDates
+	}
+	// polls listing the updated records only can't tell deleted records, they are found by the next full refresh.
+	updatedOnly := !q.snapshot && !refresh && q.listUpdated != nil
+	if updatedOnly {
+		list = func() ([]map[string]interface{}, error) {
+			return q.listUpdated(q.lastModified)
+		}
+	}
+	all, err := list()
+	if err != nil {
+		logger.Error().Err(err).Msgf("Error while listing %s", q.object)
+		return nil, fmt.Errorf("error listing %s %w", q.object, err)
+	}
+	var records []queryRecord
+	var keys = make(map[string]bool, len(all))
+	for _, data := range all {
+		r, err := newQueryRecord(q.keyField, data)
+		if err != nil {
+			return nil, err
+		}
+		keys[r.key] = true
+		if !refresh && (r.updatedAt.Before(q.lastModified) || (r.updatedAt.Equal(q.lastModified) && r.key <= q.lastKey)) {
+			continue
+		}
+		r.snapshot = q.snapshot || refresh
+		r.positionKey = r.key
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].updatedAt.Equal(records[j].updatedAt) {
+			return records[i].updatedAt.Before(records[j].updatedAt)
+		}
+		return records[i].key < records[j].key
+	})
+	if datesOnly {
+		records, err = q.getRecords(records)
+		if err != nil {
+			logger.Error().Err(err).Msgf("Error while reading the updated %s", q.object)
+			return nil, fmt.Errorf("error reading updated %s %w", q.object, err)
+		}
+	}
+	if updatedOnly {
+		for key := range q.keys {
+			keys[key] = true
+		}
+	} else if q.refreshPeriod > 0 && q.keys != nil {
+		var deleted []queryRecord
+		for key := range q.keys {
+			if !keys[key] {
+				// deletes keep the position of the last record read.
+				deleted = append(deleted, queryRecord{key: key, createdAt: polledAt, updatedAt: q.lastModified, positionKey: q.lastKey})
+			}
+		}
+		sort.Slice(deleted, func(i, j int) bool { return deleted[i].key < deleted[j].key })
+		records = append(deleted, records...)
+	}
+	lastModified, lastKey := q.lastModified, q.lastKey
+	for _, r := range records {
+		record, err := q.prepareRecord(r)
+		if err != nil {
+			return nil, err
+		}
+		err = push(record)
+		if err != nil {
+			return nil, err
+		}
+		if r.data != nil {
+			lastModified, lastKey = r.updatedAt, r.key
+		}
+	}
+	logger.Trace().Msgf("Flushed %d %s", len(records), q.object)
+	return func() {
+		q.lastModified, q.lastKey = lastModified, lastKey
+		q.snapshot = false
+		q.keys = keys
+		if refresh {
+			q.lastRefresh = polledAt
+		}
+	}, nil
+}
+
+// returns the records with the fields read by get, in the same order. Records which can't be read anymore are
+// dropped, like records deleted since they were listed.
+func (q *QueryIterator) getRecords(records []queryRecord) ([]queryRecord, error) {
+	if len(records) == 0 {
+		return records, nil
+	}
+	var keys = make([]string, 0, len(records))
+	for _, r := range records {
+		keys = append(keys, r.key)
+	}
+	all, err := q.get(keys)
+	if err != nil {
+		return nil, err
+	}
+	var data = make(map[string]map[string]interface{}, len(all))
+	for _, d := range all {
+		data[formatKey(d[q.keyField])] = d
+	}
+	var read = make([]queryRecord, 0, len(records))
+	for _, r := range records {
+		if r.data = data[r.key]; r.data != nil {
+			read = append(read, r)
+		}
+	}
+	return read, nil
+}
+
+// returns record in the format of sdk.Record
+func (q *QueryIterator) prepareRecord(r queryRecord) (sdk.Record, error) {
+	positionType := position.TypeCDC
+	if r.snapshot {
+		positionType = position.TypeSnapshot
+	}
+	position := position.Position{
+		Key:       r.positionKey,
+		CreatedAt: r.updatedAt,
+		UpdatedAt: r.updatedAt,
+		Type:      positionType,
+	}
+	pos, err := position.ToRecordPosition()
+	if err != nil {
+		return sdk.Record{}, fmt.Errorf("error converting position to record position %w", err)
+	}
+
+	metadata := make(sdk.Metadata)
+	metadata.SetCreatedAt(r.createdAt)
+	key := sdk.RawData(r.key)
+	if r.data == nil {
+		return sdk.Util.Source.NewRecordDelete(pos, metadata, key), nil
+	}
+	metadata["updatedAt"] = strconv.FormatInt(r.updatedAt.UnixNano(), 10)
+	switch {
+	case r.snapshot:
+		return sdk.Util.Source.NewRecordSnapshot(pos, metadata, key, sdk.StructuredData(r.data)), nil
+	case r.createdAt.Equal(r.updatedAt):
+		return sdk.Util.Source.NewRecordCreate(pos, metadata, key, sdk.StructuredData(r.data)), nil
+	default:
+		return sdk.Util.Source.NewRecordUpdate(pos, metadata, key, nil, sdk.StructuredData(r.data)), nil
+	}
+}
+
+// returns the listed record keyed by keyField. Records without updatedAt, like tag types, are only read by the first
+// poll and full refreshes.
+func newQueryRecord(keyField string, data map[string]interface{}) (queryRecord, error) {
+	value, ok := data[keyField]
+	if !ok || value == nil || value == "" {
+		return queryRecord{}, fmt.Errorf("record without %s: %v", keyField, data)
+	}
+	key := formatKey(value)
+	var updatedAt time.Time
+	if v, ok := data["updatedAt"]; ok {
+		var err error
+		updatedAt, err = parseDate(fmt.Sprint(v))
+		if err != nil {
+			return queryRecord{}, fmt.Errorf("error parsing updatedAt %w", err)
+		}
+	}
+	createdAt, err := parseDate(fmt.Sprint(data["createdAt"]))
+	if err != nil {
+		createdAt = updatedAt
+	}
+	return queryRecord{key: key, createdAt: createdAt.UTC(), updatedAt: updatedAt.UTC(), data: data}, nil
+}
+
+// returns the key value of a record as a string. JSON numbers, like asset ids, are formatted without exponent.
+func formatKey(value interface{}) string {
+	if v, ok := value.(float64); ok {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// parses a date of the REST API, or of the Asset API which appends the zone offset to UTC dates,
+// e.g. 2022-01-02T15:04:05Z+0000.
+func parseDate(value string) (time.Time, error) {
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		if assetDate, assetErr := time.Parse("2006-01-02T15:04:05Z-0700", value); assetErr == nil {
+			return assetDate, nil
+		}
+	}
+	return date, err
+}
+
+// returns all records of the object matching the filter, once each by keyField. Filter values are queried in chunks
+// within Marketo's filter limits.
+func queryObjects(client *marketoclient.Client, object string, filterType string, filterValues []string, fields []string, keyField string) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+	var seen = make(map[string]bool)
+	for _, chunk := range marketoclient.ChunkQueryValues(object, filterType, filterValues, fields) {
+		var moreResult = true
+		token := ""
+		for moreResult {
+			res, err := client.QueryObjects(object, filterType, chunk, fields, token)
+			if err != nil {
+				return nil, err
+			}
+			moreResult = res.MoreResult
+			token = res.NextPageToken
+			if len(res.Result) == 0 {
+				continue
+			}
+			var page []map[string]interface{}
+			err = json.Unmarshal(res.Result, &page)
+			if err != nil {
+				return nil, fmt.Errorf("error unmarshalling %s %w", object, err)
+			}
+			for _, data := range page {
+				key := formatKey(data[keyField])
+				if seen[key] {
+					continue // a record can match several filter values
+				}
+				seen[key] = true
+				records = append(records, data)
+			}
+		}
+	}
+	return records, nil
+}
+
+// returns all assets of the given type returned by listPage, paging through the Asset API by offset.
+func listAssets(asset string, listPage func(offset int) (*minimarketo.Response, error)) ([]map[string]interface{}, error) {
+	var assets []map[string]interface{}
+	for offset := 0; ; offset += marketoclient.MaxAssetsReturn {
+		res, err := listPage(offset)
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Result) == 0 {
+			return assets, nil
+		}
+		var page []map[string]interface{}
+		err = json.Unmarshal(res.Result, &page)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling %s %w", asset, err)
+		}
+		assets = append(assets, page...)
+		if len(page) < marketoclient.MaxAssetsReturn {
+			return assets, nil
+		}
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+func testOpportunity(guid, createdAt, updatedAt, stage string) map[string]interface{} {
+	return map[string]interface{}{
+		"marketoGUID": guid,
+		"createdAt":   createdAt,
+		"updatedAt":   updatedAt,
+		"stage":       stage,
+	}
+}
+
+func TestCombinedQueryIterator(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/opportunities/describe.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []marketoclient.ObjectDescription{{
+			Name:    "opportunity",
+			IDField: "marketoGUID",
+			Fields: []marketoclient.ObjectField{
+				{Name: "marketoGUID"}, {Name: "createdAt"}, {Name: "updatedAt"}, {Name: "stage"}, {Name: "amount"},
+			},
+		}}, "", false)
+	})
+	updated := []map[string]interface{}{
+		testOpportunity("o1", "2022-01-01T00:00:00Z", "2022-01-03T00:00:00Z", "Qualify"),
+		testOpportunity("o2", "2022-01-01T00:00:00Z", "2022-01-02T00:00:00Z", "Qualify"),
+		testOpportunity("o3", "2022-01-04T00:00:00Z", "2022-01-04T00:00:00Z", "Prospect"),
+	}
+	var mu sync.Mutex
+	var polls int
+	var reads []string
+	f.Handle("/rest/v1/opportunities.json", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("filterType") == "marketoGUID" {
+			// updated opportunities are read by marketoGUID with all fields.
+			if q.Get("fields") != "marketoGUID,createdAt,updatedAt,stage" {
+				t.Errorf("unexpected fields %s", q.Get("fields"))
+			}
+			mu.Lock()
+			reads = append(reads, q.Get("filterValues"))
+			mu.Unlock()
+			var result []map[string]interface{}
+			for _, o := range updated {
+				if strings.Contains(","+q.Get("filterValues")+",", ","+o["marketoGUID"].(string)+",") {
+					result = append(result, o)
+				}
+			}
+			marketotest.WriteResult(w, result, "", false)
+			return
+		}
+		if q.Get("filterType") != "externalCompanyId" || q.Get("filterValues") != "acme,initech" {
+			t.Errorf("unexpected filter %s=%s", q.Get("filterType"), q.Get("filterValues"))
+		}
+		mu.Lock()
+		if q.Get("nextPageToken") == "" {
+			polls++ // first page of a poll
+		}
+		first := polls == 1
+		mu.Unlock()
+		// the first poll reads all fields, the following ones the keys and dates only.
+		wantFields := "marketoGUID,createdAt,updatedAt"
+		if first {
+			wantFields += ",stage"
+		}
+		if q.Get("fields") != wantFields {
+			t.Errorf("expected fields %s, got %s", wantFields, q.Get("fields"))
+		}
+		switch {
+		case first && q.Get("nextPageToken") == "":
+			marketotest.WriteResult(w, []map[string]interface{}{
+				testOpportunity("o2", "2022-01-01T00:00:00Z", "2022-01-02T00:00:00Z", "Qualify"),
+			}, "page2", true)
+		case first:
+			marketotest.WriteResult(w, []map[string]interface{}{
+				testOpportunity("o1", "2022-01-01T00:00:00Z", "2022-01-01T00:00:00Z", "Prospect"),
+			}, "", false)
+		default:
+			var result []map[string]interface{}
+			for _, o := range updated {
+				result = append(result, map[string]interface{}{
+					"marketoGUID": o["marketoGUID"], "createdAt": o["createdAt"], "updatedAt": o["updatedAt"],
+				})
+			}
+			marketotest.WriteResult(w, result, "", false)
+		}
+	})
+	client := *f.Client(t)
+	it, err := NewCombinedQueryIterator(ctx, 10*time.Millisecond, client, position.Position{}, marketoclient.QueryObjectOpportunities,
+		"externalCompanyId", []string{"acme", "initech"}, []string{"stage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readAckedRecords(ctx, t, it, 4)
+	want := []struct {
+		operation    sdk.Operation
+		key          string
+		positionType position.IteratorType
+	}{
+		{sdk.OperationSnapshot, "o1", position.TypeSnapshot},
+		{sdk.OperationSnapshot, "o2", position.TypeSnapshot},
+		{sdk.OperationUpdate, "o1", position.TypeCDC},
+		{sdk.OperationCreate, "o3", position.TypeCDC},
+	}
+	for i, w := range want {
+		p, err := position.ParseRecordPosition(records[i].Position)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if records[i].Operation != w.operation || string(records[i].Key.Bytes()) != w.key || p.Type != w.positionType {
+			t.Errorf("expected record %d to be %v of %s at %v position, got %v of %s at %v position", i,
+				w.operation, w.key, w.positionType, records[i].Operation, records[i].Key.Bytes(), p.Type)
+		}
+	}
+	if stage := records[2].Payload.After.(sdk.StructuredData)["stage"]; stage != "Qualify" {
+		t.Errorf("expected updated stage Qualify, got %v", stage)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reads) == 0 || reads[0] != "o1,o3" {
+		t.Errorf("expected the updated opportunities o1,o3 to be read by marketoGUID, got reads %v", reads)
+	}
+}
+
+func TestQueryIterator_ResumeFromPosition(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/opportunities/roles.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []map[string]interface{}{
+			testOpportunity("r1", "2022-01-01T00:00:00Z", "2022-01-02T00:00:00Z", ""),
+			testOpportunity("r2", "2022-01-01T00:00:00Z", "2022-01-02T00:00:00Z", ""),
+			testOpportunity("r3", "2022-01-01T00:00:00Z", "2022-01-03T00:00:00Z", ""),
+		}, "", false)
+	})
+	client := *f.Client(t)
+	p := position.Position{
+		Key:       "r1",
+		UpdatedAt: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC),
+		Type:      position.TypeSnapshot,
+	}
+	it, err := NewQueryIterator(ctx, &client, time.Hour, marketoclient.QueryObjectOpportunityRoles, "leadId", []string{"1"}, []string{"marketoGUID"}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readAckedRecords(ctx, t, it, 2)
+	for i, key := range []string{"r2", "r3"} {
+		if string(records[i].Key.Bytes()) != key || records[i].Operation != sdk.OperationSnapshot {
+			t.Errorf("expected record %d to be a snapshot of %s, got %v of %s", i, key, records[i].Operation, records[i].Key.Bytes())
+		}
+	}
+}
+
+func TestCombinedAssetIterator(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	polls := [][]map[string]interface{}{
+		{
+			{"id": 1, "name": "Webinar", "createdAt": "2022-01-01T00:00:00Z+0000", "updatedAt": "2022-01-01T00:00:00Z+0000"},
+			{"id": 2, "name": "Newsletter", "createdAt": "2022-01-01T00:00:00Z+0000", "updatedAt": "2022-01-02T00:00:00Z+0000"},
+		},
+		{
+			{"id": 2, "name": "Monthly Newsletter", "createdAt": "2022-01-01T00:00:00Z+0000", "updatedAt": "2022-01-03T00:00:00Z+0000"},
+		},
+	}
+	var mu sync.Mutex
+	var poll int
+	f.Handle("/rest/asset/v1/forms.json", func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query(); q.Get("offset") != "0" || q.Get("maxReturn") != "200" {
+			t.Errorf("unexpected paging %s", r.URL.RawQuery)
+		}
+		mu.Lock()
+		result := polls[len(polls)-1]
+		if poll < len(polls) {
+			result = polls[poll]
+		}
+		poll++
+		mu.Unlock()
+		marketotest.WriteResult(w, result, "", false)
+	})
+	client := *f.Client(t)
+	it, err := NewCombinedAssetIterator(ctx, 10*time.Millisecond, time.Hour, client, position.Position{}, marketoclient.AssetForms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readAckedRecords(ctx, t, it, 4)
+	want := []struct {
+		operation sdk.Operation
+		key       string
+	}{
+		{sdk.OperationSnapshot, "1"},
+		{sdk.OperationSnapshot, "2"},
+		{sdk.OperationDelete, "1"},
+		{sdk.OperationUpdate, "2"},
+	}
+	for i, w := range want {
+		if records[i].Operation != w.operation || string(records[i].Key.Bytes()) != w.key {
+			t.Errorf("expected record %d to be %v of %s, got %v of %s", i, w.operation, w.key, records[i].Operation, records[i].Key.Bytes())
+		}
+	}
+	// the delete keeps the position of the last record read.
+	p, err := position.ParseRecordPosition(records[2].Position)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Key != "2" || !p.UpdatedAt.Equal(time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected delete position at record 2, got %+v", p)
+	}
+}
+
+func TestAssetIterator_UpdatedSince(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	var mu sync.Mutex
+	var filters []string
+	f.Handle("/rest/asset/v1/programs.json", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		q := r.URL.Query()
+		filters = append(filters, q.Get("earliestUpdatedAt"))
+		if q.Get("earliestUpdatedAt") == "" {
+			marketotest.WriteResult(w, []map[string]interface{}{
+				{"id": 1, "name": "Webinar", "createdAt": "2022-01-01T00:00:00Z+0000", "updatedAt": "2022-01-01T00:00:00Z+0000"},
+				{"id": 2, "name": "Newsletter", "createdAt": "2022-01-01T00:00:00Z+0000", "updatedAt": "2022-01-02T00:00:00Z+0000"},
+			}, "", false)
+			return
+		}
+		if q.Get("latestUpdatedAt") == "" {
+			t.Errorf("expected latestUpdatedAt with earliestUpdatedAt, got %s", r.URL.RawQuery)
+		}
+		marketotest.WriteResult(w, []map[string]interface{}{
+			{"id": 2, "name": "Monthly Newsletter", "createdAt": "2022-01-01T00:00:00Z+0000", "updatedAt": "2022-01-03T00:00:00Z+0000"},
+		}, "", false)
+	})
+	client := *f.Client(t)
+	it, err := NewAssetIterator(ctx, &client, 10*time.Millisecond, time.Hour, marketoclient.AssetPrograms, "id", position.Position{Type: position.TypeSnapshot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	// programs missing from the filtered listing are not deleted.
+	records := readAckedRecords(ctx, t, it, 3)
+	want := []struct {
+		operation sdk.Operation
+		key       string
+	}{
+		{sdk.OperationSnapshot, "1"},
+		{sdk.OperationSnapshot, "2"},
+		{sdk.OperationUpdate, "2"},
+	}
+	for i, w := range want {
+		if records[i].Operation != w.operation || string(records[i].Key.Bytes()) != w.key {
+			t.Errorf("expected record %d to be %v of %s, got %v of %s", i, w.operation, w.key, records[i].Operation, records[i].Key.Bytes())
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(filters) < 2 || filters[0] != "" || filters[1] != "2022-01-02T00:00:00Z" {
+		t.Errorf("expected a full listing followed by the programs updated since 2022-01-02, got %q", filters)
+	}
+}
+
+func TestAssetIterator_FullRefresh(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/asset/v1/tagTypes.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []map[string]interface{}{{"tagType": "Region", "required": false}}, "", false)
+	})
+	client := *f.Client(t)
+	// every poll is due for a refresh, so unchanged tag types are emitted again.
+	it, err := NewAssetIterator(ctx, &client, 10*time.Millisecond, time.Nanosecond, marketoclient.AssetTagTypes, "tagType", position.Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readAckedRecords(ctx, t, it, 3)
+	for i, rec := range records {
+		if rec.Operation != sdk.OperationSnapshot || string(rec.Key.Bytes()) != "Region" {
+			t.Errorf("expected record %d to be a snapshot of Region, got %v of %s", i, rec.Operation, rec.Key.Bytes())
+		}
+	}
+}
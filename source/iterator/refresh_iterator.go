@@ -0,0 +1,188 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+// record read by the RefreshIterator
+type refreshRecord struct {
+	key       string
+	data      map[string]interface{} // nil if the record isn't returned by the query anymore
+	operation sdk.Operation
+	polledAt  time.Time // time the poll which read the record started
+}
+
+// RefreshIterator polls the records of an object which can only be queried by filter and whose changes can't be
+// tracked by updatedAt, like companies. Every poll queries all records matching the filter values and compares them
+// with the previous poll: new records are emitted as created, records whose payload hash changed as updated and
+// records which are not returned anymore as deleted. Every refreshPeriod, and on the first poll, all records are
+// emitted as snapshot records instead. The hashes are written in the position of the last record of every poll, so
+// after a restart the first poll compares the records with the poll of that position. A restart from a position
+// without hashes, read during a poll, starts with a full refresh.
+type RefreshIterator struct {
+	*poller
+	client        *marketoclient.Client // marketo client
+	object        string                // object to query, like marketoclient.QueryObjectCompanies
+	keyField      string                // field keying the records of the object
+	filterType    string                // field to filter the records by
+	filterValues  []string              // values of the filter field matching the records to read
+	fields        []string              // fields to fetch from marketo
+	refreshPeriod time.Duration         // period of full refreshes
+	hashes        map[string]string     // payload hashes of the records read by the previous poll, by key
+	lastRefresh   time.Time             // start of the latest full refresh
+}
+
+// returns NewRefreshIterator which polls Marketo for records of the object matching the filter, keyed by keyField.
+// The first poll runs right away, and is a full refresh unless p holds the hashes of the records read before.
+func NewRefreshIterator(ctx context.Context, client *marketoclient.Client, pollingPeriod time.Duration, refreshPeriod time.Duration, object string, keyField string, filterType string, filterValues []string, fields []string, p position.Position) (*RefreshIterator, error) {
+	iterator := &RefreshIterator{
+		client:        client,
+		object:        object,
+		keyField:      keyField,
+		filterType:    filterType,
+		filterValues:  filterValues,
+		fields:        fields,
+		refreshPeriod: refreshPeriod,
+	}
+	if p.Refresh != nil {
+		iterator.hashes = p.Refresh.Hashes
+		if iterator.hashes == nil {
+			iterator.hashes = make(map[string]string)
+		}
+		iterator.lastRefresh = p.Refresh.RefreshedAt
+	}
+	iterator.poller = newPoller(ctx, "refresh", pollingPeriod, true, iterator.flushLatestRecords)
+	return iterator, nil
+}
+
+// queries the records matching the filter and pushes the changes since the previous poll to the buffer, ordered
+// by key, or all records if a full refresh is due. Records of the previous poll which are missing are pushed as
+// deleted.
+func (r *RefreshIterator) flushLatestRecords(ctx context.Context, push func(sdk.Record) error) (func(), error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "flushLatestRecords").Logger()
+	logger.Trace().Msg("Starting the flushLatestRecords")
+
+	polledAt := time.Now().UTC()
+	all, err := queryObjects(r.client, r.object, r.filterType, r.filterValues, r.fields, r.keyField)
+	if err != nil {
+		logger.Error().Err(err).Msgf("Error while querying %s", r.object)
+		return nil, fmt.Errorf("error querying %s %w", r.object, err)
+	}
+	refresh := r.hashes == nil || polledAt.Sub(r.lastRefresh) >= r.refreshPeriod
+	var hashes = make(map[string]string, len(all))
+	var records []refreshRecord
+	for _, data := range all {
+		key := formatKey(data[r.keyField])
+		hash, err := payloadHash(data)
+		if err != nil {
+			return nil, err
+		}
+		hashes[key] = hash
+		previous, ok := r.hashes[key]
+		switch {
+		case refresh:
+			records = append(records, refreshRecord{key: key, data: data, operation: sdk.OperationSnapshot, polledAt: polledAt})
+		case !ok:
+			records = append(records, refreshRecord{key: key, data: data, operation: sdk.OperationCreate, polledAt: polledAt})
+		case previous != hash:
+			records = append(records, refreshRecord{key: key, data: data, operation: sdk.OperationUpdate, polledAt: polledAt})
+		}
+	}
+	for key := range r.hashes {
+		if _, ok := hashes[key]; !ok {
+			records = append(records, refreshRecord{key: key, operation: sdk.OperationDelete, polledAt: polledAt})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].key < records[j].key })
+	state := &position.RefreshState{Hashes: hashes, RefreshedAt: r.lastRefresh}
+	if refresh {
+		state.RefreshedAt = polledAt
+	}
+	for i, rec := range records {
+		var recordState *position.RefreshState
+		if i == len(records)-1 {
+			recordState = state
+		}
+		record, err := r.prepareRecord(rec, recordState)
+		if err != nil {
+			return nil, err
+		}
+		err = push(record)
+		if err != nil {
+			return nil, err
+		}
+	}
+	logger.Trace().Msgf("Flushed %d %s", len(records), r.object)
+	return func() {
+		r.hashes = hashes
+		r.lastRefresh = state.RefreshedAt
+	}, nil
+}
+
+// returns record in the format of sdk.Record, with given state in its position if not nil.
+func (r *RefreshIterator) prepareRecord(rec refreshRecord, state *position.RefreshState) (sdk.Record, error) {
+	positionType := position.TypeCDC
+	if rec.operation == sdk.OperationSnapshot {
+		positionType = position.TypeSnapshot
+	}
+	position := position.Position{
+		Key:       rec.key,
+		CreatedAt: rec.polledAt,
+		UpdatedAt: rec.polledAt,
+		Type:      positionType,
+		Refresh:   state,
+	}
+	pos, err := position.ToRecordPosition()
+	if err != nil {
+		return sdk.Record{}, fmt.Errorf("error converting position to record position %w", err)
+	}
+
+	metadata := make(sdk.Metadata)
+	metadata.SetCreatedAt(rec.polledAt)
+	key := sdk.RawData(rec.key)
+	switch rec.operation {
+	case sdk.OperationSnapshot:
+		return sdk.Util.Source.NewRecordSnapshot(pos, metadata, key, sdk.StructuredData(rec.data)), nil
+	case sdk.OperationCreate:
+		return sdk.Util.Source.NewRecordCreate(pos, metadata, key, sdk.StructuredData(rec.data)), nil
+	case sdk.OperationUpdate:
+		return sdk.Util.Source.NewRecordUpdate(pos, metadata, key, nil, sdk.StructuredData(rec.data)), nil
+	default:
+		return sdk.Util.Source.NewRecordDelete(pos, metadata, key), nil
+	}
+}
+
+// returns the hex encoded digest of the JSON encoded payload, the first 8 bytes of its SHA-256 hash, which keeps the
+// hashes of the positions short. Maps are encoded with sorted keys, so equal payloads have equal hashes.
+func payloadHash(data map[string]interface{}) (string, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("error hashing payload %w", err)
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:8]), nil
+}
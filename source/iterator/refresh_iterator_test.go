@@ -0,0 +1,196 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+func TestCombinedRefreshIterator(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/companies/describe.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []marketoclient.ObjectDescription{{
+			Name:         "company",
+			IDField:      "id",
+			DedupeFields: []string{"externalCompanyId"},
+			Fields: []marketoclient.ObjectField{
+				{Name: "id"}, {Name: "externalCompanyId"}, {Name: "company"}, {Name: "industry"},
+			},
+		}}, "", false)
+	})
+	polls := [][]map[string]interface{}{
+		{
+			{"id": 1, "externalCompanyId": "acme", "industry": "Retail"},
+			{"id": 2, "externalCompanyId": "initech", "industry": "Software"},
+		},
+		{
+			{"id": 1, "externalCompanyId": "acme", "industry": "Retail"},
+			{"id": 3, "externalCompanyId": "umbrella", "industry": "Pharma"},
+			{"id": 2, "externalCompanyId": "initech", "industry": "Consulting"},
+		},
+		{
+			{"id": 2, "externalCompanyId": "initech", "industry": "Consulting"},
+			{"id": 3, "externalCompanyId": "umbrella", "industry": "Pharma"},
+		},
+	}
+	var mu sync.Mutex
+	var poll int
+	f.Handle("/rest/v1/companies.json", func(w http.ResponseWriter, r *http.Request) {
+		if fields := r.URL.Query().Get("fields"); fields != "id,createdAt,updatedAt,industry" {
+			t.Errorf("unexpected fields %s", fields)
+		}
+		mu.Lock()
+		result := polls[len(polls)-1]
+		if poll < len(polls) {
+			result = polls[poll]
+		}
+		poll++
+		mu.Unlock()
+		marketotest.WriteResult(w, result, "", false)
+	})
+	client := *f.Client(t)
+	it, err := NewCombinedRefreshIterator(ctx, 10*time.Millisecond, time.Hour, client, position.Position{}, marketoclient.QueryObjectCompanies,
+		"externalCompanyId", []string{"acme", "initech", "umbrella"}, []string{"industry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readAckedRecords(ctx, t, it, 5)
+	want := []struct {
+		operation sdk.Operation
+		key       string
+	}{
+		{sdk.OperationSnapshot, "1"},
+		{sdk.OperationSnapshot, "2"},
+		{sdk.OperationUpdate, "2"},
+		{sdk.OperationCreate, "3"},
+		{sdk.OperationDelete, "1"},
+	}
+	for i, w := range want {
+		if records[i].Operation != w.operation || string(records[i].Key.Bytes()) != w.key {
+			t.Errorf("expected record %d to be %v of %s, got %v of %s", i, w.operation, w.key, records[i].Operation, records[i].Key.Bytes())
+		}
+	}
+	if industry := records[2].Payload.After.(sdk.StructuredData)["industry"]; industry != "Consulting" {
+		t.Errorf("expected updated industry Consulting, got %v", industry)
+	}
+}
+
+func TestRefreshIterator_FullRefresh(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/rest/v1/namedaccounts.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []map[string]interface{}{
+			{"marketoGUID": "a1", "name": "Acme"},
+		}, "", false)
+	})
+	client := *f.Client(t)
+	// every poll is due for a refresh, so unchanged records are emitted again.
+	it, err := NewRefreshIterator(ctx, &client, 10*time.Millisecond, time.Nanosecond, marketoclient.QueryObjectNamedAccounts, "marketoGUID",
+		"name", []string{"Acme"}, []string{"marketoGUID", "name"}, position.Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Stop()
+
+	records := readAckedRecords(ctx, t, it, 2)
+	for i, rec := range records {
+		if rec.Operation != sdk.OperationSnapshot || string(rec.Key.Bytes()) != "a1" {
+			t.Errorf("expected record %d to be a snapshot of a1, got %v of %s", i, rec.Operation, rec.Key.Bytes())
+		}
+	}
+}
+
+func TestRefreshIterator_Restart(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	var mu sync.Mutex
+	companies := []map[string]interface{}{
+		{"id": 1234567, "externalCompanyId": "acme"},
+		{"id": 1234568, "externalCompanyId": "initech"},
+	}
+	f.Handle("/rest/v1/companies.json", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		marketotest.WriteResult(w, companies, "", false)
+	})
+	client := *f.Client(t)
+	open := func(p position.Position) *RefreshIterator {
+		it, err := NewRefreshIterator(ctx, &client, time.Hour, 24*time.Hour, marketoclient.QueryObjectCompanies, "id",
+			"externalCompanyId", []string{"acme", "initech"}, []string{"id", "externalCompanyId"}, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return it
+	}
+	it := open(position.Position{})
+	records := readAckedRecords(ctx, t, it, 2)
+	it.Stop()
+	// large ids are keyed by their digits, not by their exponent form.
+	for i, want := range []string{"1234567", "1234568"} {
+		if got := string(records[i].Key.Bytes()); got != want {
+			t.Errorf("expected record %d to have key %s, got %s", i, want, got)
+		}
+	}
+
+	first, err := position.ParseRecordPosition(records[0].Position)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Refresh != nil {
+		t.Error("expected no refresh state in the position of the first record of the poll")
+	}
+	last, err := position.ParseRecordPosition(records[1].Position)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Refresh == nil || len(last.Refresh.Hashes) != 2 {
+		t.Fatalf("expected the hashes of 2 records in the position of the last record, got %+v", last.Refresh)
+	}
+
+	// after a restart from the last position, the record deleted while stopped is emitted as deleted and the
+	// unchanged record is not emitted again.
+	mu.Lock()
+	companies = companies[1:]
+	mu.Unlock()
+	it = open(last)
+	defer it.Stop()
+	records = readAckedRecords(ctx, t, it, 1)
+	if records[0].Operation != sdk.OperationDelete || string(records[0].Key.Bytes()) != "1234567" {
+		t.Errorf("expected a delete of 1234567, got %v of %s", records[0].Operation, records[0].Key.Bytes())
+	}
+	deleted, err := position.ParseRecordPosition(records[0].Position)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Refresh == nil || len(deleted.Refresh.Hashes) != 1 || !deleted.Refresh.RefreshedAt.Equal(last.Refresh.RefreshedAt) {
+		t.Errorf("expected the hashes of 1 record and the refresh time of the restored state, got %+v", deleted.Refresh)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if it.HasNext(ctx) {
+		t.Error("expected no record of the unchanged company")
+	}
+}
@@ -17,12 +17,14 @@ package iterator
 import (
 	"context"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	sdk "github.com/conduitio/conduit-connector-sdk"
@@ -40,52 +42,188 @@ const (
 // to handle snapshot iterator
 type SnapshotIterator struct {
 	client          *marketoclient.Client
-	initialDate     time.Time        // holds the initial date of the snapshot
-	fields          []string         // holds the fields to be returned from the API
-	endpoint        string           // holds the endpoint of the API
-	exportID        string           // holds the current processin exportId
-	iteratorCount   int              // holds the number of snapshots to be created
-	errChan         chan error       // used to send errors
-	csvReader       chan *csv.Reader // holds bulk data returned from the API in CSV format
-	data            chan []string    // holds the data to be flushed to the conduit
-	hasData         chan struct{}    // used to signal that the iterator has data
-	lastMaxModified time.Time        // holds the last maxModified date of the snapshot
+	object          string                             // holds the exported object, see marketoclient.ExportObjectLeads
+	activityTypeIDs []int                              // holds the activity type ids to export, all if empty
+	activityTypes   map[int]marketoclient.ActivityType // holds the activity types of the exported activities
+	programIDs      []int                              // holds the ids of the programs to export the members of
+	customObject    string                             // holds the API name of the exported custom object
+	dedupeFields    []string                           // holds the fields keying the exported custom object records
+	initialDate     time.Time                          // holds the initial date of the snapshot
+	fields          []string                           // holds the fields to be returned from the API
+	endpoint        string                             // holds the endpoint of the API
+	exportID        string                             // holds the current processin exportId
+	mu              sync.Mutex                         // guards exportID, which stop reads while pulling
+	iteratorCount   int                                // holds the number of snapshots to be created
+	errChan         chan error                         // used to send errors
+	done            chan struct{}                      // closed once pulling and flushing are finished
+	csvReader       chan exportFile                    // holds bulk data returned from the API in CSV format
+	data            chan exportRow                     // holds the data to be flushed to the conduit
+	hasData         chan struct{}                      // used to signal that the iterator has data
+	lastMaxModified time.Time                          // holds the last maxModified date of the snapshot
+}
+
+// file of an export job, read by the flush goroutine while the next export is pulled.
+type exportFile struct {
+	reader  *csv.Reader
+	columns []string // header of the file
+}
+
+// row of an exported file, with the columns of its file.
+type exportRow struct {
+	columns []string
+	values  []string
+}
+
+// returns the values of the row by column.
+func (r exportRow) fields() map[string]interface{} {
+	var fields = make(map[string]interface{}, len(r.columns))
+	for i, column := range r.columns {
+		if i < len(r.values) {
+			fields[column] = r.values[i]
+		}
+	}
+	return fields
 }
 
 // returns NewSnapshotIterator with supplied parameters, also initiates the pull and flush goroutines.
 func NewSnapshotIterator(ctx context.Context, endpoint string, fields []string, client marketoclient.Client, p position.Position, initialDate time.Time) (*SnapshotIterator, error) {
 	logger := sdk.Logger(ctx).With().Str("Method", "NewSnapshotIterator").Logger()
 	logger.Trace().Msg("Starting the NewSnapshotIterator")
-	var err error
 	s := &SnapshotIterator{
 		endpoint:        endpoint,
 		client:          &client,
+		object:          marketoclient.ExportObjectLeads,
 		fields:          fields,
-		errChan:         make(chan error),
-		data:            make(chan []string, 100),
+		errChan:         make(chan error, 1),
+		data:            make(chan exportRow, 100),
 		hasData:         make(chan struct{}, 100),
 		lastMaxModified: time.Time{},
 		initialDate:     initialDate,
 	}
-	eg, ctx := errgroup.WithContext(ctx)
-	if s.initialDate.IsZero() {
-		s.initialDate, err = s.getLastProcessedDate(ctx, p)
+	err := s.start(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// returns NewActivitySnapshotIterator which exports activities created since initialDate, also initiates the pull
+// and flush goroutines. Only activities of activityTypeIDs are exported if any are given, activityTypes holds the
+// data types of the exported activities.
+func NewActivitySnapshotIterator(ctx context.Context, endpoint string, client marketoclient.Client, p position.Position, initialDate time.Time, activityTypeIDs []int, activityTypes map[int]marketoclient.ActivityType) (*SnapshotIterator, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "NewActivitySnapshotIterator").Logger()
+	logger.Trace().Msg("Starting the NewActivitySnapshotIterator")
+	s := &SnapshotIterator{
+		endpoint:        endpoint,
+		client:          &client,
+		object:          marketoclient.ExportObjectActivities,
+		activityTypeIDs: activityTypeIDs,
+		activityTypes:   activityTypes,
+		errChan:         make(chan error, 1),
+		data:            make(chan exportRow, 100),
+		hasData:         make(chan struct{}, 100),
+		lastMaxModified: time.Time{},
+		initialDate:     initialDate,
+	}
+	if !reflect.ValueOf(p).IsZero() {
+		// resume after the last exported activity.
+		s.initialDate = time.Time{}
+	}
+	err := s.start(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// returns NewProgramMemberSnapshotIterator which exports the members of the given programs with requested fields,
+// one export per program, also initiates the pull and flush goroutines. If position is set, the export resumes
+// from the program of the position.
+func NewProgramMemberSnapshotIterator(ctx context.Context, endpoint string, fields []string, client marketoclient.Client, p position.Position, programIDs []int) (*SnapshotIterator, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "NewProgramMemberSnapshotIterator").Logger()
+	logger.Trace().Msg("Starting the NewProgramMemberSnapshotIterator")
+	s := &SnapshotIterator{
+		endpoint:        endpoint,
+		client:          &client,
+		object:          marketoclient.ExportObjectProgramMembers,
+		fields:          fields,
+		programIDs:      programIDs,
+		errChan:         make(chan error, 1),
+		data:            make(chan exportRow, 100),
+		hasData:         make(chan struct{}, 100),
+		lastMaxModified: time.Time{},
+	}
+	if programID, _, found := cut(p.Key, ":"); found {
+		for i, id := range programIDs {
+			if strconv.Itoa(id) == programID {
+				s.programIDs = programIDs[i:]
+				break
+			}
+		}
+	}
+	err := s.start(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// returns NewCustomObjectSnapshotIterator which exports the records of the given custom object updated since
+// initialDate with requested fields, also initiates the pull and flush goroutines. Records are keyed by the dedupe
+// fields of the custom object.
+func NewCustomObjectSnapshotIterator(ctx context.Context, endpoint string, fields []string, client marketoclient.Client, p position.Position, initialDate time.Time, customObject marketoclient.ObjectDescription) (*SnapshotIterator, error) {
+	logger := sdk.Logger(ctx).With().Str("Method", "NewCustomObjectSnapshotIterator").Logger()
+	logger.Trace().Msg("Starting the NewCustomObjectSnapshotIterator")
+	s := &SnapshotIterator{
+		endpoint:        endpoint,
+		client:          &client,
+		object:          marketoclient.ExportObjectCustomObject(customObject.Name),
+		customObject:    customObject.Name,
+		dedupeFields:    customObjectKeyFields(customObject),
+		fields:          fields,
+		errChan:         make(chan error, 1),
+		data:            make(chan exportRow, 100),
+		hasData:         make(chan struct{}, 100),
+		lastMaxModified: time.Time{},
+		initialDate:     initialDate,
 	}
+	err := s.start(ctx, p)
 	if err != nil {
-		logger.Error().Err(err).Msg("Error getting initial date")
-		return nil, fmt.Errorf("error getting initial date: %w", err)
+		return nil, err
+	}
+	return s, nil
+}
+
+// computes the export windows from the initial date or the supplied position and starts the pull and flush
+// goroutines.
+func (s *SnapshotIterator) start(ctx context.Context, p position.Position) error {
+	logger := sdk.Logger(ctx).With().Str("Method", "start").Logger()
+	var err error
+	eg, ctx := errgroup.WithContext(ctx)
+	if s.object == marketoclient.ExportObjectProgramMembers {
+		s.iteratorCount = len(s.programIDs)
+	} else {
+		if s.initialDate.IsZero() {
+			s.initialDate, err = s.getLastProcessedDate(ctx, p)
+		}
+		if err != nil {
+			logger.Error().Err(err).Msg("Error getting initial date")
+			return fmt.Errorf("error getting initial date: %w", err)
+		}
+		startDateDuration := time.Since(s.initialDate)
+		s.iteratorCount = int(startDateDuration.Hours()/MaximumHoursGap) + 1
 	}
-	startDateDuration := time.Since(s.initialDate)
-	s.iteratorCount = int(startDateDuration.Hours()/MaximumHoursGap) + 1
 	logger.Info().Msgf("Creating %d snapshots one by one", s.iteratorCount)
-	s.csvReader = make(chan *csv.Reader, s.iteratorCount)
+	s.csvReader = make(chan exportFile, s.iteratorCount)
 	eg.Go(func() error {
 		return s.pull(ctx)
 	})
 	eg.Go(func() error {
 		return s.flush(ctx)
 	})
+	s.done = make(chan struct{})
 	go func() {
+		defer close(s.done)
 		err := eg.Wait()
 		logger.Trace().Msg("Errgroup wait finished")
 		if err != nil {
@@ -93,7 +231,7 @@ func NewSnapshotIterator(ctx context.Context, endpoint string, fields []string,
 			s.errChan <- err
 		}
 	}()
-	return s, nil
+	return nil
 }
 
 // returns true if there are more records to be read from the iterator's buffer, otherwise returns false.
@@ -155,11 +293,14 @@ func (s *SnapshotIterator) Next(ctx context.Context) (sdk.Record, error) {
 func (s *SnapshotIterator) stop(ctx context.Context) error {
 	logger := sdk.Logger(ctx).With().Str("Method", "Stop").Logger()
 	logger.Trace().Msg("Starting the SnapshotIterator Stop method")
-	if s.exportID == "" {
+	s.mu.Lock()
+	exportID := s.exportID
+	s.mu.Unlock()
+	if exportID == "" {
 		logger.Trace().Msg("No exportId to cancel")
 		return nil
 	}
-	err := s.client.CancelExportLeads(s.exportID)
+	err := s.client.CancelExport(s.object, exportID)
 	if errors.Is(err, marketoclient.ErrCannotCancel) {
 		logger.Err(err).Msg("Cannot cancel export")
 		return nil
@@ -178,14 +319,28 @@ func (s *SnapshotIterator) pull(ctx context.Context) error {
 	var startDate, endDate time.Time
 	date := s.initialDate
 	for i := 0; i < s.iteratorCount; i++ {
+		if s.object == marketoclient.ExportObjectProgramMembers {
+			programID := s.programIDs[i]
+			logger.Info().Msgf("Pulling members of program %d", programID)
+			err := s.getExport(ctx, func() (string, error) {
+				return s.client.CreateExportProgramMembers(programID, s.fields)
+			})
+			if err != nil {
+				logger.Error().Err(err).Msgf("Error while getting snapshot of members of program %d", programID)
+				return fmt.Errorf("error while getting snapshot of members of program %d: %w", programID, err)
+			}
+			continue
+		}
 		startDate = date
 		endDate = date.Add(time.Hour * time.Duration(MaximumHoursGap)).Add(-1 * time.Second)
 		date = date.Add(time.Hour * time.Duration(MaximumHoursGap))
 		logger.Info().Msgf("Pulling data from %s to %s", startDate.Format(time.RFC3339), endDate.Format(time.RFC3339))
-		err := s.getLeads(ctx, startDate, endDate)
+		err := s.getExport(ctx, func() (string, error) {
+			return s.createExport(startDate.UTC().Format(time.RFC3339), endDate.UTC().Format(time.RFC3339))
+		})
 		if err != nil {
-			logger.Error().Err(err).Msg("Error while getting snapshot of leads")
-			return fmt.Errorf("error while getting snapshot of leads: %w", err)
+			logger.Error().Err(err).Msgf("Error while getting snapshot of %s", s.object)
+			return fmt.Errorf("error while getting snapshot of %s: %w", s.object, err)
 		}
 	}
 	return nil
@@ -199,9 +354,9 @@ func (s *SnapshotIterator) flush(ctx context.Context) error {
 		close(s.data)
 		close(s.hasData)
 	}()
-	for reader := range s.csvReader {
+	for file := range s.csvReader {
 		for {
-			rec, err := reader.Read()
+			rec, err := file.reader.Read()
 			if err == io.EOF {
 				logger.Trace().Msg("EOF reached")
 				break
@@ -210,25 +365,27 @@ func (s *SnapshotIterator) flush(ctx context.Context) error {
 				logger.Err(err).Msg("Error while reading csv")
 				return fmt.Errorf("error while reading csv: %s", err.Error())
 			}
-			s.data <- rec
+			s.data <- exportRow{columns: file.columns, values: rec}
 			s.hasData <- struct{}{}
 		}
 	}
 	return nil
 }
 
-// requests the data from the Marketo API and pushes it to the csvReader channel.
-func (s *SnapshotIterator) getLeads(ctx context.Context, startDate, endDate time.Time) error {
-	logger := sdk.Logger(ctx).With().Str("Method", "getLeads").Logger()
-	logger.Trace().Msg("Starting the getLeads method")
-	var err error
-	s.exportID, err = s.client.CreateExportLeads(s.fields, startDate.UTC().Format(time.RFC3339), endDate.UTC().Format(time.RFC3339))
+// requests the data of the export job created by create from the Marketo API and pushes it to the csvReader channel.
+func (s *SnapshotIterator) getExport(ctx context.Context, create func() (string, error)) error {
+	logger := sdk.Logger(ctx).With().Str("Method", "getExport").Logger()
+	logger.Trace().Msg("Starting the getExport method")
+	exportID, err := create()
+	s.mu.Lock()
+	s.exportID = exportID
+	s.mu.Unlock()
 	if err != nil {
 		logger.Error().Err(err).Msg("Error while creating export")
 		return fmt.Errorf("error while creating export: %w", err)
 	}
 	err = marketoclient.WithRetry(ctx, func() (bool, error) {
-		_, err := s.client.EnqueueExportLeads(s.exportID)
+		_, err := s.client.EnqueueExport(s.object, exportID)
 		if errors.Is(err, marketoclient.ErrEnqueueLimit) {
 			logger.Trace().Msg("Enqueue limit reached")
 			return true, nil
@@ -245,7 +402,7 @@ func (s *SnapshotIterator) getLeads(ctx context.Context, startDate, endDate time
 	}
 
 	err = marketoclient.WithRetry(ctx, func() (bool, error) {
-		statusResult, err := s.client.StatusOfExportLeads(s.exportID)
+		statusResult, err := s.client.StatusOfExport(s.object, exportID)
 		if err != nil {
 			logger.Err(err).Msg("Error while getting status of export")
 			return false, err
@@ -260,35 +417,57 @@ func (s *SnapshotIterator) getLeads(ctx context.Context, startDate, endDate time
 		return true, nil
 	})
 	if errors.Is(err, marketoclient.ErrZeroRecords) {
-		logger.Trace().Msgf("Skipping,Zero records found for %s", s.exportID)
+		logger.Trace().Msgf("Skipping,Zero records found for %s", exportID)
 		return nil
 	}
 	if err != nil {
 		logger.Err(err).Msg("Error while getting status of export")
 		return err
 	}
-	bytes, err := s.client.FileExportLeads(ctx, s.endpoint, s.exportID)
+	bytes, err := s.client.FileExport(ctx, s.endpoint, s.object, exportID)
 	if err != nil {
 		logger.Err(err).Msg("Error while getting file of export")
 		return err
 	}
 	csvReader := csv.NewReader(strings.NewReader(string(*bytes)))
-	_, err = csvReader.Read() // removing the header
+	header, err := csvReader.Read() // removing the header
 	if err != nil {
 		logger.Err(err).Msg("Error while reading csv")
 		return err
 	}
+	// columns of activity files are not requested as fields, program member files may order them differently, so the
+	// rows are read with the header of their file.
 	logger.Trace().Msg("Sending csv reader to channel")
-	s.csvReader <- csvReader
+	s.csvReader <- exportFile{reader: csvReader, columns: header}
 
 	return nil
 }
 
+// creates the export job of the iterator's object for the given time range.
+func (s *SnapshotIterator) createExport(startDate, endDate string) (string, error) {
+	if s.object == marketoclient.ExportObjectActivities {
+		return s.client.CreateExportActivities(s.activityTypeIDs, startDate, endDate)
+	}
+	if s.customObject != "" {
+		return s.client.CreateExportCustomObjects(s.customObject, s.fields, startDate, endDate)
+	}
+	return s.client.CreateExportLeads(s.fields, startDate, endDate)
+}
+
 // prepares and returns record in sdk.Record format. If process fails for any reason, it returns error.
-func (s *SnapshotIterator) prepareRecord(ctx context.Context, data []string) (sdk.Record, error) {
+func (s *SnapshotIterator) prepareRecord(ctx context.Context, row exportRow) (sdk.Record, error) {
 	logger := sdk.Logger(ctx).With().Str("Method", "prepareRecord").Logger()
 	logger.Trace().Msg("Starting the prepareRecord method")
-	var dataMap = marketoclient.GetDataMap(s.fields, data)
+	switch s.object {
+	case marketoclient.ExportObjectActivities:
+		return s.prepareActivityRecord(row)
+	case marketoclient.ExportObjectProgramMembers:
+		return s.prepareProgramMemberRecord(row)
+	}
+	if s.customObject != "" {
+		return s.prepareCustomObjectRecord(row)
+	}
+	var dataMap = marketoclient.GetDataMap(s.fields, row.values)
 	createdAt, err := time.Parse(time.RFC3339, fmt.Sprintf("%s", dataMap["createdAt"]))
 	if err != nil {
 		logger.Err(err).Msg("Error while parsing createdAt")
@@ -324,6 +503,119 @@ func (s *SnapshotIterator) prepareRecord(ctx context.Context, data []string) (sd
 	), nil
 }
 
+// prepares and returns activity record in sdk.Record format, keyed by the activity id.
+func (s *SnapshotIterator) prepareActivityRecord(row exportRow) (sdk.Record, error) {
+	activity := row.fields()
+	// the marketoGUID of an activity is its id.
+	key := fmt.Sprint(activity["marketoGUID"])
+	id, err := strconv.Atoi(key)
+	if err != nil {
+		return sdk.Record{}, fmt.Errorf("error parsing activity id %q %w", key, err)
+	}
+	activity["id"] = id
+	if attributes, ok := activity["attributes"].(string); ok && attributes != "" {
+		var parsed map[string]interface{}
+		err := json.Unmarshal([]byte(attributes), &parsed)
+		if err != nil {
+			return sdk.Record{}, fmt.Errorf("error parsing attributes of activity %s %w", key, err)
+		}
+		activity["attributes"] = parsed
+	}
+	activityDate, err := time.Parse(time.RFC3339, fmt.Sprint(activity["activityDate"]))
+	if err != nil {
+		return sdk.Record{}, fmt.Errorf("error parsing activityDate %w", err)
+	}
+	if activityDate.After(s.lastMaxModified) {
+		s.lastMaxModified = activityDate
+	}
+	position := position.Position{
+		Key:       key,
+		CreatedAt: activityDate,
+		UpdatedAt: activityDate,
+		Type:      position.TypeSnapshot,
+	}
+	pos, err := position.ToRecordPosition()
+	if err != nil {
+		return sdk.Record{}, fmt.Errorf("error converting position to record position %w", err)
+	}
+
+	typed := typedActivity(s.activityTypes, activity)
+	return sdk.Util.Source.NewRecordSnapshot(
+		pos, activityMetadata(key, activityDate, typed), sdk.RawData(key), sdk.StructuredData(typed),
+	), nil
+}
+
+// prepares and returns program member record in sdk.Record format, keyed by program id and lead id.
+func (s *SnapshotIterator) prepareProgramMemberRecord(row exportRow) (sdk.Record, error) {
+	member := row.fields()
+	programID, err := strconv.Atoi(fmt.Sprint(member["programId"]))
+	if err != nil {
+		return sdk.Record{}, fmt.Errorf("error parsing programId %w", err)
+	}
+	leadID, err := strconv.Atoi(fmt.Sprint(member["leadId"]))
+	if err != nil {
+		return sdk.Record{}, fmt.Errorf("error parsing leadId %w", err)
+	}
+	member["programId"], member["leadId"] = programID, leadID
+	updatedAt, err := time.Parse(time.RFC3339, fmt.Sprint(member["updatedAt"]))
+	if err != nil {
+		return sdk.Record{}, fmt.Errorf("error parsing updatedAt %w", err)
+	}
+	if updatedAt.After(s.lastMaxModified) {
+		s.lastMaxModified = updatedAt
+	}
+	position := position.Position{
+		Key:       programMemberKey(programID, leadID),
+		CreatedAt: updatedAt,
+		UpdatedAt: updatedAt,
+		Type:      position.TypeSnapshot,
+	}
+	pos, err := position.ToRecordPosition()
+	if err != nil {
+		return sdk.Record{}, fmt.Errorf("error converting position to record position %w", err)
+	}
+
+	return sdk.Util.Source.NewRecordSnapshot(
+		pos, programMemberMetadata(programID, leadID, updatedAt), programMemberRecordKey(programID, leadID), sdk.StructuredData(member),
+	), nil
+}
+
+// prepares and returns custom object record in sdk.Record format, keyed by the dedupe fields of the custom object.
+func (s *SnapshotIterator) prepareCustomObjectRecord(row exportRow) (sdk.Record, error) {
+	record := row.fields()
+	updatedAt, err := time.Parse(time.RFC3339, fmt.Sprint(record["updatedAt"]))
+	if err != nil {
+		return sdk.Record{}, fmt.Errorf("error parsing updatedAt %w", err)
+	}
+	createdAt, err := time.Parse(time.RFC3339, fmt.Sprint(record["createdAt"]))
+	if err != nil {
+		createdAt = updatedAt
+	}
+	if updatedAt.After(s.lastMaxModified) {
+		s.lastMaxModified = updatedAt
+	}
+	var key = make(sdk.StructuredData, len(s.dedupeFields))
+	for _, field := range s.dedupeFields {
+		key[field] = record[field]
+	}
+	position := position.Position{
+		Key:       string(key.Bytes()),
+		CreatedAt: updatedAt,
+		UpdatedAt: updatedAt,
+		Type:      position.TypeSnapshot,
+	}
+	pos, err := position.ToRecordPosition()
+	if err != nil {
+		return sdk.Record{}, fmt.Errorf("error converting position to record position %w", err)
+	}
+
+	metadata := make(sdk.Metadata)
+	metadata.SetCreatedAt(createdAt)
+	metadata["updatedAt"] = strconv.FormatInt(updatedAt.UnixNano(), 10)
+
+	return sdk.Util.Source.NewRecordSnapshot(pos, metadata, key, sdk.StructuredData(record)), nil
+}
+
 // returns Last date from the supplied position.if p is zero value, then it queries least date from the database.
 func (s *SnapshotIterator) getLastProcessedDate(ctx context.Context, p position.Position) (time.Time, error) {
 	logger := sdk.Logger(ctx).With().Str("Method", "getInitialDate").Logger()
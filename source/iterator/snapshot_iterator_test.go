@@ -0,0 +1,168 @@
+// Copyright © 2022 Meroxa, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	sdk "github.com/conduitio/conduit-connector-sdk"
+	"github.com/rustiever/conduit-connector-marketo/internal/marketotest"
+	marketoclient "github.com/rustiever/conduit-connector-marketo/marketo-client"
+	"github.com/rustiever/conduit-connector-marketo/source/position"
+)
+
+var testCustomObject = marketoclient.ObjectDescription{
+	Name:         "subscription_c",
+	IDField:      "marketoGUID",
+	DedupeFields: []string{"subscriptionId"},
+}
+
+func TestSnapshotIterator_ErrorWithoutReader(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/bulk/v1/customobjects/subscription_c/export/create.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":"1029","message":"Export daily quota exceeded"}]}`))
+	})
+	client := *f.Client(t)
+	s, err := NewCustomObjectSnapshotIterator(ctx, f.URL, []string{"subscriptionId"}, client, position.Position{},
+		time.Now().Add(-time.Hour), testCustomObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the failed export finishes the iterator even if nothing reads the error yet.
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the iterator to finish after the export failed")
+	}
+	if _, err := s.Next(ctx); err == nil {
+		t.Error("expected Next to return the export error")
+	}
+}
+
+func TestSnapshotIterator_StopWhilePulling(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	f.Handle("/bulk/v1/customobjects/subscription_c/export/create.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, []marketoclient.CreateExportResult{{ExportID: "export-1"}}, "", false)
+	})
+	f.Handle("/bulk/v1/customobjects/subscription_c/export/export-1/enqueue.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, nil, "", false)
+	})
+	statusRequested, release := make(chan struct{}), make(chan struct{})
+	f.Handle("/bulk/v1/customobjects/subscription_c/export/export-1/status.json", func(w http.ResponseWriter, r *http.Request) {
+		close(statusRequested)
+		<-release
+		marketotest.WriteResult(w, []marketoclient.StatusOfExportResult{{Status: "Completed"}}, "", false)
+	})
+	f.Handle("/bulk/v1/customobjects/subscription_c/export/export-1/cancel.json", func(w http.ResponseWriter, r *http.Request) {
+		marketotest.WriteResult(w, nil, "", false)
+	})
+	client := *f.Client(t)
+	s, err := NewCustomObjectSnapshotIterator(ctx, f.URL, []string{"subscriptionId"}, client, position.Position{},
+		time.Now().Add(-time.Hour), testCustomObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stop runs concurrently with the pull setting the export id.
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-statusRequested:
+				return
+			default:
+			}
+			if err := s.stop(ctx); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	<-stopped
+	if err := s.stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if got := len(f.RequestsTo("/bulk/v1/customobjects/subscription_c/export/export-1/cancel.json")); got == 0 {
+		t.Error("expected the running export to be canceled")
+	}
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the iterator to finish")
+	}
+}
+
+func TestSnapshotIterator_ExportHeaders(t *testing.T) {
+	ctx := context.Background()
+	f := marketotest.NewServer(t)
+	// the files of the exports of the members of both programs order their columns differently.
+	files := map[int]string{
+		1001: "leadId,programId,status,updatedAt\n11,1001,Registered,2022-10-01T10:00:00Z\n12,1001,Attended,2022-10-01T11:00:00Z\n",
+		1002: "updatedAt,status,programId,leadId\n2022-10-02T10:00:00Z,Invited,1002,21\n",
+	}
+	f.Handle("/bulk/v1/program/members/export/create.json", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Filter struct {
+				ProgramID int `json:"programId"`
+			} `json:"filter"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		marketotest.WriteResult(w, []marketoclient.CreateExportResult{{ExportID: fmt.Sprint(body.Filter.ProgramID)}}, "", false)
+	})
+	for programID, file := range files {
+		file := file
+		prefix := fmt.Sprintf("/bulk/v1/program/members/export/%d/", programID)
+		f.Handle(prefix+"enqueue.json", func(w http.ResponseWriter, r *http.Request) {
+			marketotest.WriteResult(w, nil, "", false)
+		})
+		f.Handle(prefix+"status.json", func(w http.ResponseWriter, r *http.Request) {
+			marketotest.WriteResult(w, []marketoclient.StatusOfExportResult{{Status: "Completed", NumberOfRecords: 1}}, "", false)
+		})
+		f.Handle(prefix+"file.json", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(file))
+		})
+	}
+	s, err := NewProgramMemberSnapshotIterator(ctx, f.URL, []string{"leadId", "programId", "status", "updatedAt"}, *f.Client(t),
+		position.Position{}, []int{1001, 1002})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var members []string
+	for s.HasNext(ctx) {
+		rec, err := s.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := rec.Payload.After.(sdk.StructuredData)
+		members = append(members, fmt.Sprintf("%v/%v:%v", data["programId"], data["leadId"], data["status"]))
+	}
+	sort.Strings(members)
+	expected := "[1001/11:Registered 1001/12:Attended 1002/21:Invited]"
+	if fmt.Sprint(members) != expected {
+		t.Errorf("expected the rows read with the header of their file %s, got %v", expected, members)
+	}
+}
@@ -33,6 +33,24 @@ type Position struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Type      IteratorType
+	// PageToken is the Marketo activity paging token of the page the record
+	// was read from. CDC resumes from this page, skipping records up to Key.
+	PageToken string `json:",omitempty"`
+	// Batch is the index of the batch of activity types the page of an
+	// activity was requested for, see iterator.ActivityIterator.
+	Batch int `json:",omitempty"`
+	// PollToken is the paging token the poll of an activity started from,
+	// set if batches of activity types follow the batch of the activity.
+	PollToken string `json:",omitempty"`
+	// Refresh is the state of a refresh iterator after the poll the record
+	// was read by, set on the last record of the poll only.
+	Refresh *RefreshState `json:",omitempty"`
+}
+
+// RefreshState is the state a refresh iterator compares its next poll with.
+type RefreshState struct {
+	Hashes      map[string]string // payload digests of the records read by the poll, by key
+	RefreshedAt time.Time         // start of the latest full refresh
 }
 
 func (p Position) ToRecordPosition() (sdk.Position, error) {
@@ -60,3 +78,35 @@ func ConvertToCDCPosition(p sdk.Position) (sdk.Position, error) {
 	cdcPos.Type = TypeCDC
 	return cdcPos.ToRecordPosition()
 }
+
+// CompositePosition is the position of a source reading several objects. It holds the position of the last record
+// read of every object, so every object resumes from its own position, and the object of the record it belongs to.
+type CompositePosition struct {
+	Object    string
+	Positions map[string]Position
+}
+
+func (p CompositePosition) ToRecordPosition() (sdk.Position, error) {
+	return json.Marshal(p)
+}
+
+// ParseCompositePosition parses the position of a source reading several objects. A position of a single object,
+// written before objects were read together, is returned as the position of the given object.
+func ParseCompositePosition(p sdk.Position, object string) (CompositePosition, error) {
+	if p == nil {
+		return CompositePosition{}, nil
+	}
+	var pos CompositePosition
+	err := json.Unmarshal(p, &pos)
+	if err != nil {
+		return CompositePosition{}, err
+	}
+	if pos.Positions == nil {
+		single, err := ParseRecordPosition(p)
+		if err != nil {
+			return CompositePosition{}, err
+		}
+		return CompositePosition{Object: object, Positions: map[string]Position{object: single}}, nil
+	}
+	return pos, nil
+}
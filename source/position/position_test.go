@@ -15,6 +15,7 @@
 package position
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
@@ -57,6 +58,18 @@ func Test_ParseRecordPosition(t *testing.T) {
 				CreatedAt: time.Date(2020, 1, 1, 4, 12, 27, 0, time.UTC),
 			},
 		},
+		{
+			name:    "cdc type position with page token",
+			wantErr: false,
+			in:      []byte("{\"key\":\"42\",\"createdAt\":\"2020-01-01T04:12:27Z\",\"updatedAt\":\"2020-01-01T04:12:27Z\",\"type\":1,\"pageToken\":\"GIYDAOBNGEYS2MBWKQYDAORQGA5DAMBOGAYDAKZQGAYDALBQ\"}"),
+			out: Position{
+				Key:       "42",
+				Type:      TypeCDC,
+				UpdatedAt: time.Date(2020, 1, 1, 4, 12, 27, 0, time.UTC),
+				CreatedAt: time.Date(2020, 1, 1, 4, 12, 27, 0, time.UTC),
+				PageToken: "GIYDAOBNGEYS2MBWKQYDAORQGA5DAMBOGAYDAKZQGAYDALBQ",
+			},
+		},
 		{
 			name:    "invalid timestamp returns error",
 			wantErr: true,
@@ -81,3 +94,61 @@ func Test_ParseRecordPosition(t *testing.T) {
 		})
 	}
 }
+
+func Test_ParseCompositePosition(t *testing.T) {
+	date := time.Date(2020, 1, 1, 4, 12, 27, 0, time.UTC)
+	var positionTests = []struct {
+		name    string
+		wantErr bool
+		in      sdk.Position
+		out     CompositePosition
+	}{
+		{
+			name:    "Nil Position return empty Position",
+			wantErr: false,
+			in:      nil,
+			out:     CompositePosition{},
+		},
+		{
+			name:    "Malformed Position",
+			wantErr: true,
+			in:      []byte("s_1_1_1_1"),
+			out:     CompositePosition{},
+		},
+		{
+			name:    "single object position",
+			wantErr: false,
+			in:      []byte("{\"key\":\"test\",\"createdAt\":\"2020-01-01T04:12:27Z\",\"updatedAt\":\"2020-01-01T04:12:27Z\",\"type\":1}"),
+			out: CompositePosition{
+				Object: "leads",
+				Positions: map[string]Position{
+					"leads": {Key: "test", Type: TypeCDC, CreatedAt: date, UpdatedAt: date},
+				},
+			},
+		},
+		{
+			name:    "composite position",
+			wantErr: false,
+			in: []byte("{\"object\":\"activities\",\"positions\":{" +
+				"\"leads\":{\"key\":\"test\",\"createdAt\":\"2020-01-01T04:12:27Z\",\"updatedAt\":\"2020-01-01T04:12:27Z\",\"type\":1}," +
+				"\"activities\":{\"key\":\"42\",\"createdAt\":\"2020-01-01T04:12:27Z\",\"updatedAt\":\"2020-01-01T04:12:27Z\",\"type\":1,\"pageToken\":\"token\"}}}"),
+			out: CompositePosition{
+				Object: "activities",
+				Positions: map[string]Position{
+					"leads":      {Key: "test", Type: TypeCDC, CreatedAt: date, UpdatedAt: date},
+					"activities": {Key: "42", Type: TypeCDC, CreatedAt: date, UpdatedAt: date, PageToken: "token"},
+				},
+			},
+		},
+	}
+	for _, tt := range positionTests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseCompositePosition(tt.in, "leads")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCompositePosition error = %v , wantErr = %v", err, tt.wantErr)
+			} else if !reflect.DeepEqual(p, tt.out) {
+				t.Errorf("ParseCompositePosition(): Got : %+v,Expected : %+v", p, tt.out)
+			}
+		})
+	}
+}
@@ -15,8 +15,10 @@
 package source
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/SpeakData/minimarketo"
 	sdk "github.com/conduitio/conduit-connector-sdk"
@@ -27,17 +29,33 @@ import (
 	"github.com/rustiever/conduit-connector-marketo/source/position"
 )
 
+// assets maps the asset objects to their Asset API types.
+var assets = map[string]string{
+	config.ObjectPrograms:       marketoclient.AssetPrograms,
+	config.ObjectSmartCampaigns: marketoclient.AssetSmartCampaigns,
+	config.ObjectEmails:         marketoclient.AssetEmails,
+	config.ObjectForms:          marketoclient.AssetForms,
+	config.ObjectLandingPages:   marketoclient.AssetLandingPages,
+	config.ObjectFolders:        marketoclient.AssetFolders,
+	config.ObjectTags:           marketoclient.AssetTagTypes,
+}
+
 // Source connector
 type Source struct {
 	sdk.UnimplementedSource
 	config   config.SourceConfig
 	client   marketoclient.Client
 	iterator Iterator
+	cancel   context.CancelFunc // cancels the calls of the client waiting for its rate limiter
+
+	mu       sync.Mutex     // guards inflight, since Read and Ack can be called concurrently
+	inflight []sdk.Position // positions of read records waiting for an ack, oldest first
 }
 
 type Iterator interface {
 	HasNext(ctx context.Context) bool
 	Next(ctx context.Context) (sdk.Record, error)
+	Ack(ctx context.Context, p position.CompositePosition) error
 	Stop()
 }
 
@@ -76,7 +94,67 @@ func (s *Source) Parameters() map[string]sdk.Parameter {
 		config.KeyFields: {
 			Required:    false,
 			Default:     "id, createdAt, updatedAt, firstName, lastName, email",
-			Description: "The fields to be pulled from Marketo",
+			Description: "The fields to be pulled from Marketo. The fields of one of several objects can be set with `fields.<object>`, like `fields.customObject`.",
+		},
+		config.KeyCDCMode: {
+			Required:    false,
+			Default:     config.CDCModeFull,
+			Description: "The CDC mode, `full` fetches changed leads, `partial` emits changed fields only.",
+		},
+		config.KeyCDCActivityTypes: {
+			Required:    false,
+			Default:     "",
+			Description: "Comma separated activity type IDs or names which trigger a lead refresh in CDC.",
+		},
+		config.KeyObject: {
+			Required:    false,
+			Default:     config.ObjectLeads,
+			Description: "Comma separated Marketo objects to read, `leads`, `activities`, `programMembers`, `customObject`, `customObject.<apiName>`, `opportunities`, `opportunityRoles`, `companies`, `namedAccounts`, `listMembers`, `programs`, `smartCampaigns`, `emails`, `forms`, `landingPages`, `folders` or `tags`.",
+		},
+		config.KeyActivityTypes: {
+			Required:    false,
+			Default:     "All activity types.",
+			Description: "Comma separated activity type IDs or names to read when the object is `activities`.",
+		},
+		config.KeyProgramIDs: {
+			Required:    false,
+			Default:     "",
+			Description: "Comma separated IDs of the programs to read the members of, required when the object is `programMembers`.",
+		},
+		config.KeyListIDs: {
+			Required:    false,
+			Default:     "",
+			Description: "Comma separated IDs of the static lists to read the members of, required when the object is `listMembers`.",
+		},
+		config.KeyCustomObjectName: {
+			Required:    false,
+			Default:     "",
+			Description: "The API name of the custom object to read, required when the object is `customObject`. Several custom objects can be read with `customObject.<apiName>` objects instead.",
+		},
+		config.KeyFilterType: {
+			Required:    false,
+			Default:     "",
+			Description: "The field to filter the records by, required when the object is `opportunities`, `opportunityRoles`, `companies` or `namedAccounts`. The filter of one of several objects can be set with `filterType.<object>`, like `filterType.companies`.",
+		},
+		config.KeyFilterValues: {
+			Required:    false,
+			Default:     "",
+			Description: "Comma separated values of the filter field matching the records to read, required with `filterType`. The values of one of several objects can be set with `filterValues.<object>`.",
+		},
+		config.KeyRefreshPeriod: {
+			Required:    false,
+			Default:     "24h",
+			Description: "The period of full refreshes when the object is `companies`, `namedAccounts` or an asset object like `programs`. The period of one of several objects can be set with `refreshPeriod.<object>`.",
+		},
+		config.KeyExportPeriod: {
+			Required:    false,
+			Default:     "1h",
+			Description: "The period of the bulk exports reading the updated records when the object is `customObject`.",
+		},
+		config.KeyAssetPollingPeriod: {
+			Required:    false,
+			Default:     "1h",
+			Description: "The polling period of the asset objects which can't be listed by updatedAt, `forms`, `landingPages`, `folders` and `tags`.",
 		},
 	}
 }
@@ -101,33 +179,80 @@ func (s *Source) Configure(ctx context.Context, cfg map[string]string) error {
 func (s *Source) Open(ctx context.Context, pos sdk.Position) error {
 	logger := sdk.Logger(ctx).With().Str("Class", "Source").Str("Method", "Open").Logger()
 	logger.Trace().Msg("Starting Open the Source Connector...")
-	p, err := position.ParseRecordPosition(pos)
+	// positions of a single object are positions of the first object, see position.ParseCompositePosition.
+	p, err := position.ParseCompositePosition(pos, s.config.Objects[0])
 	if err != nil {
 		logger.Error().Stack().Err(err).Msg("Error While parsing the Position")
 		return fmt.Errorf("couldn't parse the position: %w", err)
 	}
-	logger.Info().Msgf("Requested fields: %s", s.config.Fields)
+	logger.Info().Msgf("Requested objects: %s, fields: %v", s.config.Objects, s.config.Fields)
 
-	config := minimarketo.ClientConfig{
+	clientConfig := minimarketo.ClientConfig{
 		ID:       s.config.ClientID,
 		Secret:   s.config.ClientSecret,
 		Endpoint: s.config.ClientEndpoint,
 		Debug:    false,
 	}
-	s.client, err = marketoclient.NewClient(config)
+	s.client, err = marketoclient.NewClient(clientConfig)
 	if err != nil {
 		logger.Error().Stack().Err(err).Msg("Error While Creating the Marketo Client")
 		return fmt.Errorf("couldn't create the marketo client: %w", err)
 	}
-	s.iterator, err = iterator.NewCombinedIterator(ctx, s.config.ClientEndpoint, s.config.PollingPeriod, s.client, p, s.config.Fields, s.config.SnapshotInitialDate)
+	// the iterators of all objects share the client, and so its rate limiter.
+	var clientCtx context.Context
+	clientCtx, s.cancel = context.WithCancel(context.Background())
+	s.client = s.client.WithRateLimit(clientCtx, marketoclient.NewRateLimiter())
+	s.iterator, err = iterator.NewMultiIterator(ctx, p, s.config.Objects, s.newIterator)
 	if err != nil {
-		logger.Error().Stack().Err(err).Msg("Error while create a combined iterator")
-		return fmt.Errorf("couldn't create a combined iterator: %w", err)
+		logger.Error().Stack().Err(err).Msg("Error while create a multi iterator")
+		return fmt.Errorf("couldn't create a multi iterator: %w", err)
 	}
 	logger.Trace().Msg("Successfully Created the Source Connector")
 	return nil
 }
 
+// returns the iterator of the given object, starting from the given position.
+func (s *Source) newIterator(ctx context.Context, object string, p position.Position) (*iterator.CombinedIterator, error) {
+	if asset, ok := assets[object]; ok {
+		pollingPeriod := s.config.PollingPeriod
+		if !marketoclient.AssetsFilteredByUpdatedAt[asset] {
+			// these assets are listed as a whole by every poll.
+			pollingPeriod = s.config.AssetPollingPeriod
+		}
+		return iterator.NewCombinedAssetIterator(ctx, pollingPeriod, s.config.RefreshPeriods[object], s.client, p, asset)
+	}
+	fields := s.config.Fields[object]
+	filter := s.config.Filters[object]
+	switch config.ObjectType(object) {
+	case config.ObjectProgramMembers:
+		return iterator.NewCombinedProgramMemberIterator(ctx, s.config.ClientEndpoint, s.config.PollingPeriod, s.client, p, fields, s.config.ProgramIDs)
+	case config.ObjectCustomObject:
+		return iterator.NewCombinedCustomObjectIterator(ctx, s.config.ClientEndpoint, s.config.ExportPeriod, s.client, p, fields, s.config.SnapshotInitialDate, s.config.CustomObjectNames[object])
+	case config.ObjectOpportunities:
+		return iterator.NewCombinedQueryIterator(ctx, s.config.PollingPeriod, s.client, p, marketoclient.QueryObjectOpportunities, filter.Type, filter.Values, fields)
+	case config.ObjectOpportunityRoles:
+		return iterator.NewCombinedQueryIterator(ctx, s.config.PollingPeriod, s.client, p, marketoclient.QueryObjectOpportunityRoles, filter.Type, filter.Values, fields)
+	case config.ObjectListMembers:
+		return iterator.NewCombinedListMemberIterator(ctx, s.config.PollingPeriod, s.client, p, s.config.ListIDs)
+	case config.ObjectCompanies:
+		return iterator.NewCombinedRefreshIterator(ctx, s.config.PollingPeriod, s.config.RefreshPeriods[object], s.client, p, marketoclient.QueryObjectCompanies, filter.Type, filter.Values, fields)
+	case config.ObjectNamedAccounts:
+		return iterator.NewCombinedRefreshIterator(ctx, s.config.PollingPeriod, s.config.RefreshPeriods[object], s.client, p, marketoclient.QueryObjectNamedAccounts, filter.Type, filter.Values, fields)
+	case config.ObjectActivities:
+		activityTypes, err := s.client.ResolveActivityTypeIDs(s.config.ActivityTypes)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't resolve the activity types: %w", err)
+		}
+		return iterator.NewCombinedActivityIterator(ctx, s.config.ClientEndpoint, s.config.PollingPeriod, s.client, p, s.config.SnapshotInitialDate, activityTypes)
+	default:
+		activityTypes, err := s.client.ResolveActivityTypeIDs(s.config.CDCActivityTypes)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't resolve the CDC activity types: %w", err)
+		}
+		return iterator.NewCombinedIterator(ctx, s.config.ClientEndpoint, s.config.PollingPeriod, s.client, p, fields, s.config.SnapshotInitialDate, s.config.CDCMode == config.CDCModePartial, activityTypes)
+	}
+}
+
 // Read gets the next record from the Marketo Instance
 func (s *Source) Read(ctx context.Context) (sdk.Record, error) {
 	logger := sdk.Logger(ctx).With().Str("Class", "Source").Str("Method", "Read").Logger()
@@ -143,15 +268,35 @@ func (s *Source) Read(ctx context.Context) (sdk.Record, error) {
 		logger.Error().Stack().Err(err).Msg("Error while fetching the records")
 		return sdk.Record{}, fmt.Errorf("couldn't fetch the records: %w", err)
 	}
+	s.mu.Lock()
+	s.inflight = append(s.inflight, record.Position)
+	s.mu.Unlock()
 	return record, nil
 }
 
+// Ack marks the oldest in-flight record as processed and lets the iterator advance its checkpoint.
+// Acks are expected in the order records were read.
 func (s *Source) Ack(ctx context.Context, pos sdk.Position) error {
 	sdk.Logger(ctx).Debug().Str("position", string(pos)).Msg("got ack")
-	return nil
+	s.mu.Lock()
+	if len(s.inflight) == 0 || !bytes.Equal(s.inflight[0], pos) {
+		s.mu.Unlock()
+		return fmt.Errorf("unexpected ack for position %s", pos)
+	}
+	s.inflight = s.inflight[1:]
+	s.mu.Unlock()
+
+	p, err := position.ParseCompositePosition(pos, s.config.Objects[0])
+	if err != nil {
+		return fmt.Errorf("couldn't parse the position: %w", err)
+	}
+	return s.iterator.Ack(ctx, p)
 }
 
 func (s *Source) Teardown(ctx context.Context) error {
+	if s.cancel != nil {
+		s.cancel()
+	}
 	if s.iterator != nil {
 		s.iterator.Stop()
 	}
@@ -350,7 +350,7 @@ func TestOpenSource_InvalidPositionType(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = source.Open(ctx, p)
-	expectedErr := "couldn't create a combined iterator: invalid position type (2)"
+	expectedErr := "couldn't create a multi iterator: error creating iterator of leads invalid position type (2)"
 	if !strings.Contains(err.Error(), expectedErr) {
 		t.Errorf("Expected want error is %q but got %v", expectedErr, err)
 	}
@@ -27,8 +27,8 @@ var version = "v0.0.0-dev"
 func Specification() sdk.Specification {
 	return sdk.Specification{
 		Name:        "marketo",
-		Summary:     "A Adobe Marketo source connector, which syncs Leads from a given Marketo instance.",
-		Description: "Marketo source connector connects to Marketo instance through the REST API with provided configuration, using `clientID` and `clientSecret`. Once connector is started `Configure` method is called to parse configurations and validate them. After that `Open` method is called to establish connection to Marketo instance with provided position. Once connection is established `Read` method is called which calls current iterator's `Next` method to fetch next record. `Teardown` is called when connector is stopped.",
+		Summary:     "A Adobe Marketo source and destination connector, which syncs Leads from and to a given Marketo instance.",
+		Description: "Marketo source connector connects to Marketo instance through the REST API with provided configuration, using `clientID` and `clientSecret`. Once connector is started `Configure` method is called to parse configurations and validate them. After that `Open` method is called to establish connection to Marketo instance with provided position. Once connection is established `Read` method is called which calls current iterator's `Next` method to fetch next record. `Teardown` is called when connector is stopped. The destination connector writes records to leads, creating or updating the lead matching the `lookupField` of each record.",
 		Version:     version,
 		Author:      "Sharan",
 	}